@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const defaultBatchRows = 1000
+
+// FetchState tracks incremental fetch progress for a channel. LastTS is the
+// watermark of a completed fetch. While a fetch is in progress, Cursor holds
+// the next history page, OldestTS the lower bound the fetch started from and
+// LatestTS the newest message seen so far, so an interrupted run resumes
+// where it left off instead of skipping unfetched history. UpdatedAt is when
+// the last page was committed and CompletedAt when a fetch last finished.
+type FetchState struct {
+	ChannelID   string
+	LastTS      string
+	OldestTS    string
+	LatestTS    string
+	Cursor      string
+	UpdatedAt   string
+	CompletedAt string
+}
+
+func (s FetchState) InProgress() bool {
+	return s.Cursor != ""
+}
+
+func (d *Database) GetFetchState(channelID string) (FetchState, error) {
+	state := FetchState{ChannelID: channelID}
+	err := d.db.QueryRow(d.rebind(`
+		SELECT last_ts, oldest_ts, latest_ts, cursor, COALESCE(CAST(updated_at AS TEXT), ''),
+			COALESCE(CAST(completed_at AS TEXT), '')
+		FROM fetch_state WHERE channel_id = ?`), channelID).
+		Scan(&state.LastTS, &state.OldestTS, &state.LatestTS, &state.Cursor, &state.UpdatedAt, &state.CompletedAt)
+	if err == sql.ErrNoRows {
+		return state, nil
+	}
+	return state, err
+}
+
+// CompleteFetch records that a fetch of the channel finished successfully.
+func (d *Database) CompleteFetch(channelID string) error {
+	return d.exec("complete_fetch", `
+		INSERT INTO fetch_state (channel_id, completed_at) VALUES (?, CURRENT_TIMESTAMP)
+		ON CONFLICT(channel_id) DO UPDATE SET completed_at = excluded.completed_at`, channelID)
+}
+
+// WriteBatch groups writes into transactions using prepared statements.
+// Rows are committed every maxRows writes to bound transaction size; the
+// fetch state is only written by Commit, so it is never persisted ahead of
+// the rows it describes.
+type WriteBatch struct {
+	db      *Database
+	maxRows int
+
+	tx           *sql.Tx
+	saveMessage  *sql.Stmt
+	saveReply    *sql.Stmt
+	saveUser     *sql.Stmt
+	saveUserTeam *sql.Stmt
+	pendingState *FetchState
+	commitCheck  func() error
+	rows         int
+}
+
+func (d *Database) BeginBatch() (Batch, error) {
+	b := &WriteBatch{db: d, maxRows: defaultBatchRows}
+	if err := b.begin(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *WriteBatch) begin() error {
+	tx, err := b.db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmts := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&b.saveMessage, saveMessageSQL},
+		{&b.saveReply, saveReplySQL},
+		{&b.saveUser, saveUserSQL},
+		{&b.saveUserTeam, saveUserTeamSQL},
+	}
+	for _, s := range stmts {
+		stmt, err := tx.Prepare(b.db.rebind(s.query))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		*s.stmt = stmt
+	}
+
+	b.tx = tx
+	b.rows = 0
+	return nil
+}
+
+func (b *WriteBatch) exec(operation string, stmt *sql.Stmt, args ...interface{}) error {
+	start := time.Now()
+	if _, err := stmt.Exec(args...); err != nil {
+		return err
+	}
+	observeDuration(metrics.dbWriteDuration, start, operation)
+
+	b.rows++
+	if b.rows >= b.maxRows {
+		if err := b.checkCommit(); err != nil {
+			return err
+		}
+		if err := b.tx.Commit(); err != nil {
+			return err
+		}
+		return b.begin()
+	}
+	return nil
+}
+
+func (b *WriteBatch) SetCommitCheck(check func() error) {
+	b.commitCheck = check
+}
+
+// checkCommit runs the commit check, rolling the transaction back if it
+// fails.
+func (b *WriteBatch) checkCommit() error {
+	if b.commitCheck == nil {
+		return nil
+	}
+	if err := b.commitCheck(); err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+func (b *WriteBatch) SaveMessage(ts, channelID, userID, text, threadTS string, replyCount int, teamID string) error {
+	return b.exec("batch_save_message", b.saveMessage, ts, channelID, userID, text, threadTS, replyCount, teamID)
+}
+
+func (b *WriteBatch) SaveReply(ts, threadTS, channelID, userID, text, teamID string) error {
+	return b.exec("batch_save_reply", b.saveReply, ts, threadTS, channelID, userID, text, teamID)
+}
+
+func (b *WriteBatch) SaveUser(id, name, realName, displayName, email, profileImage, tz, teamID string) error {
+	return b.exec("batch_save_user", b.saveUser, id, name, realName, displayName, email, profileImage, tz, teamID)
+}
+
+func (b *WriteBatch) SaveUserTeams(userID string, teamIDs []string) error {
+	for _, teamID := range teamIDs {
+		if err := b.exec("batch_save_user_team", b.saveUserTeam, userID, teamID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetFetchState records the fetch state to be written atomically with the
+// final transaction of the batch.
+func (b *WriteBatch) SetFetchState(state FetchState) {
+	b.pendingState = &state
+}
+
+func (b *WriteBatch) Commit() error {
+	defer observeDuration(metrics.dbWriteDuration, time.Now(), "batch_commit")
+
+	if err := b.checkCommit(); err != nil {
+		return err
+	}
+	if b.pendingState != nil {
+		s := b.pendingState
+		if _, err := b.tx.Exec(b.db.rebind(saveFetchStateSQL), s.ChannelID, s.LastTS, s.OldestTS, s.LatestTS, s.Cursor); err != nil {
+			b.tx.Rollback()
+			return err
+		}
+	}
+	return b.tx.Commit()
+}
+
+func (b *WriteBatch) Rollback() error {
+	return b.tx.Rollback()
+}
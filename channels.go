@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// channelTypes maps the -types values to conversations.list types.
+var channelTypes = map[string]string{
+	"public":  "public_channel",
+	"private": "private_channel",
+	"mpim":    "mpim",
+	"im":      "im",
+}
+
+// parseChannelTypes turns a comma-separated -types value into
+// conversations.list types. Slack's own type names are accepted too.
+func parseChannelTypes(value string) ([]string, error) {
+	var types []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if slackType, ok := channelTypes[name]; ok {
+			name = slackType
+		}
+		switch name {
+		case "public_channel", "private_channel", "mpim", "im":
+			types = append(types, name)
+		default:
+			return nil, fmt.Errorf("unknown channel type %q (use public, private, mpim or im)", name)
+		}
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no channel types given")
+	}
+	return types, nil
+}
+
+type ChannelListOptions struct {
+	Types           []string
+	IncludeArchived bool
+}
+
+// ChannelListing is a conversation as Slack lists it, together with what the
+// local archive holds for it.
+type ChannelListing struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Members    int    `json:"members"`
+	IsMember   bool   `json:"is_member"`
+	IsArchived bool   `json:"is_archived"`
+
+	// InArchive is set once the channel has been fetched into the store.
+	InArchive       bool       `json:"in_archive"`
+	LastFetched     string     `json:"last_fetched,omitempty"`
+	LatestTS        string     `json:"latest_ts,omitempty"`
+	FetchInProgress bool       `json:"fetch_in_progress,omitempty"`
+	AutoJoinedAt    *time.Time `json:"auto_joined_at,omitempty"`
+}
+
+// ListChannels lists the workspace's conversations of the given types,
+// sorted by name, and marks the ones in the local archive.
+func ListChannels(ctx context.Context, client SlackAPI, store Store, options ChannelListOptions) ([]ChannelListing, error) {
+	channels, err := client.GetConversations(ctx, options.Types, !options.IncludeArchived)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list channels: %w", err)
+	}
+
+	local, err := store.GetChannels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channels: %w", err)
+	}
+	joins, err := store.GetChannelJoins()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel joins: %w", err)
+	}
+
+	listings := make([]ChannelListing, 0, len(channels))
+	for _, channel := range channels {
+		listing := ChannelListing{
+			ID:         channel.ID,
+			Name:       channel.Name,
+			Type:       channelType(channel),
+			Members:    channel.NumMembers,
+			IsMember:   channel.IsMember,
+			IsArchived: channel.IsArchived,
+		}
+		if channel.IsIM {
+			listing.Name = channel.User
+		}
+		if joinedAt, ok := joins[channel.ID]; ok {
+			listing.AutoJoinedAt = &joinedAt
+		}
+
+		if _, ok := local[channel.ID]; ok {
+			state, err := store.GetFetchState(channel.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get fetch state: %w", err)
+			}
+			listing.InArchive = true
+			listing.LastFetched = state.UpdatedAt
+			listing.LatestTS = state.LastTS
+			listing.FetchInProgress = state.InProgress()
+		}
+		listings = append(listings, listing)
+	}
+
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].Name != listings[j].Name {
+			return listings[i].Name < listings[j].Name
+		}
+		return listings[i].ID < listings[j].ID
+	})
+	return listings, nil
+}
+
+func channelType(channel slack.Channel) string {
+	switch {
+	case channel.IsIM:
+		return "im"
+	case channel.IsMpIM:
+		return "mpim"
+	case channel.IsPrivate:
+		return "private"
+	default:
+		return "public"
+	}
+}
+
+func (l ChannelListing) displayName() string {
+	if l.Type == "im" {
+		return "@" + l.Name
+	}
+	return "#" + l.Name
+}
+
+// localStatus describes what the archive holds for the channel.
+func (l ChannelListing) localStatus() string {
+	switch {
+	case !l.InArchive:
+		return "-"
+	case l.FetchInProgress:
+		return "fetching since " + l.LastFetched
+	case l.LastFetched != "":
+		return "fetched " + l.LastFetched
+	default:
+		return "stored"
+	}
+}
+
+func WriteChannelsText(w io.Writer, listings []ChannelListing) error {
+	members, archived := 0, 0
+	fmt.Fprintf(w, "%-28s %-12s %-8s %7s %-6s %-9s %s\n", "Channel", "ID", "Type", "Members", "Bot", "Status", "Local Archive")
+	for _, l := range listings {
+		bot := "-"
+		if l.IsMember {
+			bot = "member"
+			members++
+		}
+		status := "active"
+		if l.IsArchived {
+			status = "archived"
+		}
+		if l.InArchive {
+			archived++
+		}
+		fmt.Fprintf(w, "%-28s %-12s %-8s %7d %-6s %-9s %s\n", l.displayName(), l.ID, l.Type, l.Members, bot, status, l.localStatus())
+	}
+	fmt.Fprintf(w, "\n%s, the bot is a member of %d, %d in the local archive\n", plural(len(listings), "channel"), members, archived)
+	return nil
+}
+
+func WriteChannelsJSON(w io.Writer, listings []ChannelListing) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(listings)
+}
+
+func WriteChannelsCSV(w io.Writer, listings []ChannelListing) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "name", "type", "members", "is_member", "is_archived", "in_archive", "last_fetched", "latest_ts", "auto_joined_at"})
+	for _, l := range listings {
+		joinedAt := ""
+		if l.AutoJoinedAt != nil {
+			joinedAt = l.AutoJoinedAt.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			l.ID, l.Name, l.Type, strconv.Itoa(l.Members),
+			strconv.FormatBool(l.IsMember), strconv.FormatBool(l.IsArchived), strconv.FormatBool(l.InArchive),
+			l.LastFetched, l.LatestTS, joinedAt,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// channelIDPattern matches Slack conversation IDs; channel names are always
+// lower case, so the two cannot be confused.
+var channelIDPattern = regexp.MustCompile(`^[CGD][A-Z0-9]{6,}$`)
+
+func isChannelID(value string) bool {
+	return channelIDPattern.MatchString(value)
+}
+
+// channelResolver turns channel names given with -channel into IDs using
+// conversations.list. The list is only fetched if a name is given.
+type channelResolver struct {
+	client SlackAPI
+	byName map[string][]string
+}
+
+func newChannelResolver(client SlackAPI) *channelResolver {
+	return &channelResolver{client: client}
+}
+
+func (r *channelResolver) Resolve(ctx context.Context, values []string) ([]string, error) {
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimPrefix(value, "#")
+		if isChannelID(value) {
+			ids = append(ids, value)
+			continue
+		}
+
+		if r.byName == nil {
+			channels, err := r.client.GetConversations(ctx, []string{"public_channel", "private_channel"}, false)
+			if err != nil && isSlackError(err, "missing_scope") {
+				// Without groups:read only public channels can be listed.
+				channels, err = r.client.GetConversations(ctx, []string{"public_channel"}, false)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list channels to resolve #%s: %w", value, err)
+			}
+			r.byName = make(map[string][]string, len(channels))
+			for _, channel := range channels {
+				r.byName[channel.Name] = append(r.byName[channel.Name], channel.ID)
+			}
+		}
+
+		id, err := pickChannel(value, r.byName[strings.ToLower(value)])
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// resolveLocalChannel turns a channel name into the ID of the channel with
+// that name in the archive. IDs, including ones not in the archive, are
+// returned unchanged.
+func resolveLocalChannel(store Store, value string) (string, error) {
+	value = strings.TrimPrefix(value, "#")
+	if value == "" || isChannelID(value) {
+		return value, nil
+	}
+
+	channels, err := store.GetChannels()
+	if err != nil {
+		return "", fmt.Errorf("failed to get channels: %w", err)
+	}
+	if _, ok := channels[value]; ok {
+		return value, nil
+	}
+
+	var ids []string
+	for id, name := range channels {
+		if strings.EqualFold(name, value) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return pickChannel(value, ids)
+}
+
+func pickChannel(name string, ids []string) (string, error) {
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("channel #%s not found; run -mode channels to list channels and their IDs", name)
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("several channels are named #%s (%s); pass the channel ID instead", name, strings.Join(ids, ", "))
+	}
+}
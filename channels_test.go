@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/takutakahashi/slack-all-contexts/fakeslack"
+)
+
+func TestParseChannelTypes(t *testing.T) {
+	for value, want := range map[string][]string{
+		"public":                 {"public_channel"},
+		"public, private,,mpim":  {"public_channel", "private_channel", "mpim"},
+		"im,private_channel":     {"im", "private_channel"},
+		"public_channel,public ": {"public_channel", "public_channel"},
+	} {
+		if got, err := parseChannelTypes(value); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("parseChannelTypes(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", " , ", "public,dm"} {
+		if _, err := parseChannelTypes(value); err == nil {
+			t.Errorf("parseChannelTypes(%q): want an error", value)
+		}
+	}
+}
+
+func TestChannelType(t *testing.T) {
+	im, mpim, private := slack.Channel{}, slack.Channel{}, slack.Channel{}
+	im.IsIM = true
+	mpim.IsMpIM = true
+	mpim.IsPrivate = true
+	private.IsPrivate = true
+
+	for _, tc := range []struct {
+		channel slack.Channel
+		want    string
+	}{{im, "im"}, {mpim, "mpim"}, {private, "private"}, {slack.Channel{}, "public"}} {
+		if got := channelType(tc.channel); got != tc.want {
+			t.Errorf("channelType = %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestIsChannelID(t *testing.T) {
+	for value, want := range map[string]bool{
+		"C01ABCDEF": true,
+		"G0123456":  true,
+		"D0ABCDEF1": true,
+		"general":   false,
+		"C123":      false,
+		"c01abcdef": false,
+		"U01ABCDEF": false,
+		"C01ABC-EF": false,
+	} {
+		if got := isChannelID(value); got != want {
+			t.Errorf("isChannelID(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestResolveLocalChannel(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		seedStore(t, store)
+		// Names are unique in a workspace, but not across the workspaces of
+		// an archive, and a renamed channel keeps its old name until fetched.
+		store.SaveChannel("C0DEPLOY2", "deploys", "T2")
+		store.SaveChannel("C0DEPLOY1", "deploys", "T1")
+
+		for value, want := range map[string]string{
+			"":          "",
+			"general":   "C1",
+			"#general":  "C1",
+			"#General":  "C1",
+			"C1":        "C1",
+			"C0DEPLOY1": "C0DEPLOY1",
+			// IDs are passed through even when they are not archived.
+			"C0MISSING": "C0MISSING",
+		} {
+			if got, err := resolveLocalChannel(store, value); err != nil || got != want {
+				t.Errorf("resolveLocalChannel(%q) = %q, %v; want %q", value, got, err, want)
+			}
+		}
+
+		if _, err := resolveLocalChannel(store, "#deploys"); err == nil || !strings.Contains(err.Error(), "several channels are named #deploys (C0DEPLOY1, C0DEPLOY2)") {
+			t.Errorf("ambiguous name error = %v, want both IDs in order", err)
+		}
+		if _, err := resolveLocalChannel(store, "missing"); err == nil || !strings.Contains(err.Error(), "channel #missing not found") {
+			t.Errorf("unknown name error = %v", err)
+		}
+	})
+}
+
+func TestPickChannel(t *testing.T) {
+	if id, err := pickChannel("general", []string{"C1"}); err != nil || id != "C1" {
+		t.Errorf("pickChannel with one match = %q, %v", id, err)
+	}
+	if _, err := pickChannel("general", nil); err == nil || !strings.Contains(err.Error(), "run -mode channels") {
+		t.Errorf("pickChannel with no match error = %v", err)
+	}
+	if _, err := pickChannel("general", []string{"C1", "C2"}); err == nil || !strings.Contains(err.Error(), "(C1, C2); pass the channel ID instead") {
+		t.Errorf("pickChannel with two matches error = %v", err)
+	}
+}
+
+func channelFixtures() fakeslack.Fixtures {
+	return fakeslack.Fixtures{
+		Team: fakeslack.Team{ID: "T1", Name: "Acme", Domain: "acme"},
+		Channels: []fakeslack.Channel{
+			{ID: "C0GENERAL", Name: "general", IsMember: true, NumMembers: 12},
+			{ID: "C0RANDOM1", Name: "random", NumMembers: 3},
+			{ID: "C0OLDSTUF", Name: "old", IsArchived: true},
+			{ID: "G0SECRET1", Name: "secret", IsPrivate: true, IsMember: true, NumMembers: 2},
+			// An archived channel that shares a name with an active one.
+			{ID: "C0RANDOM0", Name: "random", IsArchived: true},
+		},
+	}
+}
+
+func TestChannelResolver(t *testing.T) {
+	ctx := context.Background()
+	server, client := newFakeSlack(t, channelFixtures())
+
+	resolver := newChannelResolver(client)
+	ids, err := resolver.Resolve(ctx, []string{"C0NOTLISTED", "#general", "secret", "General"})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if want := []string{"C0NOTLISTED", "C0GENERAL", "G0SECRET1", "C0GENERAL"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Resolve = %v, want %v", ids, want)
+	}
+	// The channel list is fetched once, and only because names were given.
+	if got := server.Calls("conversations.list"); got != 1 {
+		t.Errorf("conversations.list calls = %d, want 1", got)
+	}
+	if _, err := newChannelResolver(client).Resolve(ctx, []string{"C0GENERAL"}); err != nil || server.Calls("conversations.list") != 1 {
+		t.Errorf("resolving IDs only = %v with %d list calls, want no list call", err, server.Calls("conversations.list"))
+	}
+
+	_, err = newChannelResolver(client).Resolve(ctx, []string{"random"})
+	if err == nil || !strings.Contains(err.Error(), "several channels are named #random (C0RANDOM1, C0RANDOM0)") {
+		t.Errorf("ambiguous name error = %v", err)
+	}
+	if _, err := newChannelResolver(client).Resolve(ctx, []string{"nope"}); err == nil || !strings.Contains(err.Error(), "#nope not found") {
+		t.Errorf("unknown name error = %v", err)
+	}
+
+	// Without groups:read, names are resolved among public channels.
+	server.FailNext("conversations.list", "missing_scope")
+	if ids, err := newChannelResolver(client).Resolve(ctx, []string{"general"}); err != nil || ids[0] != "C0GENERAL" {
+		t.Errorf("Resolve without groups:read = %v, %v", ids, err)
+	}
+	server.FailNext("conversations.list", "missing_scope")
+	if _, err := newChannelResolver(client).Resolve(ctx, []string{"secret"}); err == nil || !strings.Contains(err.Error(), "#secret not found") {
+		t.Errorf("private channel without groups:read error = %v", err)
+	}
+}
+
+func TestListChannels(t *testing.T) {
+	ctx := context.Background()
+	_, client := newFakeSlack(t, channelFixtures())
+
+	forEachStore(t, func(t *testing.T, store Store) {
+		store.SaveChannel("C0GENERAL", "general", "T1")
+		store.SaveChannel("G0SECRET1", "secret", "T1")
+		// A batch carries the fetch state of one channel.
+		for _, state := range []FetchState{
+			{ChannelID: "C0GENERAL", LastTS: "1704067200.000100"},
+			{ChannelID: "G0SECRET1", Cursor: "page-2"},
+		} {
+			batch, err := store.BeginBatch()
+			if err != nil {
+				t.Fatal(err)
+			}
+			batch.SetFetchState(state)
+			if err := batch.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		joinedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		store.SaveChannelJoin("C0RANDOM1", joinedAt)
+
+		listings, err := ListChannels(ctx, client, store, ChannelListOptions{Types: []string{"public_channel", "private_channel"}})
+		if err != nil {
+			t.Fatalf("ListChannels failed: %v", err)
+		}
+		var ids []string
+		for _, l := range listings {
+			ids = append(ids, l.ID)
+		}
+		if want := []string{"C0GENERAL", "C0RANDOM1", "G0SECRET1"}; !reflect.DeepEqual(ids, want) {
+			t.Fatalf("listings = %v, want unarchived channels sorted by name", ids)
+		}
+
+		general, random, secret := listings[0], listings[1], listings[2]
+		if !general.InArchive || general.LastFetched == "" || general.LatestTS != "1704067200.000100" || general.FetchInProgress || general.Members != 12 || !general.IsMember {
+			t.Errorf("general = %+v, want a fetched, archived channel", general)
+		}
+		if random.InArchive || random.AutoJoinedAt == nil || !random.AutoJoinedAt.Equal(joinedAt) || random.Type != "public" {
+			t.Errorf("random = %+v, want an auto-joined channel not in the archive", random)
+		}
+		if !secret.FetchInProgress || secret.Type != "private" {
+			t.Errorf("secret = %+v, want a private channel being fetched", secret)
+		}
+
+		listings, err = ListChannels(ctx, client, store, ChannelListOptions{Types: []string{"public_channel"}, IncludeArchived: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listings) != 4 || listings[1].ID != "C0OLDSTUF" || listings[2].ID != "C0RANDOM0" {
+			t.Errorf("listings with archived = %+v, want same names ordered by ID", listings)
+		}
+	})
+}
+
+func TestWriteChannels(t *testing.T) {
+	joinedAt := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	listings := []ChannelListing{
+		{ID: "C0GENERAL", Name: "general", Type: "public", Members: 12, IsMember: true, InArchive: true, LastFetched: "2024-01-02 03:04:05", LatestTS: "1704067200.000100"},
+		{ID: "C0RANDOM1", Name: "random", Type: "public", Members: 3, IsArchived: true, AutoJoinedAt: &joinedAt},
+		{ID: "D0ALICE01", Name: "U1", Type: "im", InArchive: true, FetchInProgress: true, LastFetched: "2024-01-03 00:00:00"},
+		{ID: "G0SECRET1", Name: "secret", Type: "private", InArchive: true},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteChannelsText(&buf, listings); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"#general                     C0GENERAL    public        12 member active    fetched 2024-01-02 03:04:05\n",
+		"#random                      C0RANDOM1    public         3 -      archived  -\n",
+		"@U1                          D0ALICE01    im             0 -      active    fetching since 2024-01-03 00:00:00\n",
+		"#secret                      G0SECRET1    private        0 -      active    stored\n",
+		"\n4 channels, the bot is a member of 1, 3 in the local archive\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text listing is missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := WriteChannelsJSON(&buf, listings[:2]); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("JSON listing is invalid: %v", err)
+	}
+	if _, ok := decoded[0]["auto_joined_at"]; ok || decoded[1]["auto_joined_at"] != "2024-01-01T09:00:00Z" || decoded[1]["in_archive"] != false {
+		t.Errorf("JSON listing = %v", decoded)
+	}
+
+	buf.Reset()
+	if err := WriteChannelsCSV(&buf, listings[:2]); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,name,type,members,is_member,is_archived,in_archive,last_fetched,latest_ts,auto_joined_at\n" +
+		"C0GENERAL,general,public,12,true,false,true,2024-01-02 03:04:05,1704067200.000100,\n" +
+		"C0RANDOM1,random,public,3,false,true,false,,,2024-01-01T09:00:00Z\n"
+	if buf.String() != want {
+		t.Errorf("CSV = %q, want %q", buf.String(), want)
+	}
+}
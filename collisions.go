@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Collision describes a reply whose parent message is missing from its own
+// channel. Before message identity was scoped to channels, a message from
+// another channel with the same ts could overwrite the parent; such parents
+// show up as living in a different channel. A parent older than the
+// channel's earliest stored message was most likely just never fetched, for
+// example because of -since, and is reported separately from orphans.
+type Collision struct {
+	Kind           string
+	ChannelID      string
+	ReplyTS        string
+	ThreadTS       string
+	OtherChannelID string
+}
+
+// Collision kinds.
+const (
+	collisionCrossChannel = "cross-channel"
+	collisionOutsideFetch = "outside-fetch"
+	collisionOrphaned     = "orphaned"
+)
+
+const collisionsSQL = `
+	SELECT r.channel_id, r.ts, r.thread_ts, COALESCE((
+		SELECT m.channel_id FROM messages m
+		WHERE m.ts = r.thread_ts AND m.channel_id != r.channel_id
+		LIMIT 1
+	), ''), COALESCE((
+		SELECT m.ts FROM messages m
+		WHERE m.channel_id = r.channel_id
+		ORDER BY CAST(m.ts AS REAL) LIMIT 1
+	), '')
+	FROM replies r
+	WHERE NOT EXISTS (
+		SELECT 1 FROM messages m
+		WHERE m.channel_id = r.channel_id AND m.ts = r.thread_ts
+	)
+	ORDER BY r.channel_id, r.thread_ts, r.ts`
+
+func (d *Database) DetectCollisions() ([]Collision, error) {
+	return detectCollisions(d.db)
+}
+
+func detectCollisions(q queryer) ([]Collision, error) {
+	rows, err := q.Query(collisionsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collisions []Collision
+	for rows.Next() {
+		var (
+			c        Collision
+			earliest string
+		)
+		if err := rows.Scan(&c.ChannelID, &c.ReplyTS, &c.ThreadTS, &c.OtherChannelID, &earliest); err != nil {
+			return nil, err
+		}
+		switch {
+		case c.OtherChannelID != "":
+			c.Kind = collisionCrossChannel
+		case earliest == "" || tsSeconds(c.ThreadTS) < tsSeconds(earliest):
+			c.Kind = collisionOutsideFetch
+		default:
+			c.Kind = collisionOrphaned
+		}
+		collisions = append(collisions, c)
+	}
+
+	return collisions, rows.Err()
+}
+
+func writeCollisionReport(w io.Writer, collisions []Collision) {
+	if len(collisions) == 0 {
+		fmt.Fprintln(w, "No collided or orphaned rows found.")
+		return
+	}
+
+	kinds := make(map[string]int)
+	for _, c := range collisions {
+		kinds[c.Kind]++
+	}
+
+	fmt.Fprintf(w, "Found %d replies without a parent message in their channel (%d with the parent ts stored under another channel, %d with a parent older than the fetched history):\n\n",
+		len(collisions), kinds[collisionCrossChannel], kinds[collisionOutsideFetch])
+	for _, c := range collisions {
+		switch c.Kind {
+		case collisionCrossChannel:
+			fmt.Fprintf(w, "  %s reply %s in thread %s: parent ts is stored under channel %s\n", c.ChannelID, c.ReplyTS, c.ThreadTS, c.OtherChannelID)
+		case collisionOutsideFetch:
+			fmt.Fprintf(w, "  %s reply %s in thread %s: parent is older than the earliest stored message\n", c.ChannelID, c.ReplyTS, c.ThreadTS)
+		default:
+			fmt.Fprintf(w, "  %s reply %s in thread %s: parent message not found\n", c.ChannelID, c.ReplyTS, c.ThreadTS)
+		}
+	}
+	fmt.Fprintln(w, "\nRe-fetch the affected channels to restore their parent messages.")
+}
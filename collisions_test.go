@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectCollisions(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "slack.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	db.SaveChannel("C1", "general", "")
+	db.SaveChannel("C2", "random", "")
+	for _, m := range []struct{ ts, channelID string }{
+		{"1700000100.000100", "C1"},
+		{"1700000300.000100", "C1"},
+		{"1700000200.000100", "C2"},
+	} {
+		if err := db.SaveMessage(m.ts, m.channelID, "U1", "hello", "", 0, ""); err != nil {
+			t.Fatalf("failed to save message: %v", err)
+		}
+	}
+
+	replies := []struct{ ts, threadTS, channelID string }{
+		// In the fetched range, with the parent ts stored under C2.
+		{"1700000250.000100", "1700000200.000100", "C1"},
+		// In the fetched range, with no parent anywhere.
+		{"1700000260.000100", "1700000150.000100", "C1"},
+		// Parent older than C1's earliest message, as after -since.
+		{"1700000110.000100", "1699999000.000100", "C1"},
+		// A thread whose parent is stored is not reported.
+		{"1700000120.000100", "1700000100.000100", "C1"},
+	}
+	for _, r := range replies {
+		if err := db.SaveReply(r.ts, r.threadTS, r.channelID, "U1", "reply", ""); err != nil {
+			t.Fatalf("failed to save reply: %v", err)
+		}
+	}
+
+	collisions, err := db.DetectCollisions()
+	if err != nil {
+		t.Fatalf("failed to detect collisions: %v", err)
+	}
+	want := []Collision{
+		{Kind: collisionOutsideFetch, ChannelID: "C1", ReplyTS: "1700000110.000100", ThreadTS: "1699999000.000100"},
+		{Kind: collisionOrphaned, ChannelID: "C1", ReplyTS: "1700000260.000100", ThreadTS: "1700000150.000100"},
+		{Kind: collisionCrossChannel, ChannelID: "C1", ReplyTS: "1700000250.000100", ThreadTS: "1700000200.000100", OtherChannelID: "C2"},
+	}
+	if len(collisions) != len(want) {
+		t.Fatalf("collisions = %+v, want %+v", collisions, want)
+	}
+	for i := range want {
+		if collisions[i] != want[i] {
+			t.Errorf("collision %d = %+v, want %+v", i, collisions[i], want[i])
+		}
+	}
+
+	var report strings.Builder
+	writeCollisionReport(&report, collisions)
+	for _, line := range []string{
+		"Found 3 replies without a parent message in their channel (1 with the parent ts stored under another channel, 1 with a parent older than the fetched history)",
+		"C1 reply 1700000110.000100 in thread 1699999000.000100: parent is older than the earliest stored message",
+		"C1 reply 1700000260.000100 in thread 1700000150.000100: parent message not found",
+		"C1 reply 1700000250.000100 in thread 1700000200.000100: parent ts is stored under channel C2",
+	} {
+		if !strings.Contains(report.String(), line) {
+			t.Errorf("report is missing %q:\n%s", line, report.String())
+		}
+	}
+}
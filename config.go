@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const configFileName = "slack-all-contexts.yaml"
+
+// Config is the -config file: named profiles, typically one per workspace.
+//
+//	default_profile: acme
+//	profiles:
+//	  acme:
+//	    token_env: ACME_SLACK_TOKEN
+//	    db: acme.db
+//	    channels:
+//	      exclude_patterns: ["^bots-"]
+//	    export:
+//	      tz: Asia/Tokyo
+type Config struct {
+	DefaultProfile string             `yaml:"default_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// Profile holds the settings for one workspace. Each value is a default for
+// the flag of the same name; flags given on the command line win.
+type Profile struct {
+	// Token, TokenFile and TokenEnv are alternatives; the first one set is
+	// used.
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+	TokenEnv  string `yaml:"token_env"`
+
+	DB   string `yaml:"db"`
+	Addr string `yaml:"addr"`
+
+	Channels  ChannelConfig   `yaml:"channels"`
+	Export    ExportConfig    `yaml:"export"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Daemon    DaemonConfig    `yaml:"daemon"`
+	Redaction RedactionConfig `yaml:"redaction"`
+}
+
+// ChannelConfig selects channels by ID or name. Patterns are regular
+// expressions matched against the channel name.
+type ChannelConfig struct {
+	Include         []string `yaml:"include"`
+	Exclude         []string `yaml:"exclude"`
+	IncludePatterns []string `yaml:"include_patterns"`
+	ExcludePatterns []string `yaml:"exclude_patterns"`
+}
+
+type ExportConfig struct {
+	Format          string `yaml:"format"`
+	Output          string `yaml:"output"`
+	OutputDir       string `yaml:"output_dir"`
+	TZ              string `yaml:"tz"`
+	TimeFormat      string `yaml:"time_format"`
+	AuthorTZ        *bool  `yaml:"author_tz"`
+	PageSize        int    `yaml:"page_size"`
+	DownloadAvatars *bool  `yaml:"download_avatars"`
+	ChunkTokens     int    `yaml:"chunk_tokens"`
+	ChunkOverlap    int    `yaml:"chunk_overlap"`
+	Tokenizer       string `yaml:"tokenizer"`
+}
+
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+	MaxRetries        *int    `yaml:"max_retries"`
+}
+
+type DaemonConfig struct {
+	Interval string `yaml:"interval"`
+	Jitter   string `yaml:"jitter"`
+}
+
+type RedactionConfig struct {
+	Mode      string   `yaml:"mode"`
+	Builtins  []string `yaml:"builtins"`
+	RulesFile string   `yaml:"rules_file"`
+}
+
+// configSearchPaths are tried in order when -config is not given.
+func configSearchPaths() []string {
+	paths := []string{configFileName}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "slack-all-contexts", "config.yaml"))
+	}
+	return paths
+}
+
+// findConfig returns the config file to load: path if set, otherwise the
+// first file on the search path, or "" if there is none.
+func findConfig(path string) (string, error) {
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("failed to read config: %w", err)
+		}
+		return path, nil
+	}
+
+	for _, candidate := range configSearchPaths() {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Profile returns the named profile. Without a name it falls back to
+// default_profile, or to the only profile if there is just one.
+func (c *Config) Profile(name string) (Profile, string, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		if len(c.Profiles) == 1 {
+			for only := range c.Profiles {
+				name = only
+			}
+		} else if len(c.Profiles) > 1 {
+			return Profile{}, "", fmt.Errorf("several profiles are defined (%s); choose one with -profile or set default_profile", strings.Join(c.profileNames(), ", "))
+		} else {
+			return Profile{}, "", nil
+		}
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, "", fmt.Errorf("profile %q not found (available: %s)", name, strings.Join(c.profileNames(), ", "))
+	}
+	return profile, name, nil
+}
+
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveToken returns the profile's token. A token_env naming an unset
+// variable yields no token, leaving -token and SLACK_BOT_TOKEN to apply.
+func (p Profile) resolveToken() (string, error) {
+	switch {
+	case p.Token != "":
+		return p.Token, nil
+	case p.TokenFile != "":
+		data, err := os.ReadFile(p.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case p.TokenEnv != "":
+		return os.Getenv(p.TokenEnv), nil
+	default:
+		return "", nil
+	}
+}
+
+// flagValues maps the profile onto flag names. Unset values are left out.
+// The token is not among them; it is resolved by the modes that need it.
+func (p Profile) flagValues() map[string]string {
+	values := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	setInt := func(name string, value int) {
+		if value != 0 {
+			values[name] = strconv.Itoa(value)
+		}
+	}
+	setBool := func(name string, value *bool) {
+		if value != nil {
+			values[name] = strconv.FormatBool(*value)
+		}
+	}
+
+	set("db", p.DB)
+	set("addr", p.Addr)
+
+	set("format", p.Export.Format)
+	set("output", p.Export.Output)
+	set("output-dir", p.Export.OutputDir)
+	set("tz", p.Export.TZ)
+	set("time-format", p.Export.TimeFormat)
+	setBool("author-tz", p.Export.AuthorTZ)
+	setInt("page-size", p.Export.PageSize)
+	setBool("download-avatars", p.Export.DownloadAvatars)
+	setInt("chunk-tokens", p.Export.ChunkTokens)
+	setInt("chunk-overlap", p.Export.ChunkOverlap)
+	set("tokenizer", p.Export.Tokenizer)
+
+	if p.RateLimit.RequestsPerSecond != 0 {
+		values["rate"] = strconv.FormatFloat(p.RateLimit.RequestsPerSecond, 'g', -1, 64)
+	}
+	setInt("burst", p.RateLimit.Burst)
+	if p.RateLimit.MaxRetries != nil {
+		values["max-retries"] = strconv.Itoa(*p.RateLimit.MaxRetries)
+	}
+
+	set("interval", p.Daemon.Interval)
+	set("jitter", p.Daemon.Jitter)
+
+	set("redact", p.Redaction.Mode)
+	set("redact-builtins", strings.Join(p.Redaction.Builtins, ","))
+	set("redact-rules", p.Redaction.RulesFile)
+	return values
+}
+
+// applyConfigFlags sets flags from values unless they were given on the
+// command line.
+func applyConfigFlags(fs *flag.FlagSet, values map[string]string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid config value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ChannelSelector decides which channels a profile covers. A nil selector
+// matches every channel.
+type ChannelSelector struct {
+	include         map[string]bool
+	exclude         map[string]bool
+	includePatterns []*regexp.Regexp
+	excludePatterns []*regexp.Regexp
+}
+
+func (c ChannelConfig) selector() (*ChannelSelector, error) {
+	if len(c.Include)+len(c.Exclude)+len(c.IncludePatterns)+len(c.ExcludePatterns) == 0 {
+		return nil, nil
+	}
+
+	s := &ChannelSelector{include: make(map[string]bool), exclude: make(map[string]bool)}
+	for _, channel := range c.Include {
+		s.include[strings.TrimPrefix(channel, "#")] = true
+	}
+	for _, channel := range c.Exclude {
+		s.exclude[strings.TrimPrefix(channel, "#")] = true
+	}
+	for _, patterns := range []struct {
+		values []string
+		dest   *[]*regexp.Regexp
+	}{
+		{c.IncludePatterns, &s.includePatterns},
+		{c.ExcludePatterns, &s.excludePatterns},
+	} {
+		for _, pattern := range patterns.values {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid channel pattern %q: %w", pattern, err)
+			}
+			*patterns.dest = append(*patterns.dest, re)
+		}
+	}
+	return s, nil
+}
+
+// Match reports whether the channel is selected. Exclusions win; with no
+// include list or pattern every other channel is included.
+func (s *ChannelSelector) Match(id, name string) bool {
+	if s == nil {
+		return true
+	}
+	if s.exclude[id] || s.exclude[name] || matchAny(s.excludePatterns, name) {
+		return false
+	}
+	if len(s.include) == 0 && len(s.includePatterns) == 0 {
+		return true
+	}
+	return s.include[id] || s.include[name] || matchAny(s.includePatterns, name)
+}
+
+func matchAny(patterns []*regexp.Regexp, value string) bool {
+	for _, re := range patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadedProfile is the profile selected by loadConfig. A nil profile, used
+// when there is no config file, selects every channel and has no token.
+type LoadedProfile struct {
+	selector *ChannelSelector
+	path     string
+	name     string
+	profile  Profile
+}
+
+func (p *LoadedProfile) Selector() *ChannelSelector {
+	if p == nil {
+		return nil
+	}
+	return p.selector
+}
+
+// Token returns the profile's Slack token. It is only called by modes that
+// create a Slack client, so an unreadable token_file does not break the
+// offline modes.
+func (p *LoadedProfile) Token() (string, error) {
+	if p == nil {
+		return "", nil
+	}
+	token, err := p.profile.resolveToken()
+	if err != nil {
+		return "", fmt.Errorf("%s: profile %s: %w", p.path, p.name, err)
+	}
+	return token, nil
+}
+
+// loadConfig applies the selected profile of the config file at path (or
+// on the search path) to the command-line flags and returns it. Without a
+// config file it does nothing and returns nil.
+func loadConfig(path, profileName string) (*LoadedProfile, error) {
+	path, err := findConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		if profileName != "" {
+			return nil, fmt.Errorf("-profile %s given but no config file found (searched: %s)", profileName, strings.Join(configSearchPaths(), ", "))
+		}
+		return nil, nil
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	profile, name, err := config.Profile(profileName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := applyConfigFlags(flag.CommandLine, profile.flagValues()); err != nil {
+		return nil, fmt.Errorf("%s: profile %s: %w", path, name, err)
+	}
+
+	selector, err := profile.Channels.selector()
+	if err != nil {
+		return nil, fmt.Errorf("%s: profile %s: %w", path, name, err)
+	}
+
+	if name != "" {
+		log.Printf("Using profile %s from %s", name, path)
+	}
+	return &LoadedProfile{selector: selector, path: path, name: name, profile: profile}, nil
+}
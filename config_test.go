@@ -0,0 +1,227 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), configFileName)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyConfigFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	db := fs.String("db", "slack_messages.db", "")
+	format := fs.String("format", "text", "")
+	tz := fs.String("tz", "", "")
+	burst := fs.Int("burst", 1, "")
+	authorTZ := fs.Bool("author-tz", false, "")
+	interval := fs.Duration("interval", time.Hour, "")
+
+	// A flag given on the command line wins, even when it is given its
+	// default value.
+	if err := fs.Parse([]string{"-db", "cli.db", "-format=text"}); err != nil {
+		t.Fatal(err)
+	}
+	err := applyConfigFlags(fs, map[string]string{
+		"db":        "config.db",
+		"format":    "json",
+		"tz":        "Asia/Tokyo",
+		"burst":     "5",
+		"author-tz": "true",
+		"interval":  "15m",
+	})
+	if err != nil {
+		t.Fatalf("applyConfigFlags failed: %v", err)
+	}
+	if *db != "cli.db" || *format != "text" {
+		t.Errorf("command-line flags = %q, %q; want them kept", *db, *format)
+	}
+	if *tz != "Asia/Tokyo" || *burst != 5 || !*authorTZ || *interval != 15*time.Minute {
+		t.Errorf("config flags = %q, %d, %v, %v; want the config values", *tz, *burst, *authorTZ, *interval)
+	}
+
+	// fs.Set marks flags as visited, so check bad values on a fresh set.
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("burst", 1, "")
+	err = applyConfigFlags(fs, map[string]string{"burst": "many"})
+	if err == nil || !strings.Contains(err.Error(), "invalid config value for burst") {
+		t.Errorf("invalid value error = %v", err)
+	}
+	if err := applyConfigFlags(fs, map[string]string{"nope": "1"}); err == nil {
+		t.Error("unknown flag: want an error")
+	}
+}
+
+func TestProfileFlagValues(t *testing.T) {
+	no, zero := false, 0
+	profile := Profile{
+		Token: "xoxb-secret",
+		DB:    "acme.db",
+		Export: ExportConfig{
+			TZ:              "Asia/Tokyo",
+			AuthorTZ:        &no,
+			ChunkTokens:     2000,
+			DownloadAvatars: nil,
+		},
+		RateLimit: RateLimitConfig{RequestsPerSecond: 0.5, MaxRetries: &zero},
+		Daemon:    DaemonConfig{Interval: "30m"},
+		Redaction: RedactionConfig{Mode: "all", Builtins: []string{"email", "phone"}},
+	}
+
+	// Explicit false and zero values are kept; unset ones are left out.
+	want := map[string]string{
+		"db":              "acme.db",
+		"tz":              "Asia/Tokyo",
+		"author-tz":       "false",
+		"chunk-tokens":    "2000",
+		"rate":            "0.5",
+		"max-retries":     "0",
+		"interval":        "30m",
+		"redact":          "all",
+		"redact-builtins": "email,phone",
+	}
+	if got := profile.flagValues(); !reflect.DeepEqual(got, want) {
+		t.Errorf("flagValues = %v, want %v", got, want)
+	}
+	if got := (Profile{}).flagValues(); len(got) != 0 {
+		t.Errorf("empty profile flagValues = %v", got)
+	}
+}
+
+func TestConfigProfile(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+default_profile: acme
+profiles:
+  acme:
+    db: acme.db
+  beta:
+    db: beta.db
+`))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	for _, tc := range []struct{ name, wantName, wantDB string }{
+		{"", "acme", "acme.db"},
+		{"beta", "beta", "beta.db"},
+	} {
+		profile, name, err := config.Profile(tc.name)
+		if err != nil || name != tc.wantName || profile.DB != tc.wantDB {
+			t.Errorf("Profile(%q) = %+v, %q, %v; want %s", tc.name, profile, name, err, tc.wantName)
+		}
+	}
+	if _, _, err := config.Profile("gamma"); err == nil || !strings.Contains(err.Error(), "available: acme, beta") {
+		t.Errorf("unknown profile error = %v", err)
+	}
+
+	config.DefaultProfile = ""
+	if _, _, err := config.Profile(""); err == nil || !strings.Contains(err.Error(), "several profiles") {
+		t.Errorf("ambiguous profile error = %v", err)
+	}
+	delete(config.Profiles, "beta")
+	if _, name, err := config.Profile(""); err != nil || name != "acme" {
+		t.Errorf("only profile = %q, %v; want acme", name, err)
+	}
+	if _, name, err := (&Config{}).Profile(""); err != nil || name != "" {
+		t.Errorf("no profiles = %q, %v; want none", name, err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	if _, err := LoadConfig(writeConfig(t, "profiles:\n  acme:\n    dbb: typo.db\n")); err == nil || !strings.Contains(err.Error(), "dbb") {
+		t.Errorf("unknown field error = %v", err)
+	}
+	if config, err := LoadConfig(writeConfig(t, "")); err != nil || len(config.Profiles) != 0 {
+		t.Errorf("empty config = %+v, %v", config, err)
+	}
+	if _, err := findConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing -config file: want an error")
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("xoxb-from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SLACK_TOKEN", "xoxb-from-env")
+
+	for _, tc := range []struct {
+		profile Profile
+		want    string
+	}{
+		{Profile{Token: "xoxb-inline", TokenFile: tokenFile, TokenEnv: "TEST_SLACK_TOKEN"}, "xoxb-inline"},
+		{Profile{TokenFile: tokenFile, TokenEnv: "TEST_SLACK_TOKEN"}, "xoxb-from-file"},
+		{Profile{TokenEnv: "TEST_SLACK_TOKEN"}, "xoxb-from-env"},
+		{Profile{TokenEnv: "TEST_SLACK_TOKEN_UNSET"}, ""},
+		{Profile{}, ""},
+	} {
+		if got, err := tc.profile.resolveToken(); err != nil || got != tc.want {
+			t.Errorf("resolveToken(%+v) = %q, %v; want %q", tc.profile, got, err, tc.want)
+		}
+	}
+
+	loaded := &LoadedProfile{path: "config.yaml", name: "acme", profile: Profile{TokenFile: filepath.Join(t.TempDir(), "missing")}}
+	if _, err := loaded.Token(); err == nil || !strings.Contains(err.Error(), "config.yaml: profile acme:") {
+		t.Errorf("unreadable token file error = %v", err)
+	}
+
+	// Without a config file there is no token and every channel is selected.
+	var none *LoadedProfile
+	if token, err := none.Token(); token != "" || err != nil || none.Selector() != nil {
+		t.Errorf("nil profile = %q, %v", token, err)
+	}
+}
+
+func TestChannelSelector(t *testing.T) {
+	selector, err := ChannelConfig{
+		Include:         []string{"#general", "C9"},
+		Exclude:         []string{"#random"},
+		IncludePatterns: []string{"^team-"},
+		ExcludePatterns: []string{"-archive$"},
+	}.selector()
+	if err != nil {
+		t.Fatalf("selector failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		id, name string
+		want     bool
+	}{
+		{"C1", "general", true},
+		{"C9", "renamed", true},
+		{"C2", "team-infra", true},
+		{"C3", "team-infra-archive", false},
+		{"C4", "random", false},
+		{"C5", "other", false},
+	} {
+		if got := selector.Match(tc.id, tc.name); got != tc.want {
+			t.Errorf("Match(%s, %s) = %v, want %v", tc.id, tc.name, got, tc.want)
+		}
+	}
+
+	// With only exclusions, everything else is included.
+	selector, _ = ChannelConfig{ExcludePatterns: []string{"^bots-"}}.selector()
+	if !selector.Match("C1", "general") || selector.Match("C2", "bots-alerts") {
+		t.Error("exclude-only selector mismatched")
+	}
+
+	if selector, err := (ChannelConfig{}).selector(); selector != nil || err != nil || !selector.Match("C1", "general") {
+		t.Errorf("empty config selector = %v, %v; want nil matching everything", selector, err)
+	}
+	if _, err := (ChannelConfig{IncludePatterns: []string{"("}}).selector(); err == nil {
+		t.Error("invalid pattern: want an error")
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DaemonOptions struct {
+	// Channels to sync; empty means every channel the bot is a member of,
+	// re-read every Interval.
+	Channels []string
+	// Selector filters joined channels when Channels is empty.
+	Selector *ChannelSelector
+	Interval time.Duration
+	Jitter   time.Duration
+	// AutoJoin joins configured public channels the bot is not a member of.
+	AutoJoin bool
+	// Redactor, if set, redacts text before it is stored.
+	Redactor *Redactor
+}
+
+// Daemon keeps the archive up to date by running incremental fetches for
+// each channel on a schedule. Channels are fetched one at a time because
+// they share the Slack rate limit.
+type Daemon struct {
+	client    SlackAPI
+	store     Store
+	options   DaemonOptions
+	processor *MessageProcessor
+	rand      *rand.Rand
+
+	mu          sync.Mutex
+	owner       string
+	startedAt   time.Time
+	channels    map[string]*channelStatus
+	nextRefresh time.Time
+	refreshErr  string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type channelStatus struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name,omitempty"`
+	Running      bool       `json:"running"`
+	Runs         int        `json:"runs"`
+	Failures     int        `json:"failures"`
+	LastStarted  *time.Time `json:"last_started,omitempty"`
+	LastFinished *time.Time `json:"last_finished,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	NextRun      time.Time  `json:"next_run"`
+}
+
+type daemonStatus struct {
+	StartedAt    time.Time       `json:"started_at"`
+	Owner        string          `json:"owner"`
+	Database     string          `json:"database"`
+	Interval     string          `json:"interval"`
+	Jitter       string          `json:"jitter"`
+	AllChannels  bool            `json:"all_joined_channels"`
+	RefreshError string          `json:"refresh_error,omitempty"`
+	Channels     []channelStatus `json:"channels"`
+}
+
+func NewDaemon(client SlackAPI, store Store, options DaemonOptions) *Daemon {
+	processor := NewMessageProcessor(client, store)
+	processor.SetAutoJoin(options.AutoJoin)
+	processor.SetRedactor(options.Redactor)
+
+	return &Daemon{
+		client:    client,
+		store:     store,
+		options:   options,
+		processor: processor,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		channels:  make(map[string]*channelStatus),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Stop asks Run to return; a fetch in progress finishes its current page
+// first.
+func (d *Daemon) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		d.processor.Stop()
+	})
+}
+
+// Run syncs channels until Stop is called. It holds the database's writer
+// lock throughout, so no fetch, migration or other daemon can write to the
+// database while it runs.
+func (d *Daemon) Run(ctx context.Context) error {
+	lease, err := AcquireLease(d.store, writerLockName, lockTTL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lease.Release(); err != nil {
+			log.Printf("Failed to release writer lock: %v", err)
+		}
+	}()
+
+	ctx, cancel := lease.Context(ctx)
+	defer cancel()
+	d.processor.SetLease(lease)
+
+	d.mu.Lock()
+	d.owner = lease.Owner()
+	d.startedAt = time.Now()
+	d.mu.Unlock()
+
+	for {
+		if d.nextRefresh.IsZero() || d.allJoined() && time.Now().After(d.nextRefresh) {
+			d.refreshChannels(ctx)
+		}
+
+		channelID, wait := d.nextDue()
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-d.stop:
+				timer.Stop()
+				return nil
+			case <-lease.Lost():
+				timer.Stop()
+				return lease.Check()
+			case <-timer.C:
+			}
+			continue
+		}
+
+		select {
+		case <-d.stop:
+			return nil
+		default:
+		}
+
+		if err := d.syncChannel(ctx, channelID); errors.Is(err, errProcessingStopped) {
+			return nil
+		}
+		if err := lease.Check(); err != nil {
+			return err
+		}
+	}
+}
+
+// refreshChannels schedules newly configured or joined channels and drops
+// ones the bot has left. New channels start within one jitter period so
+// a restart does not fire every fetch at once.
+func (d *Daemon) refreshChannels(ctx context.Context) {
+	ids := d.options.Channels
+	if d.allJoined() {
+		joined, err := d.client.GetConversations(ctx, []string{"public_channel", "private_channel"}, true)
+		if err != nil {
+			log.Printf("Failed to list channels: %v", err)
+			d.mu.Lock()
+			d.refreshErr = err.Error()
+			d.nextRefresh = time.Now().Add(d.options.Interval)
+			d.mu.Unlock()
+			return
+		}
+		ids = nil
+		for _, channel := range joined {
+			if channel.IsMember && d.options.Selector.Match(channel.ID, channel.Name) {
+				ids = append(ids, channel.ID)
+			}
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.refreshErr = ""
+	d.nextRefresh = now.Add(d.options.Interval)
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+		if _, ok := d.channels[id]; ok {
+			continue
+		}
+		name, _ := d.store.GetChannelName(id)
+		d.channels[id] = &channelStatus{ID: id, Name: name, NextRun: now.Add(d.jitter())}
+		log.Printf("Scheduled channel %s", id)
+	}
+	for id, status := range d.channels {
+		if !wanted[id] && !status.Running {
+			delete(d.channels, id)
+			log.Printf("Unscheduled channel %s", id)
+		}
+	}
+}
+
+func (d *Daemon) allJoined() bool {
+	return len(d.options.Channels) == 0
+}
+
+func (d *Daemon) jitter() time.Duration {
+	if d.options.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(d.rand.Int63n(int64(d.options.Jitter)))
+}
+
+// nextDue returns the channel to sync next and how long to wait for it. If
+// the channel list is due for a refresh first, the channel is empty.
+func (d *Daemon) nextDue() (string, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var next *channelStatus
+	for _, status := range d.channels {
+		if next == nil || status.NextRun.Before(next.NextRun) {
+			next = status
+		}
+	}
+
+	now := time.Now()
+	if next == nil || d.allJoined() && d.nextRefresh.Before(next.NextRun) {
+		if wait := d.nextRefresh.Sub(now); wait > 0 {
+			return "", wait
+		}
+		return "", time.Millisecond
+	}
+	return next.ID, next.NextRun.Sub(now)
+}
+
+func (d *Daemon) syncChannel(ctx context.Context, channelID string) error {
+	started := time.Now()
+	d.updateStatus(channelID, func(status *channelStatus) {
+		status.Running = true
+		status.LastStarted = &started
+	})
+
+	err := d.processor.ProcessChannel(ctx, channelID)
+
+	finished := time.Now()
+	name, _ := d.store.GetChannelName(channelID)
+	d.updateStatus(channelID, func(status *channelStatus) {
+		status.Running = false
+		status.Runs++
+		status.LastFinished = &finished
+		status.NextRun = finished.Add(d.options.Interval + d.jitter())
+		if name != "" {
+			status.Name = name
+		}
+		switch {
+		case err == nil:
+			status.LastSuccess = &finished
+			status.LastError = ""
+		case errors.Is(err, errProcessingStopped):
+		default:
+			status.Failures++
+			status.LastError = err.Error()
+		}
+	})
+
+	if err != nil && !errors.Is(err, errProcessingStopped) {
+		log.Printf("Failed to sync channel %s: %v", channelID, err)
+	}
+	return err
+}
+
+func (d *Daemon) updateStatus(channelID string, fn func(*channelStatus)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if status, ok := d.channels[channelID]; ok {
+		fn(status)
+	}
+}
+
+func (d *Daemon) Status() daemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := daemonStatus{
+		StartedAt:    d.startedAt,
+		Owner:        d.owner,
+		Database:     d.store.String(),
+		Interval:     d.options.Interval.String(),
+		Jitter:       d.options.Jitter.String(),
+		AllChannels:  d.allJoined(),
+		RefreshError: d.refreshErr,
+		Channels:     make([]channelStatus, 0, len(d.channels)),
+	}
+	for _, channel := range d.channels {
+		status.Channels = append(status.Channels, *channel)
+	}
+	sort.Slice(status.Channels, func(i, j int) bool { return status.Channels[i].ID < status.Channels[j].ID })
+	return status
+}
+
+// ServeHTTP reports the daemon status as JSON.
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/status" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(d.Status())
+}
+
+// parseChannelList splits a comma- or whitespace-separated channel list.
+func parseChannelList(value string) []string {
+	var channels []string
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		if channel := strings.TrimPrefix(strings.TrimSpace(field), "#"); channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// readChannelFile reads one channel ID per line. Blank lines and lines
+// starting with "# " are ignored.
+func readChannelFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel list: %w", err)
+	}
+	defer file.Close()
+
+	var channels []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "#" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		channels = append(channels, parseChannelList(line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read channel list: %w", err)
+	}
+	return channels, nil
+}
@@ -2,105 +2,160 @@ package main
 
 import (
 	"database/sql"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/glebarez/go-sqlite"
 )
 
+const (
+	dialectSQLite   = "sqlite"
+	dialectPostgres = "postgres"
+)
+
+// Database is the SQL implementation of Store. SQLite is the default;
+// PostgreSQL shares the same queries, with placeholders rebound and the few
+// dialect differences handled where the SQL is built.
 type Database struct {
-	db *sql.DB
+	db      *sql.DB
+	path    string
+	dialect string
 }
 
+// NewDatabase opens the database and brings its schema up to date,
+// backing up existing data before any migration is applied.
 func NewDatabase(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	database, err := OpenDatabase(dbPath)
 	if err != nil {
 		return nil, err
 	}
 
-	database := &Database{db: db}
-	if err := database.createTables(); err != nil {
+	if _, err := database.Migrate(); err != nil {
+		database.Close()
 		return nil, err
 	}
 
 	return database, nil
 }
 
-func (d *Database) createTables() error {
-	createTablesSQL := `
-	CREATE TABLE IF NOT EXISTS channels (
-		id TEXT PRIMARY KEY,
-		name TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		name TEXT,
-		real_name TEXT,
-		display_name TEXT,
-		email TEXT,
-		profile_image TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS messages (
-		ts TEXT PRIMARY KEY,
-		channel_id TEXT NOT NULL,
-		user_id TEXT,
-		text TEXT,
-		thread_ts TEXT,
-		reply_count INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (channel_id) REFERENCES channels(id),
-		FOREIGN KEY (user_id) REFERENCES users(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS replies (
-		ts TEXT PRIMARY KEY,
-		thread_ts TEXT NOT NULL,
-		channel_id TEXT NOT NULL,
-		user_id TEXT,
-		text TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (thread_ts) REFERENCES messages(ts),
-		FOREIGN KEY (channel_id) REFERENCES channels(id),
-		FOREIGN KEY (user_id) REFERENCES users(id)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_messages_channel_id ON messages(channel_id);
-	CREATE INDEX IF NOT EXISTS idx_messages_thread_ts ON messages(thread_ts);
-	CREATE INDEX IF NOT EXISTS idx_replies_thread_ts ON replies(thread_ts);
-	CREATE INDEX IF NOT EXISTS idx_replies_channel_id ON replies(channel_id);
-	CREATE INDEX IF NOT EXISTS idx_users_name ON users(name);
-	`
-
-	_, err := d.db.Exec(createTablesSQL)
-	return err
+// OpenDatabase opens the database without touching its schema. Connections
+// use WAL journaling so exports can read while a fetch is writing, and wait
+// on a busy database instead of failing immediately.
+func OpenDatabase(dbPath string) (*Database, error) {
+	dsn := dbPath
+	if strings.Contains(dsn, "?") {
+		dsn += "&"
+	} else {
+		dsn += "?"
+	}
+	dsn += "_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Database{db: db, path: dbPath, dialect: dialectSQLite}, nil
 }
 
-func (d *Database) SaveChannel(id, name string) error {
-	_, err := d.db.Exec("INSERT OR REPLACE INTO channels (id, name) VALUES (?, ?)", id, name)
-	return err
+// rebind converts ? placeholders to the numbered form PostgreSQL expects.
+// Question marks inside quoted string literals are left alone.
+func (d *Database) rebind(query string) string {
+	if d.dialect != dialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	inQuote := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inQuote = !inQuote
+		case r == '?' && !inQuote:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
 
-func (d *Database) SaveMessage(ts, channelID, userID, text, threadTS string, replyCount int) error {
-	_, err := d.db.Exec(`
-		INSERT OR REPLACE INTO messages (ts, channel_id, user_id, text, thread_ts, reply_count) 
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		ts, channelID, userID, text, threadTS, replyCount)
+const (
+	saveChannelSQL = `
+		INSERT INTO channels (id, name, team_id) VALUES (?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET name = excluded.name, team_id = excluded.team_id`
+
+	saveMessageSQL = `
+		INSERT INTO messages (ts, channel_id, user_id, text, thread_ts, reply_count, team_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(channel_id, ts) DO UPDATE SET
+			user_id = excluded.user_id,
+			text = excluded.text,
+			thread_ts = excluded.thread_ts,
+			reply_count = excluded.reply_count,
+			team_id = excluded.team_id`
+
+	saveReplySQL = `
+		INSERT INTO replies (ts, thread_ts, channel_id, user_id, text, team_id)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(channel_id, ts) DO UPDATE SET
+			thread_ts = excluded.thread_ts,
+			user_id = excluded.user_id,
+			text = excluded.text,
+			team_id = excluded.team_id`
+
+	saveUserSQL = `
+		INSERT INTO users (id, name, real_name, display_name, email, profile_image, tz, team_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name,
+			real_name = excluded.real_name,
+			display_name = excluded.display_name,
+			email = excluded.email,
+			profile_image = excluded.profile_image,
+			tz = excluded.tz,
+			team_id = excluded.team_id`
+
+	saveUserTeamSQL = `
+		INSERT INTO user_teams (user_id, team_id) VALUES (?, ?)
+		ON CONFLICT(user_id, team_id) DO NOTHING`
+
+	saveFetchStateSQL = `
+		INSERT INTO fetch_state (channel_id, last_ts, oldest_ts, latest_ts, cursor, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(channel_id) DO UPDATE SET
+			last_ts = excluded.last_ts,
+			oldest_ts = excluded.oldest_ts,
+			latest_ts = excluded.latest_ts,
+			cursor = excluded.cursor,
+			updated_at = excluded.updated_at`
+)
+
+// exec runs a single write, recording its latency under operation.
+func (d *Database) exec(operation, query string, args ...interface{}) error {
+	defer observeDuration(metrics.dbWriteDuration, time.Now(), operation)
+	_, err := d.db.Exec(d.rebind(query), args...)
 	return err
 }
 
-func (d *Database) SaveReply(ts, threadTS, channelID, userID, text string) error {
-	_, err := d.db.Exec(`
-		INSERT OR REPLACE INTO replies (ts, thread_ts, channel_id, user_id, text) 
-		VALUES (?, ?, ?, ?, ?)`,
-		ts, threadTS, channelID, userID, text)
-	return err
+func (d *Database) SaveChannel(id, name, teamID string) error {
+	return d.exec("save_channel", saveChannelSQL, id, name, teamID)
+}
+
+func (d *Database) SaveMessage(ts, channelID, userID, text, threadTS string, replyCount int, teamID string) error {
+	return d.exec("save_message", saveMessageSQL, ts, channelID, userID, text, threadTS, replyCount, teamID)
+}
+
+func (d *Database) SaveReply(ts, threadTS, channelID, userID, text, teamID string) error {
+	return d.exec("save_reply", saveReplySQL, ts, threadTS, channelID, userID, text, teamID)
 }
 
 func (d *Database) GetLastMessageTimestamp(channelID string) (string, error) {
 	var ts string
-	err := d.db.QueryRow("SELECT ts FROM messages WHERE channel_id = ? ORDER BY ts DESC LIMIT 1", channelID).Scan(&ts)
+	err := d.db.QueryRow(d.rebind("SELECT ts FROM messages WHERE channel_id = ? ORDER BY ts DESC LIMIT 1"), channelID).Scan(&ts)
 	if err == sql.ErrNoRows {
 		return "", nil
 	}
@@ -108,102 +163,131 @@ func (d *Database) GetLastMessageTimestamp(channelID string) (string, error) {
 }
 
 type MessageWithReplies struct {
-	Timestamp    string
-	ChannelID    string
-	ChannelName  string
-	UserID       string
-	UserName     string
-	UserRealName string
-	UserDisplayName string
-	Text         string
-	ThreadTS     string
-	ReplyCount   int
-	Replies      []Reply
+	Timestamp       string  `json:"ts"`
+	ChannelID       string  `json:"channel_id"`
+	ChannelName     string  `json:"channel_name"`
+	TeamID          string  `json:"team_id,omitempty"`
+	UserID          string  `json:"user_id"`
+	UserName        string  `json:"user_name,omitempty"`
+	UserRealName    string  `json:"user_real_name,omitempty"`
+	UserDisplayName string  `json:"user_display_name,omitempty"`
+	UserTZ          string  `json:"user_tz,omitempty"`
+	Text            string  `json:"text"`
+	ThreadTS        string  `json:"thread_ts,omitempty"`
+	ReplyCount      int     `json:"reply_count,omitempty"`
+	Replies         []Reply `json:"replies"`
 }
 
 type Reply struct {
-	Timestamp       string
-	UserID          string
-	UserName        string
-	UserRealName    string
-	UserDisplayName string
-	Text            string
+	Timestamp       string `json:"ts"`
+	UserID          string `json:"user_id"`
+	UserName        string `json:"user_name,omitempty"`
+	UserRealName    string `json:"user_real_name,omitempty"`
+	UserDisplayName string `json:"user_display_name,omitempty"`
+	UserTZ          string `json:"user_tz,omitempty"`
+	Text            string `json:"text"`
 }
 
-func (d *Database) GetAllMessagesWithReplies(channelID string) ([]MessageWithReplies, error) {
+func (d *Database) GetAllMessagesWithReplies(channelID string, filter MessageFilter) ([]MessageWithReplies, error) {
+	var messages []MessageWithReplies
+	err := d.EachMessageWithReplies(channelID, filter, func(msg MessageWithReplies) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	return messages, err
+}
+
+// EachMessageWithReplies streams the channel's messages in timestamp order,
+// calling fn once per message with its replies attached. Messages and
+// replies are read with a single joined query, so only one thread is held
+// in memory at a time.
+func (d *Database) EachMessageWithReplies(channelID string, filter MessageFilter, fn func(MessageWithReplies) error) error {
+	filterSQL, filterArgs := filter.sql(d.dialect)
 	query := `
-		SELECT m.ts, m.channel_id, c.name, m.user_id, 
+		SELECT m.ts, m.channel_id, c.name, m.team_id, m.user_id,
 		       COALESCE(u.name, '') as user_name,
 		       COALESCE(u.real_name, '') as user_real_name,
 		       COALESCE(u.display_name, '') as user_display_name,
-		       m.text, m.thread_ts, m.reply_count
+		       COALESCE(u.tz, '') as user_tz,
+		       m.text, m.thread_ts, m.reply_count,
+		       r.ts, r.user_id,
+		       COALESCE(ru.name, '') as reply_user_name,
+		       COALESCE(ru.real_name, '') as reply_user_real_name,
+		       COALESCE(ru.display_name, '') as reply_user_display_name,
+		       COALESCE(ru.tz, '') as reply_user_tz,
+		       r.text
 		FROM messages m
 		JOIN channels c ON m.channel_id = c.id
 		LEFT JOIN users u ON m.user_id = u.id
-		WHERE m.channel_id = ?
-		ORDER BY m.ts ASC`
+		LEFT JOIN replies r ON m.reply_count > 0 AND m.thread_ts != ''
+		     AND r.channel_id = m.channel_id AND r.thread_ts = m.thread_ts
+		LEFT JOIN users ru ON r.user_id = ru.id
+		WHERE m.channel_id = ? AND ` + filterSQL + `
+		ORDER BY m.ts ASC, r.ts ASC`
 
-	rows, err := d.db.Query(query, channelID)
+	rows, err := d.db.Query(d.rebind(query), append([]interface{}{channelID}, filterArgs...)...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var messages []MessageWithReplies
+	var current *MessageWithReplies
 	for rows.Next() {
 		var msg MessageWithReplies
-		err := rows.Scan(&msg.Timestamp, &msg.ChannelID, &msg.ChannelName, &msg.UserID, 
-			&msg.UserName, &msg.UserRealName, &msg.UserDisplayName, 
-			&msg.Text, &msg.ThreadTS, &msg.ReplyCount)
+		var replyTS, replyUserID, replyText sql.NullString
+		var reply Reply
+		err := rows.Scan(&msg.Timestamp, &msg.ChannelID, &msg.ChannelName, &msg.TeamID, &msg.UserID,
+			&msg.UserName, &msg.UserRealName, &msg.UserDisplayName, &msg.UserTZ,
+			&msg.Text, &msg.ThreadTS, &msg.ReplyCount,
+			&replyTS, &replyUserID,
+			&reply.UserName, &reply.UserRealName, &reply.UserDisplayName, &reply.UserTZ,
+			&replyText)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		if msg.ThreadTS != "" && msg.ReplyCount > 0 {
-			replies, err := d.getReplies(msg.ThreadTS)
-			if err != nil {
-				return nil, err
+		if current == nil || current.Timestamp != msg.Timestamp {
+			if current != nil {
+				if err := fn(*current); err != nil {
+					return err
+				}
 			}
-			msg.Replies = replies
+			current = &msg
 		}
 
-		messages = append(messages, msg)
+		if replyTS.Valid {
+			reply.Timestamp = replyTS.String
+			reply.UserID = replyUserID.String
+			reply.Text = replyText.String
+			current.Replies = append(current.Replies, reply)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	return messages, nil
+	if current != nil {
+		return fn(*current)
+	}
+	return nil
 }
 
-func (d *Database) getReplies(threadTS string) ([]Reply, error) {
-	query := `
-		SELECT r.ts, r.user_id,
-		       COALESCE(u.name, '') as user_name,
-		       COALESCE(u.real_name, '') as user_real_name,
-		       COALESCE(u.display_name, '') as user_display_name,
-		       r.text
-		FROM replies r
-		LEFT JOIN users u ON r.user_id = u.id
-		WHERE r.thread_ts = ?
-		ORDER BY r.ts ASC`
+func (d *Database) CountMessages(channelID string, filter MessageFilter) (int, error) {
+	filterSQL, filterArgs := filter.sql(d.dialect)
+	query := "SELECT COUNT(*) FROM messages m JOIN channels c ON m.channel_id = c.id WHERE m.channel_id = ? AND " + filterSQL
 
-	rows, err := d.db.Query(query, threadTS)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	var count int
+	err := d.db.QueryRow(d.rebind(query), append([]interface{}{channelID}, filterArgs...)...).Scan(&count)
+	return count, err
+}
 
-	var replies []Reply
-	for rows.Next() {
-		var reply Reply
-		err := rows.Scan(&reply.Timestamp, &reply.UserID, 
-			&reply.UserName, &reply.UserRealName, &reply.UserDisplayName,
-			&reply.Text)
-		if err != nil {
-			return nil, err
-		}
-		replies = append(replies, reply)
+func (d *Database) GetChannelName(channelID string) (string, error) {
+	var name string
+	err := d.db.QueryRow(d.rebind("SELECT name FROM channels WHERE id = ?"), channelID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
-
-	return replies, nil
+	return name, err
 }
 
 func (d *Database) GetChannels() (map[string]string, error) {
@@ -226,16 +310,17 @@ func (d *Database) GetChannels() (map[string]string, error) {
 	return channels, nil
 }
 
-func (d *Database) SaveUser(id, name, realName, displayName, email, profileImage string) error {
-	_, err := d.db.Exec(`
-		INSERT OR REPLACE INTO users (id, name, real_name, display_name, email, profile_image) 
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		id, name, realName, displayName, email, profileImage)
-	return err
+func (d *Database) SaveUser(id, name, realName, displayName, email, profileImage, tz, teamID string) error {
+	return d.exec("save_user", saveUserSQL, id, name, realName, displayName, email, profileImage, tz, teamID)
 }
 
 func (d *Database) GetUsers() ([]User, error) {
-	query := "SELECT id, name, real_name, display_name, email, profile_image FROM users ORDER BY name"
+	userTeams, err := d.getUserTeams()
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT id, name, real_name, display_name, email, profile_image, COALESCE(tz, ''), team_id FROM users ORDER BY name"
 	rows, err := d.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -245,10 +330,11 @@ func (d *Database) GetUsers() ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var user User
-		err := rows.Scan(&user.ID, &user.Name, &user.RealName, &user.DisplayName, &user.Email, &user.ProfileImage)
+		err := rows.Scan(&user.ID, &user.Name, &user.RealName, &user.DisplayName, &user.Email, &user.ProfileImage, &user.TZ, &user.TeamID)
 		if err != nil {
 			return nil, err
 		}
+		user.Teams = userTeams[user.ID]
 		users = append(users, user)
 	}
 
@@ -262,6 +348,15 @@ type User struct {
 	DisplayName  string
 	Email        string
 	ProfileImage string
+	TZ           string
+	// TeamID is the user's home workspace; Teams lists every workspace of
+	// an Enterprise Grid org the user belongs to.
+	TeamID string
+	Teams  []string
+}
+
+func (d *Database) String() string {
+	return d.path
 }
 
 func (d *Database) Close() error {
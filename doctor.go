@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// doctorScopes are the OAuth scopes fetches use. Missing required scopes
+// break every fetch; the others only matter for private channels or user
+// emails.
+var doctorScopes = []struct {
+	scope    string
+	purpose  string
+	required bool
+}{
+	{"channels:history", "read messages in public channels", true},
+	{"channels:read", "look up public channels", true},
+	{"users:read", "save message authors", true},
+	{"groups:history", "read messages in private channels", false},
+	{"groups:read", "look up private channels", false},
+	{"users:read.email", "save user email addresses", false},
+}
+
+const addScopeRemedy = "Add %s under OAuth & Permissions > Scopes in the Slack app settings, then reinstall the app to the workspace"
+
+// scopeReporter is implemented by clients that know the token's scopes.
+type scopeReporter interface {
+	GrantedScopes() ([]string, bool)
+}
+
+type DoctorOptions struct {
+	// Channels to check; empty means every channel the bot is a member of.
+	Channels []string
+	// Selector filters joined channels when Channels is empty.
+	Selector *ChannelSelector
+}
+
+// Doctor checks that a token can fetch the configured channels and explains
+// how to fix what it can't, so problems show up before a fetch starts
+// instead of halfway through one.
+type Doctor struct {
+	client  SlackAPI
+	token   string
+	options DoctorOptions
+	out     io.Writer
+
+	botUser  string
+	scopes   map[string]bool
+	problems int
+	warnings int
+}
+
+func NewDoctor(client SlackAPI, token string, options DoctorOptions, out io.Writer) *Doctor {
+	return &Doctor{client: client, token: token, options: options, out: out}
+}
+
+// Run prints the report and returns an error if any check failed.
+func (d *Doctor) Run(ctx context.Context) error {
+	fmt.Fprintf(d.out, "# Slack Doctor\n")
+
+	if d.checkToken(ctx) {
+		d.checkScopes()
+		d.checkChannels(ctx)
+	}
+
+	fmt.Fprintf(d.out, "\n%s, %s\n", plural(d.problems, "problem"), plural(d.warnings, "warning"))
+	if d.problems > 0 {
+		return fmt.Errorf("%s found", plural(d.problems, "problem"))
+	}
+	return nil
+}
+
+func (d *Doctor) checkToken(ctx context.Context) bool {
+	fmt.Fprintf(d.out, "\n## Token\n\n")
+
+	kind, usable := tokenType(d.token)
+	if usable {
+		d.ok("Token type: %s", kind)
+	} else {
+		d.fail("Use the Bot User OAuth Token (xoxb-) from OAuth & Permissions in the Slack app settings", "Token type: %s", kind)
+	}
+
+	resp, err := d.client.AuthTest(ctx)
+	if err != nil {
+		d.fail(remedyFor(err, ""), "auth.test failed: %v", err)
+		return false
+	}
+
+	team := teamFromAuthTest(resp)
+	workspace := team.ID
+	if team.Domain != "" {
+		workspace += ", " + team.Domain + ".slack.com"
+	}
+	d.ok("Workspace: %s (%s)", team.Name, workspace)
+	if team.EnterpriseID != "" {
+		d.ok("Enterprise Grid org: %s", team.EnterpriseID)
+	}
+
+	d.botUser = resp.User
+	user := fmt.Sprintf("%s (%s)", resp.User, resp.UserID)
+	if resp.BotID != "" {
+		user += ", bot ID " + resp.BotID
+	}
+	d.ok("User: %s", user)
+	return true
+}
+
+func (d *Doctor) checkScopes() {
+	fmt.Fprintf(d.out, "\n## Scopes\n\n")
+
+	reporter, ok := d.client.(scopeReporter)
+	var granted []string
+	if ok {
+		granted, ok = reporter.GrantedScopes()
+	}
+	if !ok {
+		d.warn("", "Slack did not report the token's scopes; skipping scope checks")
+		return
+	}
+
+	d.scopes = make(map[string]bool, len(granted))
+	for _, scope := range granted {
+		d.scopes[scope] = true
+	}
+	sorted := append([]string(nil), granted...)
+	sort.Strings(sorted)
+	fmt.Fprintf(d.out, "Granted: %s\n\n", strings.Join(sorted, ", "))
+
+	for _, s := range doctorScopes {
+		switch {
+		case d.scopes[s.scope]:
+			d.ok("%s: %s", s.scope, s.purpose)
+		case s.required:
+			d.fail(fmt.Sprintf(addScopeRemedy, s.scope), "%s is missing: needed to %s", s.scope, s.purpose)
+		default:
+			d.warn(fmt.Sprintf(addScopeRemedy, s.scope), "%s is missing: needed to %s", s.scope, s.purpose)
+		}
+	}
+}
+
+// hasScope reports whether the token has scope, assuming it does when the
+// scopes are unknown.
+func (d *Doctor) hasScope(scope string) bool {
+	return d.scopes == nil || d.scopes[scope]
+}
+
+func (d *Doctor) checkChannels(ctx context.Context) {
+	fmt.Fprintf(d.out, "\n## Channels\n\n")
+
+	ids := d.options.Channels
+	if len(ids) == 0 {
+		joined, err := d.client.GetConversations(ctx, []string{"public_channel", "private_channel"}, true)
+		if err != nil {
+			d.fail(remedyFor(err, "channels:read"), "Failed to list channels: %v", err)
+			return
+		}
+		for _, channel := range joined {
+			if channel.IsMember && d.options.Selector.Match(channel.ID, channel.Name) {
+				ids = append(ids, channel.ID)
+			}
+		}
+		if len(ids) == 0 {
+			d.warn(d.inviteRemedy("<channel>"), "The bot has not joined any channels; pass -channel or -channels to check specific ones")
+			return
+		}
+		fmt.Fprintf(d.out, "Checking %d joined channels\n\n", len(ids))
+	}
+
+	for _, id := range ids {
+		d.checkChannel(ctx, id)
+	}
+}
+
+func (d *Doctor) checkChannel(ctx context.Context, channelID string) {
+	channel, err := d.client.GetChannelInfo(ctx, channelID)
+	if err != nil {
+		d.fail(remedyFor(err, "channels:read"), "%s: cannot read channel info: %v", channelID, err)
+		return
+	}
+
+	subject := fmt.Sprintf("#%s (%s)", channel.Name, channelID)
+	if channel.IsArchived {
+		d.warn("", "%s is archived; its history can still be fetched once", subject)
+	}
+
+	historyScope := "channels:history"
+	if channel.IsPrivate {
+		historyScope = "groups:history"
+	}
+	if !d.hasScope(historyScope) {
+		d.fail(fmt.Sprintf(addScopeRemedy, historyScope), "%s: %s is missing", subject, historyScope)
+		return
+	}
+
+	if !channel.IsMember {
+		remedy := d.inviteRemedy(channel.Name)
+		if canJoin(d.client, channel) == "" {
+			remedy += ", or fetch with -auto-join"
+		}
+		d.fail(remedy, "%s: the bot is not a member", subject)
+		return
+	}
+
+	if _, err := d.client.GetConversationHistory(ctx, channelID, "", "", 1); err != nil {
+		remedy := remedyFor(err, historyScope)
+		if isSlackError(err, "not_in_channel") {
+			remedy = d.inviteRemedy(channel.Name)
+		}
+		d.fail(remedy, "%s: cannot read history: %v", subject, err)
+		return
+	}
+
+	d.ok("%s: member, history readable", subject)
+}
+
+func (d *Doctor) inviteRemedy(channelName string) string {
+	bot := d.botUser
+	if bot == "" {
+		bot = "<bot>"
+	}
+	return fmt.Sprintf("Invite the bot by posting /invite @%s in #%s", bot, channelName)
+}
+
+func (d *Doctor) ok(format string, args ...interface{}) {
+	fmt.Fprintf(d.out, "[ok]   %s\n", fmt.Sprintf(format, args...))
+}
+
+func (d *Doctor) warn(remedy, format string, args ...interface{}) {
+	d.warnings++
+	d.report("[warn]", remedy, format, args...)
+}
+
+func (d *Doctor) fail(remedy, format string, args ...interface{}) {
+	d.problems++
+	d.report("[fail]", remedy, format, args...)
+}
+
+func (d *Doctor) report(status, remedy, format string, args ...interface{}) {
+	fmt.Fprintf(d.out, "%-6s %s\n", status, fmt.Sprintf(format, args...))
+	if remedy != "" {
+		fmt.Fprintf(d.out, "       -> %s\n", remedy)
+	}
+}
+
+// tokenType describes a token by its prefix and reports whether it can be
+// used to read channels.
+func tokenType(token string) (string, bool) {
+	switch {
+	case strings.HasPrefix(token, "xoxb-"):
+		return "bot token (xoxb)", true
+	case strings.HasPrefix(token, "xoxp-"):
+		return "user token (xoxp)", true
+	case strings.HasPrefix(token, "xoxe.xoxb-"):
+		return "rotating bot token (xoxe.xoxb)", true
+	case strings.HasPrefix(token, "xoxe.xoxp-"):
+		return "rotating user token (xoxe.xoxp)", true
+	case strings.HasPrefix(token, "xapp-"):
+		return "app-level token (xapp), which cannot call the Web API methods used here", false
+	case token == scrubbedToken:
+		return "unknown (replaying a recording)", true
+	default:
+		return "unknown", true
+	}
+}
+
+// slackErrorCode returns the error code of a Slack API error response, or
+// "" for other errors.
+func slackErrorCode(err error) string {
+	var slackErr slack.SlackErrorResponse
+	if errors.As(err, &slackErr) {
+		return slackErr.Err
+	}
+	return ""
+}
+
+// remedyFor suggests a fix for a Slack API error; scope is the scope the
+// failed call needs.
+func remedyFor(err error, scope string) string {
+	code := slackErrorCode(err)
+	if code == "" {
+		code = err.Error()
+	}
+
+	switch code {
+	case "invalid_auth", "not_authed", "token_revoked", "token_expired", "account_inactive":
+		return "The token is invalid or was revoked; check -token or SLACK_BOT_TOKEN"
+	case "missing_scope":
+		if scope == "" {
+			return "The token lacks a scope this call needs; see the Scopes section"
+		}
+		return fmt.Sprintf(addScopeRemedy, scope)
+	case "channel_not_found":
+		return "Check the channel ID; private channels are only visible after the bot has been invited"
+	case "ratelimited":
+		return "Slack is rate limiting this token; try again later or lower -rate"
+	default:
+		return ""
+	}
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
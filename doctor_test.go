@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"golang.org/x/time/rate"
+
+	"github.com/takutakahashi/slack-all-contexts/fakeslack"
+)
+
+// newScopedFakeSlack is newFakeSlack with a client that records the
+// X-OAuth-Scopes header, as newSlackClient sets up.
+func newScopedFakeSlack(t *testing.T, fixtures fakeslack.Fixtures) (*fakeslack.Server, *SlackClient) {
+	t.Helper()
+
+	server := fakeslack.NewServer(fixtures)
+	t.Cleanup(server.Close)
+
+	scopes := &scopeCapture{next: http.DefaultTransport}
+	client := NewSlackClient("xoxb-test", slack.OptionAPIURL(server.APIURL()), slack.OptionHTTPClient(&http.Client{Transport: scopes}))
+	client.scopes = scopes
+	client.limiter = rate.NewLimiter(rate.Inf, 1)
+	return server, client
+}
+
+var allDoctorScopes = []string{"channels:history", "channels:read", "users:read", "groups:history", "groups:read", "users:read.email", "channels:join"}
+
+func doctorFixtures(scopes []string) fakeslack.Fixtures {
+	return fakeslack.Fixtures{
+		Team: fakeslack.Team{ID: "T1", Name: "Acme", Domain: "acme", UserID: "U0", User: "archiver", BotID: "B1", Scopes: scopes},
+		Channels: []fakeslack.Channel{
+			{ID: "C1", Name: "general", IsMember: true},
+			{ID: "C2", Name: "random"},
+			{ID: "C3", Name: "old", IsMember: true, IsArchived: true},
+			{ID: "G1", Name: "secret", IsPrivate: true, IsMember: true},
+		},
+	}
+}
+
+func runDoctor(t *testing.T, client SlackAPI, token string, options DoctorOptions) (string, error) {
+	t.Helper()
+
+	var out strings.Builder
+	err := NewDoctor(client, token, options, &out).Run(context.Background())
+	return out.String(), err
+}
+
+func assertReport(t *testing.T, report string, want, exclude []string) {
+	t.Helper()
+
+	for _, line := range want {
+		if !strings.Contains(report, line) {
+			t.Errorf("report is missing %q:\n%s", line, report)
+		}
+	}
+	for _, line := range exclude {
+		if strings.Contains(report, line) {
+			t.Errorf("report contains %q:\n%s", line, report)
+		}
+	}
+}
+
+func TestDoctorHealthy(t *testing.T) {
+	_, client := newScopedFakeSlack(t, doctorFixtures(allDoctorScopes))
+
+	report, err := runDoctor(t, client, "xoxb-test", DoctorOptions{})
+	if err != nil {
+		t.Fatalf("Run = %v, want no problems:\n%s", err, report)
+	}
+	assertReport(t, report, []string{
+		"[ok]   Token type: bot token (xoxb)\n",
+		"[ok]   Workspace: Acme (T1, acme.slack.com)\n",
+		"[ok]   User: archiver (U0), bot ID B1\n",
+		"[ok]   users:read.email: save user email addresses\n",
+		// Only joined, unarchived channels are checked by default.
+		"Checking 2 joined channels\n",
+		"[ok]   #general (C1): member, history readable\n",
+		"[ok]   #secret (G1): member, history readable\n",
+		"\n0 problems, 0 warnings\n",
+	}, []string{"#random", "#old"})
+}
+
+func TestDoctorProblems(t *testing.T) {
+	// Missing users:read, which is required, and the optional scopes.
+	server, client := newScopedFakeSlack(t, doctorFixtures([]string{"channels:history", "channels:read", "channels:join"}))
+	server.FailNext("conversations.history", "not_in_channel")
+
+	report, err := runDoctor(t, client, "xoxb-test", DoctorOptions{Channels: []string{"C1", "C2", "C3", "G1", "C9"}})
+	if err == nil || err.Error() != "5 problems found" {
+		t.Errorf("Run = %v, want 5 problems", err)
+	}
+	assertReport(t, report, []string{
+		"Granted: channels:history, channels:join, channels:read\n",
+		"[fail] users:read is missing: needed to save message authors\n       -> Add users:read under OAuth & Permissions > Scopes",
+		"[warn] groups:read is missing: needed to look up private channels\n",
+		// A member whose history still fails with not_in_channel.
+		"[fail] #general (C1): cannot read history: not_in_channel\n       -> Invite the bot by posting /invite @archiver in #general\n",
+		"[fail] #random (C2): the bot is not a member\n       -> Invite the bot by posting /invite @archiver in #random, or fetch with -auto-join\n",
+		"[warn] #old (C3) is archived; its history can still be fetched once\n[ok]   #old (C3): member, history readable\n",
+		"[fail] #secret (G1): groups:history is missing\n",
+		"[fail] C9: cannot read channel info: channel_not_found\n       -> Check the channel ID",
+		"\n5 problems, 4 warnings\n",
+	}, nil)
+}
+
+func TestDoctorTokenProblems(t *testing.T) {
+	server, client := newScopedFakeSlack(t, doctorFixtures(allDoctorScopes))
+	server.Token = "xoxb-other"
+
+	report, err := runDoctor(t, client, "xapp-1-test", DoctorOptions{})
+	if err == nil || err.Error() != "2 problems found" {
+		t.Errorf("Run = %v, want 2 problems", err)
+	}
+	// Without a working token the other checks are skipped.
+	assertReport(t, report, []string{
+		"[fail] Token type: app-level token (xapp)",
+		"[fail] auth.test failed: invalid_auth\n       -> The token is invalid or was revoked",
+	}, []string{"## Scopes", "## Channels"})
+}
+
+func TestDoctorUnknownScopes(t *testing.T) {
+	// A client that does not capture scopes, with a channel the bot has not
+	// joined and a selector that excludes the only joined one.
+	_, client := newFakeSlack(t, doctorFixtures(nil))
+	selector, _ := ChannelConfig{Exclude: []string{"general", "secret"}}.selector()
+
+	report, err := runDoctor(t, client, "xoxb-test", DoctorOptions{Selector: selector})
+	if err != nil {
+		t.Fatalf("Run = %v:\n%s", err, report)
+	}
+	assertReport(t, report, []string{
+		"[warn] Slack did not report the token's scopes; skipping scope checks\n",
+		"[warn] The bot has not joined any channels; pass -channel or -channels to check specific ones\n       -> Invite the bot by posting /invite @archiver in #<channel>\n",
+		"\n0 problems, 2 warnings\n",
+	}, nil)
+
+	// With unknown scopes, joining is assumed to be possible.
+	report, err = runDoctor(t, client, "xoxb-test", DoctorOptions{Channels: []string{"C2"}})
+	if err == nil {
+		t.Error("channel without the bot: want a problem")
+	}
+	assertReport(t, report, []string{", or fetch with -auto-join\n"}, nil)
+}
+
+func TestTokenType(t *testing.T) {
+	for token, want := range map[string]bool{
+		"xoxb-1":       true,
+		"xoxp-1":       true,
+		"xoxe.xoxb-1":  true,
+		"xoxe.xoxp-1":  true,
+		scrubbedToken:  true,
+		"something":    true,
+		"xapp-1-A1-x2": false,
+	} {
+		if kind, usable := tokenType(token); usable != want || kind == "" {
+			t.Errorf("tokenType(%q) = %q, %v; want usable %v", token, kind, usable, want)
+		}
+	}
+}
+
+func TestRemedyFor(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		scope string
+		want  string
+	}{
+		{slack.SlackErrorResponse{Err: "token_revoked"}, "", "The token is invalid or was revoked"},
+		{slack.SlackErrorResponse{Err: "missing_scope"}, "groups:read", "Add groups:read under OAuth & Permissions"},
+		{slack.SlackErrorResponse{Err: "missing_scope"}, "", "see the Scopes section"},
+		{slack.SlackErrorResponse{Err: "channel_not_found"}, "", "Check the channel ID"},
+		{errors.New("ratelimited"), "", "rate limiting"},
+		{errors.New("connection refused"), "", ""},
+	} {
+		got := remedyFor(tc.err, tc.scope)
+		if (tc.want == "") != (got == "") || !strings.Contains(got, tc.want) {
+			t.Errorf("remedyFor(%v, %q) = %q, want %q", tc.err, tc.scope, got, tc.want)
+		}
+	}
+
+	if got := plural(1, "problem") + ", " + plural(0, "warning") + ", " + plural(2, "channel"); got != "1 problem, 0 warnings, 2 channels" {
+		t.Errorf("plural = %q", got)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultChunkTokens = 4000
+
+type chunkUnit struct {
+	text         string
+	tokens       int
+	firstTS      string
+	lastTS       string
+	participants []string
+	messages     int
+}
+
+type chunkWriter struct {
+	exporter    *Exporter
+	tokenizer   Tokenizer
+	budget      int
+	overlap     int
+	channelID   string
+	channelName string
+	basePath    string
+
+	units   []chunkUnit
+	carried int
+	written int
+}
+
+// ExportChunks splits a channel into self-describing text files that each
+// fit within the configured token budget. A thread is never split across
+// chunks; a thread larger than the budget is written to a chunk of its own.
+func (e *Exporter) ExportChunks(channelID, outputPath string) error {
+	total, err := e.db.CountMessages(channelID, e.options.Filter)
+	if err != nil {
+		return fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	if total == 0 {
+		return fmt.Errorf("no messages found for channel %s", channelID)
+	}
+
+	channelName, err := e.db.GetChannelName(channelID)
+	if err != nil {
+		return fmt.Errorf("failed to get channel: %w", err)
+	}
+
+	tokenizer := e.options.Tokenizer
+	if tokenizer == nil {
+		tokenizer = estimateTokenizer{}
+	}
+
+	budget := e.options.ChunkTokens
+	if budget <= 0 {
+		budget = defaultChunkTokens
+	}
+
+	cw := &chunkWriter{
+		exporter:    e,
+		tokenizer:   tokenizer,
+		budget:      budget,
+		overlap:     e.options.ChunkOverlap,
+		channelID:   channelID,
+		channelName: channelName,
+		basePath:    strings.TrimSuffix(outputPath, filepath.Ext(outputPath)),
+	}
+
+	err = e.eachMessage(channelID, func(msg MessageWithReplies) error {
+		return cw.add(e.newChunkUnit(msg, tokenizer))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export messages: %w", err)
+	}
+
+	if err := cw.flush(); err != nil {
+		return err
+	}
+
+	log.Printf("Wrote %d chunks for channel %s", cw.written, channelID)
+	return nil
+}
+
+func (e *Exporter) newChunkUnit(msg MessageWithReplies, tokenizer Tokenizer) chunkUnit {
+	var buf bytes.Buffer
+	e.writeTextMessage(&buf, msg)
+
+	unit := chunkUnit{
+		text:     buf.String(),
+		firstTS:  msg.Timestamp,
+		lastTS:   msg.Timestamp,
+		messages: 1 + len(msg.Replies),
+	}
+	unit.tokens = tokenizer.CountTokens(unit.text)
+
+	seen := make(map[string]bool)
+	addParticipant := func(userID, userName, realName, displayName string) {
+		display := e.formatUserDisplay(userID, userName, realName, displayName)
+		if !seen[display] {
+			seen[display] = true
+			unit.participants = append(unit.participants, display)
+		}
+	}
+
+	addParticipant(msg.UserID, msg.UserName, msg.UserRealName, msg.UserDisplayName)
+	for _, reply := range msg.Replies {
+		addParticipant(reply.UserID, reply.UserName, reply.UserRealName, reply.UserDisplayName)
+		if reply.Timestamp > unit.lastTS {
+			unit.lastTS = reply.Timestamp
+		}
+	}
+
+	return unit
+}
+
+func (cw *chunkWriter) add(unit chunkUnit) error {
+	if len(cw.units) > cw.carried && cw.tokens(append(cw.units, unit)) > cw.budget {
+		if err := cw.flush(); err != nil {
+			return err
+		}
+	}
+
+	// Carried-over context is dropped, oldest first, when it would push the
+	// next unit over budget.
+	for cw.carried > 0 && cw.tokens(append(cw.units, unit)) > cw.budget {
+		cw.units = cw.units[1:]
+		cw.carried--
+	}
+
+	if len(cw.units) == 0 && cw.tokens([]chunkUnit{unit}) > cw.budget {
+		log.Printf("Warning: thread %s (%d tokens) exceeds the chunk budget of %d tokens", unit.firstTS, unit.tokens, cw.budget)
+	}
+
+	cw.units = append(cw.units, unit)
+	return nil
+}
+
+func (cw *chunkWriter) tokens(units []chunkUnit) int {
+	total := cw.tokenizer.CountTokens(cw.header(units, 0))
+	for _, unit := range units {
+		total += unit.tokens
+	}
+	return total
+}
+
+func (cw *chunkWriter) header(units []chunkUnit, tokens int) string {
+	var b strings.Builder
+
+	firstTS, lastTS := "", ""
+	var participants []string
+	seen := make(map[string]bool)
+	messages := 0
+	for _, unit := range units {
+		if firstTS == "" || unit.firstTS < firstTS {
+			firstTS = unit.firstTS
+		}
+		if unit.lastTS > lastTS {
+			lastTS = unit.lastTS
+		}
+		for _, participant := range unit.participants {
+			if !seen[participant] {
+				seen[participant] = true
+				participants = append(participants, participant)
+			}
+		}
+		messages += unit.messages
+	}
+
+	fmt.Fprintf(&b, "# Slack Channel Export: #%s (chunk %d)\n", cw.channelName, cw.written+1)
+	fmt.Fprintf(&b, "Channel ID: %s\n", cw.channelID)
+	fmt.Fprintf(&b, "Date Range: %s - %s\n", cw.exporter.formatTimestamp(firstTS, ""), cw.exporter.formatTimestamp(lastTS, ""))
+	fmt.Fprintf(&b, "Participants: %s\n", strings.Join(participants, ", "))
+	fmt.Fprintf(&b, "Threads: %d (%d messages)\n", len(units), messages)
+	if cw.carried > 0 {
+		fmt.Fprintf(&b, "Overlap: first %d thread(s) repeated from the previous chunk for context\n", cw.carried)
+	}
+	if tokens > 0 {
+		fmt.Fprintf(&b, "Estimated Tokens: %d\n", tokens)
+	}
+	fmt.Fprintf(&b, "Export Date: %s\n\n", time.Now().In(cw.exporter.location()).Format(cw.exporter.timeLayout))
+	b.WriteString(strings.Repeat("=", 71) + "\n\n")
+
+	return b.String()
+}
+
+func (cw *chunkWriter) flush() error {
+	if len(cw.units) <= cw.carried {
+		return nil
+	}
+
+	outputPath := fmt.Sprintf("%s_part%04d.txt", cw.basePath, cw.written+1)
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(cw.header(cw.units, cw.tokens(cw.units))); err != nil {
+		return err
+	}
+	for _, unit := range cw.units {
+		if _, err := file.WriteString(unit.text); err != nil {
+			return err
+		}
+	}
+	cw.written++
+
+	var carried []chunkUnit
+	if cw.overlap > 0 {
+		tokens := 0
+		for i := len(cw.units) - 1; i >= 0; i-- {
+			tokens += cw.units[i].tokens
+			if tokens > cw.overlap {
+				break
+			}
+			carried = append([]chunkUnit{cw.units[i]}, carried...)
+		}
+	}
+	cw.units = carried
+	cw.carried = len(carried)
+
+	return nil
+}
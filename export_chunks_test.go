@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// zeroTokenizer counts nothing, so chunk sizes come from the units' own
+// token counts alone.
+type zeroTokenizer struct{}
+
+func (zeroTokenizer) CountTokens(string) int { return 0 }
+
+func readChunks(t *testing.T, basePath string) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(basePath + "_part*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := make([]string, len(paths))
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks[i] = string(data)
+	}
+	return chunks
+}
+
+// chunkThreads returns the unit texts in a chunk, in order.
+func chunkThreads(chunk string) []string {
+	_, body, _ := strings.Cut(chunk, strings.Repeat("=", 71)+"\n\n")
+	return strings.Fields(body)
+}
+
+func TestChunkWriterBoundaries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		budget   int
+		overlap  int
+		tokens   []int
+		want     [][]string
+		overlaps []int
+	}{
+		{"fills up to the budget", 10, 0, []int{4, 4, 4}, [][]string{{"t1", "t2"}, {"t3"}}, []int{0, 0}},
+		{"exactly at the budget", 10, 0, []int{5, 5, 5}, [][]string{{"t1", "t2"}, {"t3"}}, []int{0, 0}},
+		{"oversized thread gets its own chunk", 10, 0, []int{3, 15, 3}, [][]string{{"t1"}, {"t2"}, {"t3"}}, []int{0, 0, 0}},
+		{"overlap repeats the last threads", 10, 4, []int{4, 4, 4, 4}, [][]string{{"t1", "t2"}, {"t2", "t3"}, {"t3", "t4"}}, []int{0, 1, 1}},
+		{"overlap is dropped when it does not fit", 10, 8, []int{6, 6}, [][]string{{"t1"}, {"t2"}}, []int{0, 0}},
+		{"overlap larger than the last thread only", 20, 5, []int{4, 6, 12}, [][]string{{"t1", "t2"}, {"t3"}}, []int{0, 0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			basePath := filepath.Join(t.TempDir(), "general")
+			cw := &chunkWriter{
+				exporter:    NewExporter(NewMemoryStore(), ExportOptions{Location: time.UTC}),
+				tokenizer:   zeroTokenizer{},
+				budget:      tc.budget,
+				overlap:     tc.overlap,
+				channelID:   "C1",
+				channelName: "general",
+				basePath:    basePath,
+			}
+			for i, tokens := range tc.tokens {
+				unit := chunkUnit{
+					text:     "t" + string(rune('1'+i)) + "\n",
+					tokens:   tokens,
+					firstTS:  "1704067200.000100",
+					lastTS:   "1704067200.000100",
+					messages: 1,
+				}
+				if err := cw.add(unit); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := cw.flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			chunks := readChunks(t, basePath)
+			if len(chunks) != len(tc.want) || cw.written != len(tc.want) {
+				t.Fatalf("wrote %d chunks (%d files), want %d", cw.written, len(chunks), len(tc.want))
+			}
+			for i, chunk := range chunks {
+				if got := chunkThreads(chunk); strings.Join(got, ",") != strings.Join(tc.want[i], ",") {
+					t.Errorf("chunk %d = %v, want %v", i+1, got, tc.want[i])
+				}
+				overlap := strings.Contains(chunk, "Overlap: first 1 thread(s)")
+				if overlap != (tc.overlaps[i] == 1) {
+					t.Errorf("chunk %d overlap header = %v, want %d repeated threads", i+1, overlap, tc.overlaps[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExportChunks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		seedStore(t, store)
+
+		basePath := filepath.Join(t.TempDir(), "general")
+		exporter := NewExporter(store, ExportOptions{Format: "chunks", ChunkTokens: 100000, Location: time.UTC})
+		if err := exporter.ExportChunks("C1", basePath+".txt"); err != nil {
+			t.Fatalf("ExportChunks failed: %v", err)
+		}
+		chunks := readChunks(t, basePath)
+		if len(chunks) != 1 {
+			t.Fatalf("wrote %d chunks, want 1", len(chunks))
+		}
+		for _, want := range []string{
+			"# Slack Channel Export: #general (chunk 1)\n",
+			"Channel ID: C1\n",
+			"Date Range: 2024-01-01 00:00:00 UTC - 2024-01-02 00:00:00 UTC\n",
+			"Participants: ali (@alice), bobby (@bob)\n",
+			"Threads: 3 (5 messages)\n",
+			"Estimated Tokens: ",
+		} {
+			if !strings.Contains(chunks[0], want) {
+				t.Errorf("chunk is missing %q:\n%s", want, chunks[0])
+			}
+		}
+
+		// With a tiny budget every thread is written on its own, replies
+		// included.
+		basePath = filepath.Join(t.TempDir(), "general")
+		exporter = NewExporter(store, ExportOptions{Format: "chunks", ChunkTokens: 1, Location: time.UTC})
+		if err := exporter.ExportChunks("C1", basePath+".txt"); err != nil {
+			t.Fatalf("ExportChunks failed: %v", err)
+		}
+		chunks = readChunks(t, basePath)
+		if len(chunks) != 3 {
+			t.Fatalf("wrote %d chunks, want one per thread", len(chunks))
+		}
+		if !strings.Contains(chunks[0], "Deploy started") || !strings.Contains(chunks[0], "Rollback plan is ready") || !strings.Contains(chunks[0], "thanks") {
+			t.Errorf("first chunk does not hold the whole thread:\n%s", chunks[0])
+		}
+		if !strings.Contains(chunks[2], "(chunk 3)") || !strings.Contains(chunks[2], "next day") {
+			t.Errorf("last chunk = %q, want chunk 3 with the last message", chunks[2])
+		}
+	})
+}
+
+func TestTokenizers(t *testing.T) {
+	for _, tc := range []struct {
+		tokenizer string
+		text      string
+		want      int
+	}{
+		{"estimate", "", 0},
+		{"estimate", "abcd", 1},
+		{"estimate", "abcde", 2},
+		{"estimate", "日本語テキスト", 7},
+		{"estimate", "日本 abc", 3},
+		{"chars", "日本語テキスト", 2},
+		{"words", "one two three", 4},
+	} {
+		tokenizer, err := NewTokenizer(tc.tokenizer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tokenizer.CountTokens(tc.text); got != tc.want {
+			t.Errorf("%s.CountTokens(%q) = %d, want %d", tc.tokenizer, tc.text, got, tc.want)
+		}
+	}
+
+	if _, err := NewTokenizer("tiktoken"); err == nil || !strings.Contains(err.Error(), "available: chars, estimate, words") {
+		t.Errorf("unknown tokenizer error = %v", err)
+	}
+}
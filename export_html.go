@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultHTMLPageSize = 500
+
+type htmlChannel struct {
+	ID           string
+	Name         string
+	MessageCount int
+	TotalPages   int
+}
+
+type htmlIndexPage struct {
+	ExportDate string
+	Channels   []htmlChannel
+}
+
+type htmlChannelPage struct {
+	Root        string
+	ChannelID   string
+	ChannelName string
+	Page        int
+	TotalPages  int
+	PrevPage    string
+	NextPage    string
+	Messages    []htmlMessage
+}
+
+type htmlMessage struct {
+	Anchor  string
+	Time    string
+	TS      string
+	User    string
+	Avatar  string
+	Text    template.HTML
+	Replies []htmlMessage
+}
+
+type htmlSearchEntry struct {
+	Channel string `json:"c"`
+	Page    string `json:"p"`
+	User    string `json:"u"`
+	Time    string `json:"d"`
+	Text    string `json:"t"`
+}
+
+// ExportHTML writes a static site that can be opened directly from disk:
+// an index of channels, paginated channel pages and a client-side search.
+// When channelID is empty every channel in the database is exported.
+func (e *Exporter) ExportHTML(outputDir, channelID string) error {
+	if err := os.MkdirAll(filepath.Join(outputDir, "assets"), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	allChannels, err := e.db.GetChannels()
+	if err != nil {
+		return fmt.Errorf("failed to get channels: %w", err)
+	}
+
+	include, err := e.channelFilter()
+	if err != nil {
+		return err
+	}
+
+	var channels []htmlChannel
+	for id, name := range allChannels {
+		if channelID != "" && id != channelID {
+			continue
+		}
+		if channelID == "" && !include(id, name) {
+			continue
+		}
+		channels = append(channels, htmlChannel{ID: id, Name: name})
+	}
+	if len(channels) == 0 {
+		return fmt.Errorf("no channels found in database")
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+
+	users, err := e.db.GetUsers()
+	if err != nil {
+		return fmt.Errorf("failed to get users: %w", err)
+	}
+
+	avatars := e.prepareAvatars(outputDir, users)
+	renderer := newMrkdwnRenderer(users, allChannels)
+
+	for name, content := range map[string]string{
+		"assets/style.css": htmlStyleSheet,
+		"assets/search.js": htmlSearchScript,
+		"search.html":      htmlSearchPage,
+	} {
+		if err := os.WriteFile(filepath.Join(outputDir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	indexFile, err := os.Create(filepath.Join(outputDir, "search-index.js"))
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+	defer indexFile.Close()
+
+	index := bufio.NewWriter(indexFile)
+	index.WriteString("window.SEARCH_INDEX = [\n")
+	indexEncoder := json.NewEncoder(index)
+	indexEncoder.SetEscapeHTML(false)
+
+	for i := range channels {
+		if err := e.exportHTMLChannel(outputDir, &channels[i], renderer, avatars, indexEncoder, index); err != nil {
+			return fmt.Errorf("failed to export channel %s: %w", channels[i].ID, err)
+		}
+		fmt.Printf("Exported channel #%s (%d pages)\n", channels[i].Name, channels[i].TotalPages)
+	}
+
+	index.WriteString("];\n")
+	if err := index.Flush(); err != nil {
+		return fmt.Errorf("failed to write search index: %w", err)
+	}
+
+	return writeHTMLTemplate(filepath.Join(outputDir, "index.html"), htmlIndexTemplate, htmlIndexPage{
+		ExportDate: time.Now().In(e.location()).Format(e.timeLayout),
+		Channels:   channels,
+	})
+}
+
+func (e *Exporter) exportHTMLChannel(outputDir string, channel *htmlChannel, renderer *mrkdwnRenderer, avatars map[string]string, indexEncoder *json.Encoder, index *bufio.Writer) error {
+	count, err := e.db.CountMessages(channel.ID, e.options.Filter)
+	if err != nil {
+		return err
+	}
+
+	pageSize := e.options.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultHTMLPageSize
+	}
+
+	channel.MessageCount = count
+	channel.TotalPages = (count + pageSize - 1) / pageSize
+	if channel.TotalPages == 0 {
+		channel.TotalPages = 1
+	}
+
+	channelDir := filepath.Join(outputDir, "channels", channel.ID)
+	if err := os.MkdirAll(channelDir, 0755); err != nil {
+		return err
+	}
+
+	page := htmlChannelPage{
+		Root:        "../../",
+		ChannelID:   channel.ID,
+		ChannelName: channel.Name,
+		Page:        1,
+		TotalPages:  channel.TotalPages,
+	}
+
+	writePage := func() error {
+		page.PrevPage, page.NextPage = "", ""
+		if page.Page > 1 {
+			page.PrevPage = htmlPageFile(page.Page - 1)
+		}
+		if page.Page < page.TotalPages {
+			page.NextPage = htmlPageFile(page.Page + 1)
+		}
+		if err := writeHTMLTemplate(filepath.Join(channelDir, htmlPageFile(page.Page)), htmlChannelTemplate, page); err != nil {
+			return err
+		}
+		page.Page++
+		page.Messages = page.Messages[:0]
+		return nil
+	}
+
+	toHTML := func(ts, userID, userName, realName, displayName, userTZ, text string) (htmlMessage, error) {
+		msg := htmlMessage{
+			Anchor: htmlAnchor(ts),
+			Time:   e.formatTimestamp(ts, userTZ),
+			TS:     ts,
+			User:   e.formatUserDisplay(userID, userName, realName, displayName),
+			Avatar: avatars[userID],
+			Text:   renderer.Render(text),
+		}
+		if msg.Avatar != "" && !strings.Contains(msg.Avatar, "://") {
+			msg.Avatar = page.Root + msg.Avatar
+		}
+
+		err := indexEncoder.Encode(htmlSearchEntry{
+			Channel: channel.Name,
+			Page:    path.Join("channels", channel.ID, htmlPageFile(page.Page)) + "#" + msg.Anchor,
+			User:    msg.User,
+			Time:    msg.Time,
+			Text:    renderer.Plain(text),
+		})
+		if err == nil {
+			_, err = index.WriteString(",")
+		}
+		return msg, err
+	}
+
+	err = e.eachMessage(channel.ID, func(msg MessageWithReplies) error {
+		parent, err := toHTML(msg.Timestamp, msg.UserID, msg.UserName, msg.UserRealName, msg.UserDisplayName, msg.UserTZ, msg.Text)
+		if err != nil {
+			return err
+		}
+		for _, reply := range msg.Replies {
+			child, err := toHTML(reply.Timestamp, reply.UserID, reply.UserName, reply.UserRealName, reply.UserDisplayName, reply.UserTZ, reply.Text)
+			if err != nil {
+				return err
+			}
+			parent.Replies = append(parent.Replies, child)
+		}
+
+		page.Messages = append(page.Messages, parent)
+		if len(page.Messages) >= pageSize {
+			return writePage()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(page.Messages) > 0 || page.Page == 1 {
+		return writePage()
+	}
+	return nil
+}
+
+// prepareAvatars maps user IDs to avatar URLs. When avatar downloading is
+// enabled the images are stored under avatars/ and referenced relatively, so
+// the archive keeps working offline.
+func (e *Exporter) prepareAvatars(outputDir string, users []User) map[string]string {
+	avatars := make(map[string]string, len(users))
+	if !e.options.DownloadAvatars {
+		for _, user := range users {
+			avatars[user.ID] = user.ProfileImage
+		}
+		return avatars
+	}
+
+	avatarDir := filepath.Join(outputDir, "avatars")
+	if err := os.MkdirAll(avatarDir, 0755); err != nil {
+		log.Printf("Failed to create avatar directory: %v", err)
+		return avatars
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, user := range users {
+		if user.ProfileImage == "" {
+			continue
+		}
+
+		ext := ".png"
+		if u, err := url.Parse(user.ProfileImage); err == nil && path.Ext(u.Path) != "" {
+			ext = path.Ext(u.Path)
+		}
+
+		name := user.ID + ext
+		localPath := filepath.Join(avatarDir, name)
+		if _, err := os.Stat(localPath); err != nil {
+			if err := downloadFile(client, user.ProfileImage, localPath); err != nil {
+				log.Printf("Failed to download avatar for %s: %v", user.ID, err)
+				avatars[user.ID] = user.ProfileImage
+				continue
+			}
+		}
+		avatars[user.ID] = "avatars/" + name
+	}
+
+	return avatars
+}
+
+func downloadFile(client *http.Client, sourceURL, destPath string) error {
+	resp, err := client.Get(sourceURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	file, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(destPath)
+		return err
+	}
+	return file.Close()
+}
+
+func writeHTMLTemplate(outputPath string, tmpl *template.Template, data interface{}) error {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := tmpl.Execute(w, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func htmlPageFile(page int) string {
+	return fmt.Sprintf("page-%d.html", page)
+}
+
+func htmlAnchor(ts string) string {
+	return "m" + strings.ReplaceAll(ts, ".", "-")
+}
+
+var htmlIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Slack Archive</title>
+<link rel="stylesheet" href="assets/style.css">
+</head>
+<body>
+<header><h1>Slack Archive</h1><a href="search.html">Search</a></header>
+<main>
+<p class="meta">Export Date: {{.ExportDate}}</p>
+<ul class="channels">
+{{- range .Channels}}
+<li><a href="channels/{{.ID}}/page-1.html">#{{.Name}}</a> <span class="meta">{{.ID}} &middot; {{.MessageCount}} messages</span></li>
+{{- end}}
+</ul>
+</main>
+</body>
+</html>
+`))
+
+var htmlChannelTemplate = template.Must(template.New("channel").Parse(`{{define "message"}}
+<div class="message" id="{{.Anchor}}">
+{{if .Avatar}}<img class="avatar" src="{{.Avatar}}" alt="" loading="lazy">{{else}}<div class="avatar"></div>{{end}}
+<div class="body">
+<div class="header"><span class="user">{{.User}}</span> <a class="time" href="#{{.Anchor}}" title="{{.TS}}">{{.Time}}</a></div>
+<div class="text">{{.Text}}</div>
+{{- if .Replies}}
+<details class="thread">
+<summary>{{len .Replies}} replies</summary>
+{{range .Replies}}{{template "message" .}}{{end}}
+</details>
+{{- end}}
+</div>
+</div>
+{{end}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>#{{.ChannelName}} ({{.Page}}/{{.TotalPages}})</title>
+<link rel="stylesheet" href="{{.Root}}assets/style.css">
+</head>
+<body>
+<header><h1>#{{.ChannelName}}</h1><a href="{{.Root}}index.html">Channels</a> <a href="{{.Root}}search.html">Search</a></header>
+<main>
+{{- range .Messages}}{{template "message" .}}{{end}}
+</main>
+<nav class="pager">
+{{if .PrevPage}}<a href="{{.PrevPage}}">&larr; Previous</a>{{end}}
+<span>Page {{.Page}} / {{.TotalPages}}</span>
+{{if .NextPage}}<a href="{{.NextPage}}">Next &rarr;</a>{{end}}
+</nav>
+</body>
+</html>
+`))
+
+const htmlSearchPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Search - Slack Archive</title>
+<link rel="stylesheet" href="assets/style.css">
+</head>
+<body>
+<header><h1>Search</h1><a href="index.html">Channels</a></header>
+<main>
+<input id="query" type="search" placeholder="Search messages..." autofocus>
+<p id="status" class="meta"></p>
+<ul id="results" class="results"></ul>
+</main>
+<script src="search-index.js"></script>
+<script src="assets/search.js"></script>
+</body>
+</html>
+`
+
+const htmlSearchScript = `(function () {
+  var input = document.getElementById("query");
+  var results = document.getElementById("results");
+  var status = document.getElementById("status");
+  var index = window.SEARCH_INDEX || [];
+  var maxResults = 200;
+
+  function escapeHTML(s) {
+    return s.replace(/[&<>"']/g, function (c) {
+      return { "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;" }[c];
+    });
+  }
+
+  function snippet(text, term) {
+    var pos = text.toLowerCase().indexOf(term);
+    var start = Math.max(0, pos - 60);
+    var part = text.substr(start, 200);
+    var escaped = escapeHTML(part);
+    var re = new RegExp(escapeHTML(term).replace(/[.*+?^${}()|[\]\\]/g, "\\$&"), "gi");
+    return (start > 0 ? "…" : "") + escaped.replace(re, function (m) { return "<mark>" + m + "</mark>"; });
+  }
+
+  function search() {
+    var terms = input.value.toLowerCase().split(/\s+/).filter(Boolean);
+    results.innerHTML = "";
+    if (terms.length === 0) {
+      status.textContent = "";
+      return;
+    }
+    var found = 0;
+    for (var i = 0; i < index.length && found < maxResults; i++) {
+      var entry = index[i];
+      var haystack = (entry.t + " " + entry.u).toLowerCase();
+      if (!terms.every(function (t) { return haystack.indexOf(t) !== -1; })) {
+        continue;
+      }
+      found++;
+      var li = document.createElement("li");
+      li.innerHTML = '<a href="' + entry.p + '">#' + escapeHTML(entry.c) + " &middot; " +
+        escapeHTML(entry.u) + " &middot; " + escapeHTML(entry.d) + "</a><div>" +
+        snippet(entry.t, terms[0]) + "</div>";
+      results.appendChild(li);
+    }
+    status.textContent = found >= maxResults ? "Showing first " + maxResults + " results" : found + " results";
+  }
+
+  input.addEventListener("input", search);
+})();
+`
+
+const htmlStyleSheet = `body { font-family: -apple-system, "Segoe UI", "Hiragino Sans", "Noto Sans JP", sans-serif; margin: 0; color: #1d1c1d; }
+header { background: #3f0e40; color: #fff; padding: 12px 24px; display: flex; align-items: baseline; gap: 16px; }
+header h1 { font-size: 20px; margin: 0; }
+header a { color: #fff; }
+main { max-width: 960px; margin: 0 auto; padding: 16px 24px; }
+.meta { color: #616061; font-size: 13px; }
+.channels li { margin: 6px 0; }
+.message { display: flex; gap: 10px; padding: 8px 0; border-bottom: 1px solid #eee; }
+.message:target { background: #fff8db; }
+.avatar { width: 36px; height: 36px; border-radius: 4px; background: #ddd; flex-shrink: 0; }
+.body { flex: 1; min-width: 0; }
+.user { font-weight: bold; }
+.time { color: #616061; font-size: 12px; text-decoration: none; }
+.text { white-space: normal; overflow-wrap: anywhere; }
+.mention { background: #e8f5fa; color: #1264a3; border-radius: 3px; padding: 0 2px; }
+pre, code { background: #f6f6f6; border: 1px solid #ddd; border-radius: 3px; font-family: Menlo, Consolas, monospace; }
+pre { padding: 8px; white-space: pre-wrap; }
+blockquote { border-left: 4px solid #ddd; margin: 0; padding-left: 8px; color: #616061; }
+.thread { margin-top: 6px; }
+.thread summary { cursor: pointer; color: #1264a3; }
+.pager { text-align: center; padding: 16px; display: flex; justify-content: center; gap: 16px; }
+#query { width: 100%; font-size: 16px; padding: 8px; box-sizing: border-box; }
+.results li { margin: 10px 0; }
+mark { background: #fde68a; }
+`
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportHTML(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		seedStore(t, store)
+		if err := store.SaveMessage("1704240000.000100", "C1", "U2", "&lt;script&gt;alert(1)&lt;/script&gt; see <#C2>", "", 0, "T1"); err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+		exporter := NewExporter(store, ExportOptions{Format: "html", PageSize: 2, Location: time.UTC})
+		if err := exporter.ExportHTML(dir, ""); err != nil {
+			t.Fatalf("ExportHTML failed: %v", err)
+		}
+
+		read := func(name string) string {
+			t.Helper()
+			data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", name, err)
+			}
+			return string(data)
+		}
+
+		index := read("index.html")
+		for _, want := range []string{
+			`<html lang="en">`,
+			`<a href="channels/C1/page-1.html">#general</a>`,
+			`<a href="channels/C2/page-1.html">#random</a>`,
+			"C1 &middot; 4 messages",
+		} {
+			if !strings.Contains(index, want) {
+				t.Errorf("index.html is missing %q", want)
+			}
+		}
+		if strings.Index(index, "#general") > strings.Index(index, "#random") {
+			t.Error("index.html does not list channels by name")
+		}
+
+		// Four messages at two per page fill exactly two pages.
+		page1, page2 := read("channels/C1/page-1.html"), read("channels/C1/page-2.html")
+		if _, err := os.Stat(filepath.Join(dir, "channels", "C1", "page-3.html")); !os.IsNotExist(err) {
+			t.Errorf("page-3.html exists for a channel with two full pages: %v", err)
+		}
+		for _, want := range []string{
+			`<html lang="en">`,
+			`<a href="page-2.html">Next &rarr;</a>`,
+			"<span>Page 1 / 2</span>",
+			`id="m1704067200-000100"`,
+			"<summary>2 replies</summary>",
+			"Rollback plan is ready",
+			`href="../../assets/style.css"`,
+		} {
+			if !strings.Contains(page1, want) {
+				t.Errorf("page-1.html is missing %q", want)
+			}
+		}
+		if strings.Contains(page1, "&larr; Previous") {
+			t.Error("page-1.html links to a previous page")
+		}
+		if !strings.Contains(page2, `<a href="page-1.html">&larr; Previous</a>`) || strings.Contains(page2, "Next &rarr;") {
+			t.Error("page-2.html should link back to page 1 only")
+		}
+		if strings.Contains(page2, "<script>alert") || !strings.Contains(page2, "&lt;script&gt;") {
+			t.Error("page-2.html does not escape HTML in Slack-escaped text")
+		}
+		if !strings.Contains(page2, "#random") {
+			t.Error("page-2.html does not render the channel mention by name")
+		}
+
+		search := read("search-index.js")
+		if !strings.HasPrefix(search, "window.SEARCH_INDEX = [\n") || !strings.HasSuffix(search, "];\n") {
+			t.Errorf("search-index.js is not a SEARCH_INDEX array:\n%s", search)
+		}
+		// One entry per message and reply: 4 messages and 2 replies in C1, 1 in C2.
+		if n := strings.Count(search, `"c":"general"`); n != 6 {
+			t.Errorf("search index has %d entries for #general, want 6", n)
+		}
+		if !strings.Contains(search, `"p":"channels/C1/page-2.html#m1704240000-000100"`) {
+			t.Error("search index does not link the last message to page 2")
+		}
+
+		for _, name := range []string{"search.html", "assets/style.css", "assets/search.js"} {
+			read(name)
+		}
+	})
+}
+
+func TestExportHTMLSingleChannel(t *testing.T) {
+	store := NewMemoryStore()
+	seedStore(t, store)
+
+	dir := t.TempDir()
+	if err := NewExporter(store, ExportOptions{Format: "html"}).ExportHTML(dir, "C2"); err != nil {
+		t.Fatalf("ExportHTML failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "channels", "C1")); !os.IsNotExist(err) {
+		t.Errorf("exporting C2 also wrote C1: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "channels", "C2", "page-1.html")); err != nil {
+		t.Errorf("C2 was not exported: %v", err)
+	}
+
+	if err := NewExporter(store, ExportOptions{Format: "html"}).ExportHTML(t.TempDir(), "C9"); err == nil {
+		t.Error("exporting an unknown channel: want an error")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+type jsonlRecord struct {
+	Type            string `json:"type"`
+	ChannelID       string `json:"channel_id"`
+	ChannelName     string `json:"channel_name"`
+	TeamID          string `json:"team_id,omitempty"`
+	TS              string `json:"ts"`
+	Time            string `json:"time"`
+	ThreadTS        string `json:"thread_ts,omitempty"`
+	UserID          string `json:"user_id"`
+	UserName        string `json:"user_name,omitempty"`
+	UserRealName    string `json:"user_real_name,omitempty"`
+	UserDisplayName string `json:"user_display_name,omitempty"`
+	Text            string `json:"text"`
+	ReplyCount      int    `json:"reply_count,omitempty"`
+}
+
+func (e *Exporter) ExportToJSON(channelID, outputPath string) error {
+	return exportToFile(outputPath, func(w io.Writer) error {
+		return e.WriteJSON(w, channelID)
+	})
+}
+
+func (e *Exporter) WriteJSON(w io.Writer, channelID string) error {
+	total, err := e.db.CountMessages(channelID, e.options.Filter)
+	if err != nil {
+		return fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	if total == 0 {
+		return fmt.Errorf("no messages found for channel %s", channelID)
+	}
+
+	channelName, err := e.db.GetChannelName(channelID)
+	if err != nil {
+		return fmt.Errorf("failed to get channel: %w", err)
+	}
+
+	// The header fields are written one by one so that the messages array
+	// can be streamed into the same document.
+	io.WriteString(w, "{")
+	for _, field := range []struct {
+		key   string
+		value interface{}
+	}{
+		{"channel_id", channelID},
+		{"channel_name", channelName},
+		{"export_date", time.Now().In(e.location()).Format(time.RFC3339)},
+		{"total_messages", total},
+	} {
+		value, err := marshalIndentJSON(field.value, "")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n  %q: %s,", field.key, value)
+	}
+	io.WriteString(w, "\n  \"messages\": [")
+
+	first := true
+	err = e.eachMessage(channelID, func(msg MessageWithReplies) error {
+		if msg.Replies == nil {
+			msg.Replies = []Reply{}
+		}
+
+		data, err := marshalIndentJSON(msg, "    ")
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			io.WriteString(w, ",")
+		}
+		first = false
+
+		io.WriteString(w, "\n    ")
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export messages: %w", err)
+	}
+
+	_, err = io.WriteString(w, "\n  ]\n}\n")
+	return err
+}
+
+func (e *Exporter) ExportToJSONL(channelID, outputPath string) error {
+	return exportToFile(outputPath, func(w io.Writer) error {
+		return e.WriteJSONL(w, channelID)
+	})
+}
+
+func (e *Exporter) WriteJSONL(w io.Writer, channelID string) error {
+	total, err := e.db.CountMessages(channelID, e.options.Filter)
+	if err != nil {
+		return fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	if total == 0 {
+		return fmt.Errorf("no messages found for channel %s", channelID)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	err = e.eachMessage(channelID, func(msg MessageWithReplies) error {
+		if err := encoder.Encode(jsonlRecord{
+			Type:            "message",
+			ChannelID:       msg.ChannelID,
+			ChannelName:     msg.ChannelName,
+			TeamID:          msg.TeamID,
+			TS:              msg.Timestamp,
+			Time:            e.formatISOTimestamp(msg.Timestamp),
+			ThreadTS:        msg.ThreadTS,
+			UserID:          msg.UserID,
+			UserName:        msg.UserName,
+			UserRealName:    msg.UserRealName,
+			UserDisplayName: msg.UserDisplayName,
+			Text:            msg.Text,
+			ReplyCount:      msg.ReplyCount,
+		}); err != nil {
+			return err
+		}
+
+		for _, reply := range msg.Replies {
+			if err := encoder.Encode(jsonlRecord{
+				Type:            "reply",
+				ChannelID:       msg.ChannelID,
+				ChannelName:     msg.ChannelName,
+				TeamID:          msg.TeamID,
+				TS:              reply.Timestamp,
+				Time:            e.formatISOTimestamp(reply.Timestamp),
+				ThreadTS:        msg.ThreadTS,
+				UserID:          reply.UserID,
+				UserName:        reply.UserName,
+				UserRealName:    reply.UserRealName,
+				UserDisplayName: reply.UserDisplayName,
+				Text:            reply.Text,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export messages: %w", err)
+	}
+
+	return nil
+}
+
+func marshalIndentJSON(v interface{}, prefix string) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent(prefix, "  ")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteJSON(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		seedStore(t, store)
+
+		var buf bytes.Buffer
+		exporter := NewExporter(store, ExportOptions{Format: "json", Location: time.UTC})
+		if err := exporter.WriteJSON(&buf, "C1"); err != nil {
+			t.Fatalf("WriteJSON failed: %v", err)
+		}
+
+		var doc struct {
+			ChannelID     string               `json:"channel_id"`
+			ChannelName   string               `json:"channel_name"`
+			ExportDate    string               `json:"export_date"`
+			TotalMessages int                  `json:"total_messages"`
+			Messages      []MessageWithReplies `json:"messages"`
+		}
+		decoder := json.NewDecoder(&buf)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&doc); err != nil {
+			t.Fatalf("output is not a JSON document: %v", err)
+		}
+
+		if doc.ChannelID != "C1" || doc.ChannelName != "general" || doc.TotalMessages != 3 {
+			t.Errorf("header = %q, %q, %d; want C1, general, 3", doc.ChannelID, doc.ChannelName, doc.TotalMessages)
+		}
+		if _, err := time.Parse(time.RFC3339, doc.ExportDate); err != nil {
+			t.Errorf("export_date %q is not RFC 3339: %v", doc.ExportDate, err)
+		}
+		if len(doc.Messages) != 3 {
+			t.Fatalf("messages = %d, want 3", len(doc.Messages))
+		}
+
+		thread := doc.Messages[0]
+		if thread.Timestamp != "1704067200.000100" || thread.UserName != "alice" || thread.ReplyCount != 2 || len(thread.Replies) != 2 {
+			t.Errorf("thread = %+v, want alice's message with 2 replies", thread)
+		}
+		if reply := thread.Replies[0]; reply.UserName != "bob" || reply.Text != "Rollback plan is ready" {
+			t.Errorf("first reply = %+v, want bob's", reply)
+		}
+	})
+}
+
+func TestWriteJSONEmptyReplies(t *testing.T) {
+	store := NewMemoryStore()
+	seedStore(t, store)
+
+	var buf bytes.Buffer
+	if err := NewExporter(store, ExportOptions{Format: "json"}).WriteJSON(&buf, "C2"); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	// Messages without replies have an empty array, not null.
+	if !strings.Contains(buf.String(), `"replies": []`) {
+		t.Errorf("output has no empty replies array:\n%s", buf.String())
+	}
+
+	if err := NewExporter(store, ExportOptions{Format: "json"}).WriteJSON(&buf, "C9"); err == nil {
+		t.Error("WriteJSON of an empty channel: want an error")
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		seedStore(t, store)
+
+		var buf bytes.Buffer
+		exporter := NewExporter(store, ExportOptions{Format: "jsonl", Location: time.UTC})
+		if err := exporter.WriteJSONL(&buf, "C1"); err != nil {
+			t.Fatalf("WriteJSONL failed: %v", err)
+		}
+
+		var records []jsonlRecord
+		scanner := bufio.NewScanner(&buf)
+		for scanner.Scan() {
+			var record jsonlRecord
+			decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&record); err != nil {
+				t.Fatalf("line %q is not a record: %v", scanner.Text(), err)
+			}
+			records = append(records, record)
+		}
+
+		want := []struct{ typ, ts, threadTS, user string }{
+			{"message", "1704067200.000100", "1704067200.000100", "alice"},
+			{"reply", "1704067260.000100", "1704067200.000100", "bob"},
+			{"reply", "1704067320.000100", "1704067200.000100", "alice"},
+			{"message", "1704070800.000100", "", "bob"},
+			{"message", "1704153600.000100", "", "alice"},
+		}
+		if len(records) != len(want) {
+			t.Fatalf("records = %+v, want %d", records, len(want))
+		}
+		for i, w := range want {
+			r := records[i]
+			if r.Type != w.typ || r.TS != w.ts || r.ThreadTS != w.threadTS || r.UserName != w.user ||
+				r.ChannelID != "C1" || r.ChannelName != "general" || r.TeamID != "T1" {
+				t.Errorf("record %d = %+v, want %s %s by %s in thread %q", i, r, w.typ, w.ts, w.user, w.threadTS)
+			}
+		}
+		if records[0].Time != "2024-01-01T00:00:00.0001Z" {
+			t.Errorf("time = %q, want RFC 3339 in UTC", records[0].Time)
+		}
+		if records[0].ReplyCount != 2 || records[1].ReplyCount != 0 {
+			t.Errorf("reply counts = %d, %d; want 2 on the parent only", records[0].ReplyCount, records[1].ReplyCount)
+		}
+	})
+}
+
+func TestWriteJSONLDoesNotEscapeHTML(t *testing.T) {
+	store := NewMemoryStore()
+	store.SaveChannel("C1", "general", "")
+	store.SaveMessage("1704067200.000100", "C1", "U1", "<@U2> & <#C1>", "", 0, "")
+
+	var buf bytes.Buffer
+	if err := NewExporter(store, ExportOptions{Format: "jsonl"}).WriteJSONL(&buf, "C1"); err != nil {
+		t.Fatalf("WriteJSONL failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"text":"<@U2> & <#C1>"`) {
+		t.Errorf("output escapes Slack markup:\n%s", buf.String())
+	}
+}
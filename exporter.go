@@ -1,64 +1,213 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
+type ExportOptions struct {
+	Format          string
+	Filter          MessageFilter
+	PageSize        int
+	DownloadAvatars bool
+	ChunkTokens     int
+	ChunkOverlap    int
+	Tokenizer       Tokenizer
+	Location        *time.Location
+	TimeFormat      string
+	AuthorTimezone  bool
+	// Channels limits exports of all channels; nil exports every channel.
+	Channels *ChannelSelector
+	// Redactor, if set, redacts message and reply text in the output.
+	Redactor *Redactor
+}
+
+// timeFormats are named layouts accepted by -time-format. Any other value is
+// used as a Go time layout as-is.
+var timeFormats = map[string]string{
+	"default":     "2006-01-02 15:04:05 MST",
+	"datetime":    "2006-01-02 15:04:05",
+	"datetime-ms": "2006-01-02 15:04:05.000 MST",
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"ja":          "2006年1月2日 15:04:05 MST",
+	"us":          "Jan 2, 2006 3:04:05 PM MST",
+	"eu":          "02/01/2006 15:04:05 MST",
+}
+
 type Exporter struct {
-	db *Database
+	db         Store
+	options    ExportOptions
+	timeLayout string
+	zones      map[string]*time.Location
+}
+
+func NewExporter(db Store, options ExportOptions) *Exporter {
+	if options.Format == "" {
+		options.Format = "text"
+	}
+
+	timeLayout := options.TimeFormat
+	if timeLayout == "" {
+		timeLayout = "default"
+	}
+	if layout, ok := timeFormats[timeLayout]; ok {
+		timeLayout = layout
+	}
+
+	return &Exporter{
+		db:         db,
+		options:    options,
+		timeLayout: timeLayout,
+		zones:      make(map[string]*time.Location),
+	}
+}
+
+// eachMessage streams the channel's messages matching the export filter,
+// redacted if a redactor is set.
+func (e *Exporter) eachMessage(channelID string, fn func(MessageWithReplies) error) error {
+	return e.db.EachMessageWithReplies(channelID, e.options.Filter, func(msg MessageWithReplies) error {
+		e.redactMessage(&msg)
+		return fn(msg)
+	})
 }
 
-func NewExporter(db *Database) *Exporter {
-	return &Exporter{db: db}
+func (e *Exporter) redactMessage(msg *MessageWithReplies) {
+	if e.options.Redactor == nil {
+		return
+	}
+	msg.Text = e.options.Redactor.Redact(msg.Text)
+	for i := range msg.Replies {
+		msg.Replies[i].Text = e.options.Redactor.Redact(msg.Replies[i].Text)
+	}
+}
+
+func (e *Exporter) ExportChannel(channelID, outputPath string) error {
+	switch e.options.Format {
+	case "text":
+		return e.ExportToText(channelID, outputPath)
+	case "json":
+		return e.ExportToJSON(channelID, outputPath)
+	case "jsonl":
+		return e.ExportToJSONL(channelID, outputPath)
+	case "chunks":
+		return e.ExportChunks(channelID, outputPath)
+	default:
+		return fmt.Errorf("unsupported export format: %s", e.options.Format)
+	}
+}
+
+func (e *Exporter) fileExtension() string {
+	switch e.options.Format {
+	case "json":
+		return "json"
+	case "jsonl":
+		return "jsonl"
+	default:
+		return "txt"
+	}
 }
 
 func (e *Exporter) ExportToText(channelID, outputPath string) error {
-	messages, err := e.db.GetAllMessagesWithReplies(channelID)
+	return exportToFile(outputPath, func(w io.Writer) error {
+		return e.WriteText(w, channelID)
+	})
+}
+
+func (e *Exporter) WriteText(w io.Writer, channelID string) error {
+	total, err := e.db.CountMessages(channelID, e.options.Filter)
 	if err != nil {
-		return fmt.Errorf("failed to get messages: %w", err)
+		return fmt.Errorf("failed to count messages: %w", err)
 	}
 
-	if len(messages) == 0 {
+	if total == 0 {
 		return fmt.Errorf("no messages found for channel %s", channelID)
 	}
 
-	file, err := os.Create(outputPath)
+	channelName, err := e.db.GetChannelName(channelID)
+	if err != nil {
+		return fmt.Errorf("failed to get channel: %w", err)
+	}
+
+	fmt.Fprintf(w, "# Slack Channel Export: #%s\n", channelName)
+	fmt.Fprintf(w, "Channel ID: %s\n", channelID)
+	fmt.Fprintf(w, "Export Date: %s\n", time.Now().In(e.location()).Format(e.timeLayout))
+	fmt.Fprintf(w, "Total Messages: %d\n\n", total)
+	fmt.Fprintf(w, "="+strings.Repeat("=", 70)+"\n\n")
+
+	err = e.eachMessage(channelID, func(msg MessageWithReplies) error {
+		e.writeTextMessage(w, msg)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return fmt.Errorf("failed to export messages: %w", err)
 	}
+
+	return nil
+}
+
+// exportToFile runs write against a buffered file that is only created once
+// write produces output, so a failed export does not leave an empty file.
+func exportToFile(outputPath string, write func(w io.Writer) error) error {
+	file := &lazyFile{path: outputPath}
 	defer file.Close()
 
-	channelName := messages[0].ChannelName
-	
-	fmt.Fprintf(file, "# Slack Channel Export: #%s\n", channelName)
-	fmt.Fprintf(file, "Channel ID: %s\n", channelID)
-	fmt.Fprintf(file, "Export Date: %s\n", time.Now().Format("2006-01-02 15:04:05"))
-	fmt.Fprintf(file, "Total Messages: %d\n\n", len(messages))
-	fmt.Fprintf(file, "="+ strings.Repeat("=", 70) + "\n\n")
-
-	for _, msg := range messages {
-		timestamp := e.formatTimestamp(msg.Timestamp)
-		userDisplay := e.formatUserDisplay(msg.UserID, msg.UserName, msg.UserRealName, msg.UserDisplayName)
-
-		fmt.Fprintf(file, "[%s] %s:\n%s\n", timestamp, userDisplay, msg.Text)
-
-		if len(msg.Replies) > 0 {
-			fmt.Fprintf(file, "\n  Thread Replies (%d):\n", len(msg.Replies))
-			for _, reply := range msg.Replies {
-				replyTime := e.formatTimestamp(reply.Timestamp)
-				replyUserDisplay := e.formatUserDisplay(reply.UserID, reply.UserName, reply.UserRealName, reply.UserDisplayName)
-				fmt.Fprintf(file, "  [%s] %s: %s\n", replyTime, replyUserDisplay, reply.Text)
-			}
+	w := bufio.NewWriter(file)
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return file.Close()
+}
+
+type lazyFile struct {
+	path string
+	file *os.File
+}
+
+func (f *lazyFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		file, err := os.Create(f.path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create output file: %w", err)
 		}
+		f.file = file
+	}
+	return f.file.Write(p)
+}
 
-		fmt.Fprintf(file, "\n" + strings.Repeat("-", 80) + "\n\n")
+func (f *lazyFile) Close() error {
+	if f.file == nil {
+		return nil
 	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
 
-	return nil
+func (e *Exporter) writeTextMessage(w io.Writer, msg MessageWithReplies) {
+	timestamp := e.formatTimestamp(msg.Timestamp, msg.UserTZ)
+	userDisplay := e.formatUserDisplay(msg.UserID, msg.UserName, msg.UserRealName, msg.UserDisplayName)
+
+	fmt.Fprintf(w, "[%s | %s] %s:\n%s\n", timestamp, msg.Timestamp, userDisplay, msg.Text)
+
+	if len(msg.Replies) > 0 {
+		fmt.Fprintf(w, "\n  Thread Replies (%d):\n", len(msg.Replies))
+		for _, reply := range msg.Replies {
+			replyTime := e.formatTimestamp(reply.Timestamp, reply.UserTZ)
+			replyUserDisplay := e.formatUserDisplay(reply.UserID, reply.UserName, reply.UserRealName, reply.UserDisplayName)
+			fmt.Fprintf(w, "  [%s | %s] %s: %s\n", replyTime, reply.Timestamp, replyUserDisplay, reply.Text)
+		}
+	}
+
+	fmt.Fprintf(w, "\n"+strings.Repeat("-", 80)+"\n\n")
 }
 
 func (e *Exporter) ExportAllChannels(outputDir string) error {
@@ -75,13 +224,22 @@ func (e *Exporter) ExportAllChannels(outputDir string) error {
 		return fmt.Errorf("no channels found in database")
 	}
 
+	include, err := e.channelFilter()
+	if err != nil {
+		return err
+	}
+
 	for channelID, channelName := range channels {
+		if !include(channelID, channelName) {
+			continue
+		}
+
 		safeChannelName := strings.ReplaceAll(channelName, "/", "_")
 		safeChannelName = strings.ReplaceAll(safeChannelName, " ", "_")
 		
-		outputPath := fmt.Sprintf("%s/%s_%s.txt", outputDir, safeChannelName, channelID)
+		outputPath := fmt.Sprintf("%s/%s_%s.%s", outputDir, safeChannelName, channelID, e.fileExtension())
 		
-		if err := e.ExportToText(channelID, outputPath); err != nil {
+		if err := e.ExportChannel(channelID, outputPath); err != nil {
 			fmt.Printf("Warning: Failed to export channel %s (%s): %v\n", channelName, channelID, err)
 			continue
 		}
@@ -92,14 +250,97 @@ func (e *Exporter) ExportAllChannels(outputDir string) error {
 	return nil
 }
 
-func (e *Exporter) formatTimestamp(ts string) string {
-	timestamp, err := strconv.ParseFloat(ts, 64)
+// channelFilter returns which channels an export of every channel covers:
+// those matching the Channels selector and, when the filter names a team,
+// fetched from that team.
+func (e *Exporter) channelFilter() (func(id, name string) bool, error) {
+	var teams map[string]string
+	if e.options.Filter.TeamID != "" {
+		var err error
+		if teams, err = e.db.GetChannelTeams(); err != nil {
+			return nil, fmt.Errorf("failed to get channel teams: %w", err)
+		}
+	}
+
+	return func(id, name string) bool {
+		if teams != nil && teams[id] != e.options.Filter.TeamID {
+			return false
+		}
+		return e.options.Channels.Match(id, name)
+	}, nil
+}
+
+// formatTimestamp renders ts in the export time zone, or in the author's own
+// time zone when AuthorTimezone is set and the author's profile has one.
+func (e *Exporter) formatTimestamp(ts, authorTZ string) string {
+	t, err := parseSlackTimestamp(ts)
 	if err != nil {
 		return ts
 	}
-	
-	t := time.Unix(int64(timestamp), 0)
-	return t.Format("2006-01-02 15:04:05")
+
+	loc := e.location()
+	if e.options.AuthorTimezone && authorTZ != "" {
+		if authorLoc := e.zone(authorTZ); authorLoc != nil {
+			loc = authorLoc
+		}
+	}
+
+	return t.In(loc).Format(e.timeLayout)
+}
+
+func (e *Exporter) formatISOTimestamp(ts string) string {
+	t, err := parseSlackTimestamp(ts)
+	if err != nil {
+		return ts
+	}
+
+	loc := time.UTC
+	if e.options.Location != nil {
+		loc = e.options.Location
+	}
+	return t.In(loc).Format(time.RFC3339Nano)
+}
+
+func (e *Exporter) location() *time.Location {
+	if e.options.Location != nil {
+		return e.options.Location
+	}
+	return time.Local
+}
+
+func (e *Exporter) zone(name string) *time.Location {
+	loc, ok := e.zones[name]
+	if !ok {
+		var err error
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			loc = nil
+		}
+		e.zones[name] = loc
+	}
+	return loc
+}
+
+func parseSlackTimestamp(ts string) (time.Time, error) {
+	secPart, fracPart, _ := strings.Cut(ts, ".")
+	sec, err := strconv.ParseInt(secPart, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nsec int64
+	if fracPart != "" {
+		if len(fracPart) > 9 {
+			fracPart = fracPart[:9]
+		}
+		fracPart += strings.Repeat("0", 9-len(fracPart))
+		nsec, err = strconv.ParseInt(fracPart, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return time.Unix(sec, nsec), nil
 }
 
 func (e *Exporter) formatUserDisplay(userID, userName, realName, displayName string) string {
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatTimestamp(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	const ts = "1704067200.123456" // 2024-01-01 00:00:00.123456 UTC
+	for _, tc := range []struct {
+		name     string
+		options  ExportOptions
+		authorTZ string
+		want     string
+	}{
+		{"default layout", ExportOptions{Location: time.UTC}, "", "2024-01-01 00:00:00 UTC"},
+		{"export time zone", ExportOptions{Location: tokyo}, "", "2024-01-01 09:00:00 JST"},
+		{"named layout", ExportOptions{Location: time.UTC, TimeFormat: "rfc3339"}, "", "2024-01-01T00:00:00Z"},
+		{"milliseconds", ExportOptions{Location: time.UTC, TimeFormat: "datetime-ms"}, "", "2024-01-01 00:00:00.123 UTC"},
+		{"ja layout", ExportOptions{Location: tokyo, TimeFormat: "ja"}, "", "2024年1月1日 09:00:00 JST"},
+		{"us layout", ExportOptions{Location: time.UTC, TimeFormat: "us"}, "", "Jan 1, 2024 12:00:00 AM UTC"},
+		{"custom layout", ExportOptions{Location: time.UTC, TimeFormat: "02 Jan 15:04"}, "", "01 Jan 00:00"},
+		{"author time zone ignored by default", ExportOptions{Location: time.UTC}, "Asia/Tokyo", "2024-01-01 00:00:00 UTC"},
+		{"author time zone", ExportOptions{Location: time.UTC, AuthorTimezone: true}, "Asia/Tokyo", "2024-01-01 09:00:00 JST"},
+		{"author without a time zone", ExportOptions{Location: time.UTC, AuthorTimezone: true}, "", "2024-01-01 00:00:00 UTC"},
+		{"unknown author time zone", ExportOptions{Location: time.UTC, AuthorTimezone: true}, "Mars/Olympus", "2024-01-01 00:00:00 UTC"},
+	} {
+		exporter := NewExporter(NewMemoryStore(), tc.options)
+		if got := exporter.formatTimestamp(ts, tc.authorTZ); got != tc.want {
+			t.Errorf("%s: formatTimestamp = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	exporter := NewExporter(NewMemoryStore(), ExportOptions{Location: time.UTC})
+	if got := exporter.formatTimestamp("not-a-ts", ""); got != "not-a-ts" {
+		t.Errorf("formatTimestamp of an invalid ts = %q, want it unchanged", got)
+	}
+
+	// ISO timestamps in JSON Lines keep full precision and default to UTC.
+	if got := NewExporter(NewMemoryStore(), ExportOptions{}).formatISOTimestamp(ts); got != "2024-01-01T00:00:00.123456Z" {
+		t.Errorf("formatISOTimestamp = %q, want UTC with microseconds", got)
+	}
+	if got := NewExporter(NewMemoryStore(), ExportOptions{Location: tokyo}).formatISOTimestamp(ts); got != "2024-01-01T09:00:00.123456+09:00" {
+		t.Errorf("formatISOTimestamp in Tokyo = %q", got)
+	}
+}
+
+func TestParseSlackTimestamp(t *testing.T) {
+	for ts, want := range map[string]time.Time{
+		"1704067200":              time.Unix(1704067200, 0),
+		"1704067200.000100":       time.Unix(1704067200, 100000),
+		"1704067200.5":            time.Unix(1704067200, 500000000),
+		"1704067200.123456789123": time.Unix(1704067200, 123456789),
+	} {
+		got, err := parseSlackTimestamp(ts)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseSlackTimestamp(%q) = %v, %v; want %v", ts, got, err, want)
+		}
+	}
+
+	for _, ts := range []string{"", "abc", "1704067200.x"} {
+		if _, err := parseSlackTimestamp(ts); err == nil {
+			t.Errorf("parseSlackTimestamp(%q): want an error", ts)
+		}
+	}
+}
+
+func TestWriteTextAuthorTimezone(t *testing.T) {
+	if _, err := time.LoadLocation("Asia/Tokyo"); err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	forEachStore(t, func(t *testing.T, store Store) {
+		seedStore(t, store)
+
+		var buf bytes.Buffer
+		exporter := NewExporter(store, ExportOptions{Location: time.UTC, AuthorTimezone: true})
+		if err := exporter.WriteText(&buf, "C1"); err != nil {
+			t.Fatalf("WriteText failed: %v", err)
+		}
+
+		// alice's profile is in Asia/Tokyo; bob has no time zone.
+		for _, want := range []string{
+			"[2024-01-01 09:00:00 JST | 1704067200.000100] ali (@alice):\nDeploy started\n",
+			"  [2024-01-01 00:01:00 UTC | 1704067260.000100] bobby (@bob): Rollback plan is ready\n",
+			"  [2024-01-01 09:02:00 JST | 1704067320.000100] ali (@alice): thanks\n",
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("text export is missing %q:\n%s", want, buf.String())
+			}
+		}
+	})
+}
+
+func TestLoadLocation(t *testing.T) {
+	if loc, err := loadLocation(""); loc != nil || err != nil {
+		t.Errorf("loadLocation(\"\") = %v, %v; want nil for the local time zone", loc, err)
+	}
+	if loc, err := loadLocation("UTC"); err != nil || loc != time.UTC {
+		t.Errorf("loadLocation(UTC) = %v, %v", loc, err)
+	}
+	if _, err := loadLocation("Mars/Olympus"); err == nil || !strings.Contains(err.Error(), `invalid time zone "Mars/Olympus"`) {
+		t.Errorf("loadLocation of an unknown zone error = %v", err)
+	}
+}
+
+func TestExportToFile(t *testing.T) {
+	store := NewMemoryStore()
+	seedStore(t, store)
+	exporter := NewExporter(store, ExportOptions{Location: time.UTC})
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "general.txt")
+	if err := exporter.ExportToText("C1", path); err != nil {
+		t.Fatalf("ExportToText failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := exporter.WriteText(&buf, "C1"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != buf.String() {
+		t.Errorf("exported file = %q, %v; want the streamed text", data, err)
+	}
+
+	// A failed export leaves no empty file behind.
+	path = filepath.Join(dir, "missing.txt")
+	if err := exporter.ExportToText("C9", path); err == nil {
+		t.Error("exporting an unknown channel: want an error")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("failed export created %s: %v", path, err)
+	}
+}
@@ -0,0 +1,185 @@
+package fakeslack
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Fixtures is the initial content of a fake workspace. It can be built in
+// code or loaded from a JSON file with LoadFixtures.
+type Fixtures struct {
+	Team     Team      `json:"team"`
+	Channels []Channel `json:"channels"`
+	Users    []User    `json:"users"`
+}
+
+// Team is the workspace auth.test reports for the token. EnterpriseID makes
+// it part of an Enterprise Grid org.
+type Team struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Domain       string `json:"domain"`
+	EnterpriseID string `json:"enterprise_id"`
+	// UserID and User identify the token's user, BotID the bot for bot
+	// tokens.
+	UserID string `json:"user_id"`
+	User   string `json:"user"`
+	BotID  string `json:"bot_id"`
+	// Scopes, when set, are sent in the X-OAuth-Scopes header of every
+	// response like Slack does.
+	Scopes []string `json:"scopes"`
+}
+
+type Channel struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	IsPrivate  bool      `json:"is_private"`
+	IsArchived bool      `json:"is_archived"`
+	IsMember   bool      `json:"is_member"`
+	NumMembers int       `json:"num_members"`
+	Messages   []Message `json:"messages"`
+}
+
+// Message is a top-level message. Replies form its thread; a message with
+// replies is served with thread_ts and reply_count set like Slack does.
+type Message struct {
+	TS      string    `json:"ts"`
+	User    string    `json:"user"`
+	Text    string    `json:"text"`
+	SubType string    `json:"subtype"`
+	Replies []Message `json:"replies"`
+}
+
+type User struct {
+	ID          string `json:"id"`
+	TeamID      string `json:"team_id"`
+	Name        string `json:"name"`
+	RealName    string `json:"real_name"`
+	DisplayName string `json:"display_name"`
+	Email       string `json:"email"`
+	Image512    string `json:"image_512"`
+	TZ          string `json:"tz"`
+	IsBot       bool   `json:"is_bot"`
+	Deleted     bool   `json:"deleted"`
+	// EnterpriseTeams lists every team of the Enterprise Grid org the user
+	// belongs to.
+	EnterpriseTeams []string `json:"enterprise_teams"`
+}
+
+// LoadFixtures reads fixtures from a JSON file.
+func LoadFixtures(path string) (Fixtures, error) {
+	var fixtures Fixtures
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fixtures, fmt.Errorf("failed to read fixtures: %w", err)
+	}
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return fixtures, fmt.Errorf("failed to parse fixtures: %w", err)
+	}
+	return fixtures, nil
+}
+
+func (c *Channel) kind() string {
+	if c.IsPrivate {
+		return "private_channel"
+	}
+	return "public_channel"
+}
+
+type wireChannel struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	IsChannel  bool   `json:"is_channel"`
+	IsPrivate  bool   `json:"is_private"`
+	IsArchived bool   `json:"is_archived"`
+	IsMember   bool   `json:"is_member"`
+	NumMembers int    `json:"num_members"`
+}
+
+func (c *Channel) wire() wireChannel {
+	return wireChannel{
+		ID:         c.ID,
+		Name:       c.Name,
+		IsChannel:  !c.IsPrivate,
+		IsPrivate:  c.IsPrivate,
+		IsArchived: c.IsArchived,
+		IsMember:   c.IsMember,
+		NumMembers: c.NumMembers,
+	}
+}
+
+type wireMessage struct {
+	Type         string `json:"type"`
+	Channel      string `json:"channel,omitempty"`
+	TS           string `json:"ts"`
+	User         string `json:"user,omitempty"`
+	Text         string `json:"text"`
+	SubType      string `json:"subtype,omitempty"`
+	ThreadTS     string `json:"thread_ts,omitempty"`
+	ParentUserID string `json:"parent_user_id,omitempty"`
+	ReplyCount   int    `json:"reply_count,omitempty"`
+}
+
+func (m Message) wire(channelID string) wireMessage {
+	msg := wireMessage{
+		Type:    "message",
+		Channel: channelID,
+		TS:      m.TS,
+		User:    m.User,
+		Text:    m.Text,
+		SubType: m.SubType,
+	}
+	if len(m.Replies) > 0 {
+		msg.ThreadTS = m.TS
+		msg.ReplyCount = len(m.Replies)
+	}
+	return msg
+}
+
+type wireProfile struct {
+	RealName    string `json:"real_name"`
+	DisplayName string `json:"display_name"`
+	Email       string `json:"email,omitempty"`
+	Image512    string `json:"image_512,omitempty"`
+}
+
+type wireUser struct {
+	ID         string          `json:"id"`
+	TeamID     string          `json:"team_id,omitempty"`
+	Name       string          `json:"name"`
+	RealName   string          `json:"real_name"`
+	TZ         string          `json:"tz,omitempty"`
+	IsBot      bool            `json:"is_bot"`
+	Deleted    bool            `json:"deleted"`
+	Profile    wireProfile     `json:"profile"`
+	Enterprise *wireEnterprise `json:"enterprise_user,omitempty"`
+}
+
+type wireEnterprise struct {
+	Teams []string `json:"teams"`
+}
+
+func (u *User) wire() wireUser {
+	var enterprise *wireEnterprise
+	if len(u.EnterpriseTeams) > 0 {
+		enterprise = &wireEnterprise{Teams: u.EnterpriseTeams}
+	}
+	return wireUser{
+		ID:       u.ID,
+		TeamID:   u.TeamID,
+		Name:     u.Name,
+		RealName: u.RealName,
+		TZ:       u.TZ,
+		IsBot:    u.IsBot,
+		Deleted:  u.Deleted,
+		Profile: wireProfile{
+			RealName:    u.RealName,
+			DisplayName: u.DisplayName,
+			Email:       u.Email,
+			Image512:    u.Image512,
+		},
+		Enterprise: enterprise,
+	}
+}
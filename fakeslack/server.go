@@ -0,0 +1,511 @@
+// Package fakeslack is an in-process fake of the Slack Web API methods used
+// by slack-all-contexts. It serves conversations.history, conversations.replies,
+// conversations.info, conversations.list, conversations.join, users.info,
+// users.list and auth.test from scriptable fixtures over httptest, including
+// cursor pagination, threads and HTTP 429 rate-limit responses.
+//
+// Point a slack client at it with slack.OptionAPIURL(server.APIURL()).
+package fakeslack
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultPageSize is used when a request does not set limit.
+const DefaultPageSize = 100
+
+// Server is a fake Slack Web API server. Fixtures may be added and failures
+// scripted at any time, including while a client is using the server.
+type Server struct {
+	// Token, when set, is the only token accepted; other requests fail with
+	// invalid_auth.
+	Token string
+
+	// MaxPageSize caps the limit requested by clients, so pagination can be
+	// exercised with small fixtures. Zero means no cap.
+	MaxPageSize int
+
+	server *httptest.Server
+
+	mu         sync.Mutex
+	team       Team
+	channels   []*Channel
+	users      []*User
+	rateLimits map[string][]int
+	errors     map[string][]string
+	calls      map[string]int
+}
+
+// NewServer starts a server with the given fixtures. Close it when done.
+func NewServer(fixtures Fixtures) *Server {
+	s := &Server{
+		rateLimits: make(map[string][]int),
+		errors:     make(map[string][]string),
+		calls:      make(map[string]int),
+	}
+	s.Load(fixtures)
+
+	mux := http.NewServeMux()
+	for method, handler := range map[string]func(*http.Request) (interface{}, string){
+		"conversations.history": s.conversationsHistory,
+		"conversations.replies": s.conversationsReplies,
+		"conversations.info":    s.conversationsInfo,
+		"conversations.list":    s.conversationsList,
+		"conversations.join":    s.conversationsJoin,
+		"users.info":            s.usersInfo,
+		"users.list":            s.usersList,
+		"auth.test":             s.authTest,
+	} {
+		mux.HandleFunc("/api/"+method, s.handle(method, handler))
+	}
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+// APIURL is the base URL to pass to slack.OptionAPIURL.
+func (s *Server) APIURL() string {
+	return s.server.URL + "/api/"
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Load adds fixtures to the server. A team in fixtures replaces the
+// current one.
+func (s *Server) Load(fixtures Fixtures) {
+	if fixtures.Team.ID != "" {
+		s.SetTeam(fixtures.Team)
+	}
+	for _, c := range fixtures.Channels {
+		s.AddChannel(c)
+	}
+	for _, u := range fixtures.Users {
+		s.AddUser(u)
+	}
+}
+
+// SetTeam sets the workspace reported by auth.test.
+func (s *Server) SetTeam(team Team) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.team = team
+}
+
+// AddChannel adds or replaces a channel.
+func (s *Server) AddChannel(channel Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, c := range s.channels {
+		if c.ID == channel.ID {
+			s.channels[i] = &channel
+			return
+		}
+	}
+	s.channels = append(s.channels, &channel)
+}
+
+// AddUser adds or replaces a user.
+func (s *Server) AddUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, u := range s.users {
+		if u.ID == user.ID {
+			s.users[i] = &user
+			return
+		}
+	}
+	s.users = append(s.users, &user)
+}
+
+// AddMessage posts a top-level message to a channel.
+func (s *Server) AddMessage(channelID string, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channel := s.channel(channelID)
+	if channel == nil {
+		return fmt.Errorf("fakeslack: unknown channel %s", channelID)
+	}
+	channel.Messages = append(channel.Messages, message)
+	return nil
+}
+
+// AddReply posts a reply to the thread started by threadTS.
+func (s *Server) AddReply(channelID, threadTS string, reply Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channel := s.channel(channelID)
+	if channel == nil {
+		return fmt.Errorf("fakeslack: unknown channel %s", channelID)
+	}
+	for i := range channel.Messages {
+		if channel.Messages[i].TS == threadTS {
+			channel.Messages[i].Replies = append(channel.Messages[i].Replies, reply)
+			return nil
+		}
+	}
+	return fmt.Errorf("fakeslack: unknown thread %s in channel %s", threadTS, channelID)
+}
+
+// RateLimit makes the next times calls to method fail with HTTP 429 and the
+// given Retry-After in seconds.
+func (s *Server) RateLimit(method string, times, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < times; i++ {
+		s.rateLimits[method] = append(s.rateLimits[method], retryAfter)
+	}
+}
+
+// FailNext makes the next call to method return a Slack error response with
+// the given error code, e.g. "invalid_cursor" or "not_in_channel".
+func (s *Server) FailNext(method, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors[method] = append(s.errors[method], code)
+}
+
+// Calls returns how many requests were made to method, including rate
+// limited and failed ones.
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[method]
+}
+
+func (s *Server) handle(method string, handler func(*http.Request) (interface{}, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.calls[method]++
+		if delays := s.rateLimits[method]; len(delays) > 0 {
+			s.rateLimits[method] = delays[1:]
+			s.mu.Unlock()
+			w.Header().Set("Retry-After", strconv.Itoa(delays[0]))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		var (
+			resp   interface{}
+			code   string
+			scopes = s.team.Scopes
+		)
+		if codes := s.errors[method]; len(codes) > 0 {
+			s.errors[method] = codes[1:]
+			code = codes[0]
+		} else if s.Token != "" && requestToken(r) != s.Token {
+			code = "invalid_auth"
+		} else {
+			resp, code = handler(r)
+		}
+		s.mu.Unlock()
+
+		if scopes != nil {
+			w.Header().Set("X-OAuth-Scopes", strings.Join(scopes, ","))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if code != "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": code})
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func requestToken(r *http.Request) string {
+	if token := r.Form.Get("token"); token != "" {
+		return token
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func (s *Server) channel(id string) *Channel {
+	for _, c := range s.channels {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Server) user(id string) *User {
+	for _, u := range s.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+type metadata struct {
+	NextCursor string `json:"next_cursor"`
+}
+
+func (s *Server) conversationsHistory(r *http.Request) (interface{}, string) {
+	channel := s.channel(r.Form.Get("channel"))
+	if channel == nil {
+		return nil, "channel_not_found"
+	}
+	if !channel.IsMember {
+		return nil, "not_in_channel"
+	}
+
+	oldest, latest := r.Form.Get("oldest"), r.Form.Get("latest")
+	inclusive := r.Form.Get("inclusive") == "1" || r.Form.Get("inclusive") == "true"
+
+	// History is returned newest first.
+	var messages []Message
+	for _, m := range channel.Messages {
+		if oldest != "" && (m.TS < oldest || (m.TS == oldest && !inclusive)) {
+			continue
+		}
+		if latest != "" && (m.TS > latest || (m.TS == latest && !inclusive)) {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].TS > messages[j].TS })
+
+	page, next, code := s.paginate(r, len(messages))
+	if code != "" {
+		return nil, code
+	}
+
+	wire := make([]wireMessage, 0, page.end-page.start)
+	for _, m := range messages[page.start:page.end] {
+		wire = append(wire, m.wire(channel.ID))
+	}
+	return struct {
+		OK       bool          `json:"ok"`
+		Messages []wireMessage `json:"messages"`
+		HasMore  bool          `json:"has_more"`
+		Metadata metadata      `json:"response_metadata"`
+	}{true, wire, next != "", metadata{next}}, ""
+}
+
+func (s *Server) conversationsReplies(r *http.Request) (interface{}, string) {
+	channel := s.channel(r.Form.Get("channel"))
+	if channel == nil {
+		return nil, "channel_not_found"
+	}
+	if !channel.IsMember {
+		return nil, "not_in_channel"
+	}
+
+	ts := r.Form.Get("ts")
+	var parent *Message
+	for i := range channel.Messages {
+		if channel.Messages[i].TS == ts {
+			parent = &channel.Messages[i]
+			break
+		}
+	}
+	if parent == nil {
+		return nil, "thread_not_found"
+	}
+
+	replies := append([]Message(nil), parent.Replies...)
+	sort.Slice(replies, func(i, j int) bool { return replies[i].TS < replies[j].TS })
+
+	page, next, code := s.paginate(r, len(replies))
+	if code != "" {
+		return nil, code
+	}
+
+	// Like Slack, every page starts with the parent message.
+	wire := []wireMessage{parent.wire(channel.ID)}
+	for _, m := range replies[page.start:page.end] {
+		reply := m.wire(channel.ID)
+		reply.ThreadTS = parent.TS
+		reply.ParentUserID = parent.User
+		wire = append(wire, reply)
+	}
+	return struct {
+		OK       bool          `json:"ok"`
+		Messages []wireMessage `json:"messages"`
+		HasMore  bool          `json:"has_more"`
+		Metadata metadata      `json:"response_metadata"`
+	}{true, wire, next != "", metadata{next}}, ""
+}
+
+func (s *Server) conversationsInfo(r *http.Request) (interface{}, string) {
+	channel := s.channel(r.Form.Get("channel"))
+	if channel == nil {
+		return nil, "channel_not_found"
+	}
+	return struct {
+		OK      bool        `json:"ok"`
+		Channel wireChannel `json:"channel"`
+	}{true, channel.wire()}, ""
+}
+
+func (s *Server) conversationsList(r *http.Request) (interface{}, string) {
+	types := map[string]bool{"public_channel": true}
+	if t := r.Form.Get("types"); t != "" {
+		types = make(map[string]bool)
+		for _, name := range strings.Split(t, ",") {
+			types[strings.TrimSpace(name)] = true
+		}
+	}
+	excludeArchived := r.Form.Get("exclude_archived") == "true" || r.Form.Get("exclude_archived") == "1"
+
+	var channels []*Channel
+	for _, c := range s.channels {
+		if !types[c.kind()] || (excludeArchived && c.IsArchived) {
+			continue
+		}
+		channels = append(channels, c)
+	}
+
+	page, next, code := s.paginate(r, len(channels))
+	if code != "" {
+		return nil, code
+	}
+
+	wire := make([]wireChannel, 0, page.end-page.start)
+	for _, c := range channels[page.start:page.end] {
+		wire = append(wire, c.wire())
+	}
+	return struct {
+		OK       bool          `json:"ok"`
+		Channels []wireChannel `json:"channels"`
+		Metadata metadata      `json:"response_metadata"`
+	}{true, wire, metadata{next}}, ""
+}
+
+// conversationsJoin makes the bot a member of a public channel, which is
+// all conversations.join can join.
+func (s *Server) conversationsJoin(r *http.Request) (interface{}, string) {
+	channel := s.channel(r.Form.Get("channel"))
+	switch {
+	case channel == nil:
+		return nil, "channel_not_found"
+	case channel.IsPrivate:
+		return nil, "method_not_supported_for_channel_type"
+	case channel.IsArchived:
+		return nil, "is_archived"
+	}
+
+	channel.IsMember = true
+	return struct {
+		OK      bool        `json:"ok"`
+		Channel wireChannel `json:"channel"`
+	}{true, channel.wire()}, ""
+}
+
+func (s *Server) authTest(r *http.Request) (interface{}, string) {
+	team := s.team
+	if team.ID == "" {
+		team = Team{ID: "T00000001", Name: "Fake Workspace", Domain: "fake"}
+	}
+	url := ""
+	if team.Domain != "" {
+		url = "https://" + team.Domain + ".slack.com/"
+	}
+	return struct {
+		OK           bool   `json:"ok"`
+		URL          string `json:"url"`
+		Team         string `json:"team"`
+		User         string `json:"user"`
+		TeamID       string `json:"team_id"`
+		UserID       string `json:"user_id"`
+		EnterpriseID string `json:"enterprise_id,omitempty"`
+		BotID        string `json:"bot_id,omitempty"`
+	}{true, url, team.Name, team.User, team.ID, team.UserID, team.EnterpriseID, team.BotID}, ""
+}
+
+func (s *Server) usersInfo(r *http.Request) (interface{}, string) {
+	user := s.user(r.Form.Get("user"))
+	if user == nil {
+		return nil, "user_not_found"
+	}
+	return struct {
+		OK   bool     `json:"ok"`
+		User wireUser `json:"user"`
+	}{true, user.wire()}, ""
+}
+
+func (s *Server) usersList(r *http.Request) (interface{}, string) {
+	page, next, code := s.paginate(r, len(s.users))
+	if code != "" {
+		return nil, code
+	}
+
+	wire := make([]wireUser, 0, page.end-page.start)
+	for _, u := range s.users[page.start:page.end] {
+		wire = append(wire, u.wire())
+	}
+	return struct {
+		OK       bool       `json:"ok"`
+		Members  []wireUser `json:"members"`
+		Metadata metadata   `json:"response_metadata"`
+	}{true, wire, metadata{next}}, ""
+}
+
+type pageRange struct {
+	start, end int
+}
+
+// paginate resolves the cursor and limit of r against a list of total
+// items. Cursors are opaque offsets; a cursor that does not decode fails
+// with invalid_cursor.
+func (s *Server) paginate(r *http.Request, total int) (pageRange, string, string) {
+	start := 0
+	if cursor := r.Form.Get("cursor"); cursor != "" {
+		offset, err := decodeCursor(cursor)
+		if err != nil || offset > total {
+			return pageRange{}, "", "invalid_cursor"
+		}
+		start = offset
+	}
+
+	limit, _ := strconv.Atoi(r.Form.Get("limit"))
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if s.MaxPageSize > 0 && limit > s.MaxPageSize {
+		limit = s.MaxPageSize
+	}
+
+	end := start + limit
+	if end >= total {
+		return pageRange{start, total}, "", ""
+	}
+	return pageRange{start, end}, encodeCursor(end), ""
+}
+
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(string(data), "offset:") {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return strconv.Atoi(strings.TrimPrefix(string(data), "offset:"))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MessageFilter narrows the top-level messages returned by Database queries.
+// Filters select whole threads: a matching message is always returned with
+// all of its replies.
+type MessageFilter struct {
+	From       time.Time
+	To         time.Time
+	TeamID     string
+	User       string
+	ThreadTS   string
+	Contains   string
+	MinReplies int
+}
+
+// sql returns a condition on the messages table aliased as m, suitable for
+// appending to a WHERE clause with AND, and its arguments.
+func (f MessageFilter) sql(dialect string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	if !f.From.IsZero() {
+		conds = append(conds, "CAST(m.ts AS DOUBLE PRECISION) >= ?")
+		args = append(args, unixSeconds(f.From))
+	}
+
+	if !f.To.IsZero() {
+		conds = append(conds, "CAST(m.ts AS DOUBLE PRECISION) < ?")
+		args = append(args, unixSeconds(f.To))
+	}
+
+	if f.TeamID != "" {
+		conds = append(conds, "m.team_id = ?")
+		args = append(args, f.TeamID)
+	}
+
+	if f.User != "" {
+		user := strings.TrimPrefix(f.User, "@")
+		userIDs := "(SELECT id FROM users WHERE id = ? OR name = ? OR display_name = ? UNION SELECT CAST(? AS TEXT))"
+		conds = append(conds, fmt.Sprintf(`(m.user_id IN %s OR EXISTS (
+			SELECT 1 FROM replies r
+			WHERE r.channel_id = m.channel_id AND r.thread_ts = m.ts AND r.user_id IN %s))`, userIDs, userIDs))
+		args = append(args, user, user, user, user, user, user, user, user)
+	}
+
+	if f.ThreadTS != "" {
+		conds = append(conds, "(m.ts = ? OR m.thread_ts = ?)")
+		args = append(args, f.ThreadTS, f.ThreadTS)
+	}
+
+	if f.Contains != "" {
+		pattern := "%" + escapeLike(f.Contains) + "%"
+		like := likeOperator(dialect)
+		conds = append(conds, fmt.Sprintf(`(m.text %[1]s ? ESCAPE '\' OR EXISTS (
+			SELECT 1 FROM replies r
+			WHERE r.channel_id = m.channel_id AND r.thread_ts = m.ts AND r.text %[1]s ? ESCAPE '\'))`, like))
+		args = append(args, pattern, pattern)
+	}
+
+	if f.MinReplies > 0 {
+		conds = append(conds, "m.reply_count >= ?")
+		args = append(args, f.MinReplies)
+	}
+
+	if len(conds) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// likeOperator returns the case-insensitive LIKE operator for the dialect;
+// SQLite's LIKE already ignores ASCII case.
+func likeOperator(dialect string) string {
+	if dialect == dialectPostgres {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
+
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// parseFilterTime accepts a date, a date with a time of day, or an RFC 3339
+// timestamp. Date-only values given as an upper bound cover the whole day.
+func parseFilterTime(value string, upperBound bool, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		if upperBound {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q: use YYYY-MM-DD, YYYY-MM-DD HH:MM or RFC 3339", value)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageFilterSQL(t *testing.T) {
+	if cond, args := (MessageFilter{}).sql(dialectSQLite); cond != "1 = 1" || args != nil {
+		t.Errorf("empty filter = %q, %v; want 1 = 1 without arguments", cond, args)
+	}
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 500000000, time.UTC)
+	cond, args := MessageFilter{From: from, To: from.Add(time.Hour)}.sql(dialectSQLite)
+	if cond != "CAST(m.ts AS DOUBLE PRECISION) >= ? AND CAST(m.ts AS DOUBLE PRECISION) < ?" {
+		t.Errorf("time range = %q", cond)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1704067200.5, 1704070800.5}) {
+		t.Errorf("time range args = %v, want fractional unix seconds", args)
+	}
+
+	// Every placeholder needs an argument.
+	filter := MessageFilter{From: from, TeamID: "T1", User: "@alice", ThreadTS: "1.2", Contains: "x", MinReplies: 1}
+	cond, args = filter.sql(dialectSQLite)
+	if n := strings.Count(cond, "?"); n != len(args) {
+		t.Errorf("%d placeholders but %d args in %q", n, len(args), cond)
+	}
+	for _, arg := range args[2:10] {
+		if arg != "alice" {
+			t.Errorf("user args = %v, want alice without the @", args[2:10])
+			break
+		}
+	}
+
+	for dialect, want := range map[string]string{dialectSQLite: "m.text LIKE ?", dialectPostgres: "m.text ILIKE ?"} {
+		cond, args := MessageFilter{Contains: `50%_off\`}.sql(dialect)
+		if !strings.Contains(cond, want) || !strings.Contains(cond, `ESCAPE '\'`) {
+			t.Errorf("%s contains = %q, want %s with an escape character", dialect, cond, want)
+		}
+		if !reflect.DeepEqual(args, []interface{}{`%50\%\_off\\%`, `%50\%\_off\\%`}) {
+			t.Errorf("%s contains args = %v, want the escaped pattern for the message and its replies", dialect, args)
+		}
+	}
+}
+
+func TestStoreMessageFilterLiteralWildcards(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		store.SaveChannel("C1", "general", "")
+		store.SaveMessage("1704067200.000100", "C1", "U1", "rollout at 100% done", "", 0, "")
+		store.SaveMessage("1704067300.000100", "C1", "U1", "rename snake_case keys", "", 0, "")
+		store.SaveMessage("1704067400.000100", "C1", "U1", `path C:\tmp`, "", 0, "")
+		store.SaveMessage("1704067500.000100", "C1", "U1", "plain text", "", 0, "")
+
+		for contains, want := range map[string][]string{
+			"%":      {"1704067200.000100"},
+			"0% d":   {"1704067200.000100"},
+			"_":      {"1704067300.000100"},
+			"e_c":    {"1704067300.000100"},
+			`\`:      {"1704067400.000100"},
+			`C:\tmp`: {"1704067400.000100"},
+			"plain%": nil,
+			"PLAIN":  {"1704067500.000100"},
+			"ext":    {"1704067500.000100"},
+		} {
+			if got := messageTimestamps(t, store, "C1", MessageFilter{Contains: contains}); !reflect.DeepEqual(got, want) {
+				t.Errorf("contains %q = %v, want %v", contains, got, want)
+			}
+		}
+	})
+}
+
+func TestParseFilterTime(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	for _, tc := range []struct {
+		value      string
+		upperBound bool
+		want       time.Time
+	}{
+		{"", false, time.Time{}},
+		{"2024-01-02", false, time.Date(2024, 1, 2, 0, 0, 0, 0, tokyo)},
+		// A date-only upper bound covers the whole day.
+		{"2024-01-02", true, time.Date(2024, 1, 3, 0, 0, 0, 0, tokyo)},
+		{"2024-12-31", true, time.Date(2025, 1, 1, 0, 0, 0, 0, tokyo)},
+		// Times of day are exact, even as an upper bound.
+		{"2024-01-02 15:04", true, time.Date(2024, 1, 2, 15, 4, 0, 0, tokyo)},
+		{"2024-01-02 15:04:05", false, time.Date(2024, 1, 2, 15, 4, 5, 0, tokyo)},
+		{"2024-01-02T15:04", false, time.Date(2024, 1, 2, 15, 4, 0, 0, tokyo)},
+		{"2024-01-02T15:04:05", false, time.Date(2024, 1, 2, 15, 4, 5, 0, tokyo)},
+		// RFC 3339 carries its own offset.
+		{"2024-01-02T15:04:05Z", false, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2024-01-02T15:04:05-05:00", true, time.Date(2024, 1, 2, 20, 4, 5, 0, time.UTC)},
+	} {
+		got, err := parseFilterTime(tc.value, tc.upperBound, tokyo)
+		if err != nil {
+			t.Errorf("parseFilterTime(%q, %v) failed: %v", tc.value, tc.upperBound, err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("parseFilterTime(%q, %v) = %v, want %v", tc.value, tc.upperBound, got, tc.want)
+		}
+	}
+
+	for _, value := range []string{"yesterday", "2024/01/02", "2024-13-01", "1704067200", "2024-01-02 25:00"} {
+		if _, err := parseFilterTime(value, false, tokyo); err == nil || !strings.Contains(err.Error(), "use YYYY-MM-DD") {
+			t.Errorf("parseFilterTime(%q) error = %v, want an invalid time error", value, err)
+		}
+	}
+}
@@ -4,8 +4,10 @@ go 1.19
 
 require (
 	github.com/glebarez/go-sqlite v1.21.2
+	github.com/lib/pq v1.10.9
 	github.com/slack-go/slack v0.12.3
 	golang.org/x/time v0.3.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// joinScope is the OAuth scope conversations.join needs.
+const joinScope = "channels:join"
+
+func (d *Database) SaveChannelJoin(channelID string, joinedAt time.Time) error {
+	return d.exec("save_channel_join", `
+		INSERT INTO channel_joins (channel_id, joined_at) VALUES (?, ?)
+		ON CONFLICT (channel_id) DO UPDATE SET joined_at = excluded.joined_at
+	`, channelID, joinedAt.Unix())
+}
+
+func (d *Database) GetChannelJoins() (map[string]time.Time, error) {
+	rows, err := d.db.Query("SELECT channel_id, joined_at FROM channel_joins")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	joins := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			channelID string
+			joinedAt  int64
+		)
+		if err := rows.Scan(&channelID, &joinedAt); err != nil {
+			return nil, err
+		}
+		joins[channelID] = time.Unix(joinedAt, 0)
+	}
+	return joins, rows.Err()
+}
+
+func (s *MemoryStore) SaveChannelJoin(channelID string, joinedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channelJoins[channelID] = joinedAt
+	return nil
+}
+
+func (s *MemoryStore) GetChannelJoins() (map[string]time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	joins := make(map[string]time.Time, len(s.channelJoins))
+	for id, joinedAt := range s.channelJoins {
+		joins[id] = joinedAt
+	}
+	return joins, nil
+}
+
+// canJoin reports why the bot cannot join channel with conversations.join,
+// or "" if it can. Only public, unarchived channels can be joined, and only
+// with the channels:join scope; unknown scopes are assumed to be granted.
+func canJoin(client SlackAPI, channel *slack.Channel) string {
+	switch {
+	case channel.IsPrivate:
+		return "private channels can only be joined by invitation"
+	case channel.IsArchived:
+		return "the channel is archived"
+	}
+	if reporter, ok := client.(scopeReporter); ok {
+		if scopes, ok := reporter.GrantedScopes(); ok && !containsString(scopes, joinScope) {
+			return "the token lacks the " + joinScope + " scope"
+		}
+	}
+	return ""
+}
+
+// joinChannel joins a channel the bot is not a member of and records the
+// join. historyErr is the not_in_channel error that prompted it and is
+// returned, wrapped, when the channel cannot be joined.
+func (mp *MessageProcessor) joinChannel(ctx context.Context, channel *slack.Channel, historyErr error) error {
+	if reason := canJoin(mp.slackClient, channel); reason != "" {
+		return fmt.Errorf("cannot join channel %s: %s: %w", channel.Name, reason, historyErr)
+	}
+
+	if _, err := mp.slackClient.JoinConversation(ctx, channel.ID); err != nil {
+		metrics.processorErrors.Inc("join")
+		return fmt.Errorf("failed to join channel %s: %w", channel.Name, err)
+	}
+	if err := mp.checkLease(); err != nil {
+		return err
+	}
+	if err := mp.db.SaveChannelJoin(channel.ID, time.Now()); err != nil {
+		return fmt.Errorf("failed to record channel join: %w", err)
+	}
+
+	log.Printf("Joined channel %s (%s)", channel.Name, channel.ID)
+	return nil
+}
+
+// planJoins prints which of the channels -auto-join would join, without
+// joining or fetching anything.
+func planJoins(ctx context.Context, client SlackAPI, channelIDs []string, out io.Writer) error {
+	var join, skip []string
+	for _, channelID := range channelIDs {
+		channel, err := client.GetChannelInfo(ctx, channelID)
+		if err != nil {
+			return fmt.Errorf("failed to get channel info for %s: %w", channelID, err)
+		}
+		if channel.IsMember {
+			continue
+		}
+
+		subject := fmt.Sprintf("#%s (%s)", channel.Name, channel.ID)
+		if reason := canJoin(client, channel); reason != "" {
+			skip = append(skip, fmt.Sprintf("%s: %s", subject, reason))
+		} else {
+			join = append(join, subject)
+		}
+	}
+	sort.Strings(join)
+	sort.Strings(skip)
+
+	if len(join) == 0 {
+		fmt.Fprintf(out, "No channels would be joined\n")
+	} else {
+		fmt.Fprintf(out, "Would join %s:\n", plural(len(join), "channel"))
+		for _, subject := range join {
+			fmt.Fprintf(out, "  %s\n", subject)
+		}
+	}
+	if len(skip) > 0 {
+		fmt.Fprintf(out, "Cannot join %s:\n", plural(len(skip), "channel"))
+		for _, subject := range skip {
+			fmt.Fprintf(out, "  %s\n", subject)
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
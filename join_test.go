@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestCanJoin(t *testing.T) {
+	public := &slack.Channel{}
+	private := &slack.Channel{}
+	private.IsPrivate = true
+	archived := &slack.Channel{}
+	archived.IsArchived = true
+
+	_, withJoin := newScopedFakeSlack(t, doctorFixtures(allDoctorScopes))
+	_, withoutJoin := newScopedFakeSlack(t, doctorFixtures([]string{"channels:history", "channels:read"}))
+	_, unknown := newFakeSlack(t, doctorFixtures(nil))
+	// Scopes are known once a response has been seen.
+	ctx := context.Background()
+	for _, client := range []*SlackClient{withJoin, withoutJoin, unknown} {
+		if _, err := client.AuthTest(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		client  SlackAPI
+		channel *slack.Channel
+		want    string
+	}{
+		{"public", withJoin, public, ""},
+		{"unknown scopes", unknown, public, ""},
+		{"missing scope", withoutJoin, public, "the token lacks the channels:join scope"},
+		{"private", withJoin, private, "private channels can only be joined by invitation"},
+		{"archived", withJoin, archived, "the channel is archived"},
+	} {
+		if got := canJoin(tc.client, tc.channel); got != tc.want {
+			t.Errorf("%s: canJoin = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPlanJoins(t *testing.T) {
+	fixtures := doctorFixtures(allDoctorScopes)
+	fixtures.Channels[2].IsMember = false // #old is archived
+	fixtures.Channels[3].IsMember = false // #secret is private
+	server, client := newScopedFakeSlack(t, fixtures)
+	client.AuthTest(context.Background())
+
+	var out strings.Builder
+	if err := planJoins(context.Background(), client, []string{"C1", "C2", "C3", "G1"}, &out); err != nil {
+		t.Fatalf("planJoins failed: %v", err)
+	}
+	want := "Would join 1 channel:\n" +
+		"  #random (C2)\n" +
+		"Cannot join 2 channels:\n" +
+		"  #old (C3): the channel is archived\n" +
+		"  #secret (G1): private channels can only be joined by invitation\n"
+	if out.String() != want {
+		t.Errorf("plan = %q, want %q", out.String(), want)
+	}
+	// Planning never joins.
+	if got := server.Calls("conversations.join"); got != 0 {
+		t.Errorf("conversations.join calls = %d, want none", got)
+	}
+
+	out.Reset()
+	if err := planJoins(context.Background(), client, []string{"C1"}, &out); err != nil || out.String() != "No channels would be joined\n" {
+		t.Errorf("plan for a joined channel = %q, %v", out.String(), err)
+	}
+	if err := planJoins(context.Background(), client, []string{"C9"}, &out); err == nil || !strings.Contains(err.Error(), "C9") {
+		t.Errorf("plan for an unknown channel error = %v", err)
+	}
+}
+
+func TestStoreChannelJoins(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		first := time.Unix(1704067200, 0)
+		store.SaveChannelJoin("C1", first)
+		store.SaveChannelJoin("C2", first)
+		// Joining again, e.g. after being removed, replaces the time.
+		store.SaveChannelJoin("C1", first.Add(time.Hour))
+
+		joins, err := store.GetChannelJoins()
+		if err != nil {
+			t.Fatalf("GetChannelJoins failed: %v", err)
+		}
+		if len(joins) != 2 || !joins["C1"].Equal(first.Add(time.Hour)) || !joins["C2"].Equal(first) {
+			t.Errorf("joins = %v", joins)
+		}
+	})
+}
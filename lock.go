@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// lockTTL is how long a lock outlives a process that died holding it.
+	lockTTL = 2 * time.Minute
+
+	// writerLockName is the database-wide lock every writing mode (fetch,
+	// daemon and migrate) holds for as long as it runs, so that only one
+	// of them writes to a database at a time.
+	writerLockName = "writer"
+)
+
+func (d *Database) AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	// The upsert only replaces a row we already own or one that expired, so
+	// exactly one row is affected when the lock is ours.
+	result, err := d.db.Exec(d.rebind(`
+		INSERT INTO locks (name, owner, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE locks.owner = excluded.owner OR locks.expires_at < ?
+	`), name, owner, now.Add(ttl).Unix(), now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %s: %w", name, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %s: %w", name, err)
+	}
+	return affected == 1, nil
+}
+
+func (d *Database) ReleaseLock(name, owner string) error {
+	if _, err := d.db.Exec(d.rebind("DELETE FROM locks WHERE name = ? AND owner = ?"), name, owner); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", name, err)
+	}
+	return nil
+}
+
+func (d *Database) GetLock(name string) (string, time.Time, error) {
+	var (
+		owner     string
+		expiresAt int64
+	)
+	err := d.db.QueryRow(d.rebind("SELECT owner, expires_at FROM locks WHERE name = ?"), name).Scan(&owner, &expiresAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read lock %s: %w", name, err)
+	}
+	return owner, time.Unix(expiresAt, 0), nil
+}
+
+func (s *MemoryStore) AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if lock, ok := s.locks[name]; ok && lock.owner != owner && lock.expiresAt.After(now) {
+		return false, nil
+	}
+	s.locks[name] = memoryLock{owner: owner, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *MemoryStore) ReleaseLock(name, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lock, ok := s.locks[name]; ok && lock.owner == owner {
+		delete(s.locks, name)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetLock(name string) (string, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lock, ok := s.locks[name]
+	if !ok {
+		return "", time.Time{}, nil
+	}
+	return lock.owner, lock.expiresAt, nil
+}
+
+// errLockLost is returned by Lease.Check once the lease can no longer be
+// trusted to exclude other processes.
+var errLockLost = errors.New("lost the lock to another process")
+
+var leaseCount int64
+
+// newLockOwner identifies a lease in the locks table: the host and process
+// holding it, plus a counter so leases within one process are distinct.
+func newLockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%d", host, os.Getpid(), atomic.AddInt64(&leaseCount, 1))
+}
+
+// Lease holds a store lock and renews it in the background until Release,
+// so a crashed holder blocks others for at most one ttl.
+type Lease struct {
+	store Store
+	name  string
+	owner string
+	ttl   time.Duration
+
+	// validUntil is when the lock expires unless renewed, in Unix
+	// nanoseconds. It is counted from before the acquiring call, so it is
+	// never later than the expiry stored in the locks table.
+	validUntil int64
+
+	stop     chan struct{}
+	done     chan struct{}
+	lostOnce sync.Once
+	lost     chan struct{}
+}
+
+// AcquireLease takes the named lock or fails, naming the holder, if another
+// process holds it.
+func AcquireLease(store Store, name string, ttl time.Duration) (*Lease, error) {
+	owner := newLockOwner()
+	start := time.Now()
+	ok, err := store.AcquireLock(name, owner, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		holder, expiresAt, err := store.GetLock(name)
+		if err != nil || holder == "" {
+			return nil, fmt.Errorf("another run holds the %s lock on %s", name, store)
+		}
+		return nil, fmt.Errorf("another run holds the %s lock on %s (owner %s, expires %s)",
+			name, store, holder, expiresAt.Format(time.RFC3339))
+	}
+
+	lease := &Lease{
+		store:      store,
+		name:       name,
+		owner:      owner,
+		ttl:        ttl,
+		validUntil: start.Add(ttl).UnixNano(),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		lost:       make(chan struct{}),
+	}
+	go lease.renew()
+	return lease, nil
+}
+
+func (l *Lease) renew() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			start := time.Now()
+			ok, err := l.store.AcquireLock(l.name, l.owner, l.ttl)
+			if err != nil {
+				// Keep trying; the lock is still ours until it expires.
+				log.Printf("Failed to renew lock %s: %v", l.name, err)
+				if l.Check() != nil {
+					return
+				}
+				continue
+			}
+			if !ok {
+				log.Printf("Lost lock %s to another process", l.name)
+				l.markLost()
+				return
+			}
+			atomic.StoreInt64(&l.validUntil, start.Add(l.ttl).UnixNano())
+		}
+	}
+}
+
+func (l *Lease) markLost() {
+	l.lostOnce.Do(func() { close(l.lost) })
+}
+
+// Check returns an error wrapping errLockLost if another process took the
+// lock or it expired without being renewed, e.g. because the process was
+// stalled. Writers call it before every commit.
+func (l *Lease) Check() error {
+	select {
+	case <-l.lost:
+	default:
+		if time.Now().UnixNano() < atomic.LoadInt64(&l.validUntil) {
+			return nil
+		}
+		log.Printf("Lock %s expired before it could be renewed", l.name)
+		l.markLost()
+	}
+	return fmt.Errorf("%s lock: %w", l.name, errLockLost)
+}
+
+// Owner identifies the holder in the locks table.
+func (l *Lease) Owner() string {
+	return l.owner
+}
+
+// Lost is closed if another process took over the lock or it expired.
+// Check reports the same as an error.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Context returns a copy of parent that is cancelled when the lease is
+// lost, so a writer also stops waiting on network calls.
+func (l *Lease) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-l.lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Release stops renewing and frees the lock.
+func (l *Lease) Release() error {
+	close(l.stop)
+	<-l.done
+	return l.store.ReleaseLock(l.name, l.owner)
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// mcpProtocolVersion is the Model Context Protocol revision implemented by
+// MCPServer.
+const mcpProtocolVersion = "2024-11-05"
+
+const mcpResourcePrefix = "slack://channels/"
+
+// MCPServer exposes the archive to AI assistants over the Model Context
+// Protocol: JSON-RPC 2.0 messages, one per line, on stdin and stdout.
+// Tool results and resources are rendered with Exporter, so assistants see
+// the same text as the CLI exports.
+type MCPServer struct {
+	store   Store
+	options ExportOptions
+	tools   []mcpTool
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+type mcpTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+	handler     func(args json.RawMessage) (string, error)
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// NewMCPServer creates a server rendering with options; Format and Filter
+// are set per request.
+func NewMCPServer(store Store, options ExportOptions) *MCPServer {
+	s := &MCPServer{store: store, options: options}
+	s.tools = []mcpTool{
+		{
+			Name:        "list_channels",
+			Description: "List the archived Slack channels with their IDs and message counts.",
+			InputSchema: json.RawMessage(`{"type": "object", "properties": {}}`),
+			handler:     s.listChannels,
+		},
+		{
+			Name:        "get_thread",
+			Description: "Get a thread: the parent message and all of its replies.",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"channel": {"type": "string", "description": "Channel ID or name"},
+					"ts": {"type": "string", "description": "Timestamp of the parent message"}
+				},
+				"required": ["channel", "ts"]
+			}`),
+			handler: s.getThread,
+		},
+		{
+			Name:        "search_messages",
+			Description: "Full-text search over messages and replies. Each hit is shown with the surrounding thread.",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"query": {"type": "string", "description": "Space-separated terms; all must match"},
+					"channel": {"type": "string", "description": "Channel ID or name to search in"},
+					"user": {"type": "string", "description": "Only messages by this user ID or name"},
+					"from": {"type": "string", "description": "Start date or time (YYYY-MM-DD or RFC 3339)"},
+					"to": {"type": "string", "description": "End date or time, inclusive for dates"},
+					"limit": {"type": "integer", "description": "Maximum number of results (default 10)"}
+				},
+				"required": ["query"]
+			}`),
+			handler: s.searchMessages,
+		},
+		{
+			Name:        "get_user",
+			Description: "Look up a user by ID, user name or display name.",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"user": {"type": "string", "description": "User ID, name or display name"}
+				},
+				"required": ["user"]
+			}`),
+			handler: s.getUser,
+		},
+		{
+			Name:        "get_channel_history",
+			Description: "Get the threads of a channel within a time range, oldest first, optionally filtered by user or text.",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"channel": {"type": "string", "description": "Channel ID or name"},
+					"from": {"type": "string", "description": "Start date or time (YYYY-MM-DD or RFC 3339)"},
+					"to": {"type": "string", "description": "End date or time, inclusive for dates"},
+					"user": {"type": "string", "description": "Only threads where this user posted"},
+					"contains": {"type": "string", "description": "Only threads containing this text"}
+				},
+				"required": ["channel"]
+			}`),
+			handler: s.getChannelHistory,
+		},
+	}
+	return s
+}
+
+// Serve handles requests from r until it is closed.
+func (s *MCPServer) Serve(r io.Reader, w io.Writer) error {
+	s.out = w
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			s.writeError(json.RawMessage("null"), rpcParseError, "parse error")
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			s.writeError(req.ID, rpcInvalidRequest, "invalid request")
+			continue
+		}
+
+		result, rpcErr := s.dispatch(req)
+		// Notifications have no ID and get no response.
+		if len(req.ID) == 0 {
+			continue
+		}
+		if rpcErr != nil {
+			s.writeError(req.ID, rpcErr.Code, rpcErr.Message)
+			continue
+		}
+		s.write(rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+	}
+	return scanner.Err()
+}
+
+func (s *MCPServer) write(resp rpcResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		data, _ = json.Marshal(rpcResponse{JSONRPC: "2.0", ID: resp.ID, Error: &rpcError{Code: rpcInternalError, Message: err.Error()}})
+	}
+	s.out.Write(append(data, '\n'))
+}
+
+func (s *MCPServer) writeError(id json.RawMessage, code int, message string) {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	s.write(rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}})
+}
+
+func (s *MCPServer) dispatch(req rpcRequest) (interface{}, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities": map[string]interface{}{
+				"tools":     map[string]interface{}{},
+				"resources": map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "slack-all-contexts", "version": "1.0.0"},
+		}, nil
+	case "notifications/initialized", "notifications/cancelled":
+		return nil, nil
+	case "ping":
+		return map[string]interface{}{}, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": s.tools}, nil
+	case "tools/call":
+		return s.callTool(req.Params)
+	case "resources/list":
+		return s.listResources()
+	case "resources/templates/list":
+		return map[string]interface{}{
+			"resourceTemplates": []map[string]string{{
+				"uriTemplate": mcpResourcePrefix + "{channel}{?format}",
+				"name":        "Channel export",
+				"description": "Full export of a channel; format is text (default), json or jsonl",
+				"mimeType":    "text/plain",
+			}},
+		}, nil
+	case "resources/read":
+		return s.readResource(req.Params)
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+	}
+}
+
+func (s *MCPServer) callTool(params json.RawMessage) (interface{}, *rpcError) {
+	var call struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &call); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	if len(call.Arguments) == 0 {
+		call.Arguments = json.RawMessage("{}")
+	}
+
+	for _, tool := range s.tools {
+		if tool.Name != call.Name {
+			continue
+		}
+		// Tool failures are reported in the result so the assistant can
+		// see them and adjust its arguments.
+		text, err := tool.handler(call.Arguments)
+		if err != nil {
+			return mcpToolResult(err.Error(), true), nil
+		}
+		return mcpToolResult(text, false), nil
+	}
+	return nil, &rpcError{Code: rpcInvalidParams, Message: "unknown tool: " + call.Name}
+}
+
+func mcpToolResult(text string, isError bool) map[string]interface{} {
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+// resolveChannel accepts a channel ID or name, with or without #.
+func (s *MCPServer) resolveChannel(channel string) (string, string, error) {
+	channel = strings.TrimPrefix(strings.TrimSpace(channel), "#")
+	if channel == "" {
+		return "", "", fmt.Errorf("channel is required")
+	}
+
+	channels, err := s.store.GetChannels()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get channels: %w", err)
+	}
+	if name, ok := channels[channel]; ok {
+		return channel, name, nil
+	}
+	for id, name := range channels {
+		if name == channel {
+			return id, name, nil
+		}
+	}
+	return "", "", fmt.Errorf("channel %s not found; use list_channels to see archived channels", channel)
+}
+
+func (s *MCPServer) exporter(format string, filter MessageFilter) *Exporter {
+	options := s.options
+	options.Format = format
+	options.Filter = filter
+	return NewExporter(s.store, options)
+}
+
+func (s *MCPServer) listChannels(args json.RawMessage) (string, error) {
+	channels, err := s.store.GetChannels()
+	if err != nil {
+		return "", fmt.Errorf("failed to get channels: %w", err)
+	}
+	if len(channels) == 0 {
+		return "No channels found in the archive.", nil
+	}
+
+	ids := make([]string, 0, len(channels))
+	for id := range channels {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return channels[ids[i]] < channels[ids[j]] })
+
+	var b strings.Builder
+	for _, id := range ids {
+		count, err := s.store.CountMessages(id, MessageFilter{})
+		if err != nil {
+			return "", fmt.Errorf("failed to count messages: %w", err)
+		}
+		fmt.Fprintf(&b, "#%s (%s): %d messages\n", channels[id], id, count)
+	}
+	return b.String(), nil
+}
+
+func (s *MCPServer) getThread(args json.RawMessage) (string, error) {
+	var params struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	channelID, _, err := s.resolveChannel(params.Channel)
+	if err != nil {
+		return "", err
+	}
+	if params.TS == "" {
+		return "", fmt.Errorf("ts is required")
+	}
+
+	exporter := s.exporter("text", MessageFilter{})
+	var b strings.Builder
+	found := false
+	err = s.store.EachMessageWithReplies(channelID, MessageFilter{ThreadTS: params.TS}, func(msg MessageWithReplies) error {
+		found = true
+		exporter.redactMessage(&msg)
+		exporter.writeTextMessage(&b, msg)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to load thread: %w", err)
+	}
+
+	if !found {
+		return "", fmt.Errorf("thread %s not found in channel %s", params.TS, channelID)
+	}
+	return b.String(), nil
+}
+
+func (s *MCPServer) searchMessages(args json.RawMessage) (string, error) {
+	var params struct {
+		Query   string `json:"query"`
+		Channel string `json:"channel"`
+		User    string `json:"user"`
+		From    string `json:"from"`
+		To      string `json:"to"`
+		Limit   int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if strings.TrimSpace(params.Query) == "" {
+		return "", fmt.Errorf("query is required")
+	}
+
+	var channelID string
+	if params.Channel != "" {
+		var err error
+		if channelID, _, err = s.resolveChannel(params.Channel); err != nil {
+			return "", err
+		}
+	}
+
+	filter, err := buildMessageFilter(params.From, params.To, params.User, "", "", 0, s.options.Location)
+	if err != nil {
+		return "", err
+	}
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+
+	results, err := s.store.Search(SearchOptions{
+		Query:     params.Query,
+		ChannelID: channelID,
+		User:      filter.User,
+		From:      filter.From,
+		To:        filter.To,
+		Limit:     limit,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to search: %w", err)
+	}
+	redactSearchResults(s.options.Redactor, results, params.Query)
+
+	var b strings.Builder
+	if err := s.exporter("text", MessageFilter{}).WriteSearchResults(&b, results, 3); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (s *MCPServer) getUser(args json.RawMessage) (string, error) {
+	var params struct {
+		User string `json:"user"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	query := strings.TrimPrefix(strings.TrimSpace(params.User), "@")
+	if query == "" {
+		return "", fmt.Errorf("user is required")
+	}
+
+	users, err := s.store.GetUsers()
+	if err != nil {
+		return "", fmt.Errorf("failed to get users: %w", err)
+	}
+
+	for _, user := range users {
+		if user.ID != query && user.Name != query && user.DisplayName != query && user.RealName != query {
+			continue
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "User ID: %s\n", user.ID)
+		fmt.Fprintf(&b, "Name: @%s\n", user.Name)
+		if user.RealName != "" {
+			fmt.Fprintf(&b, "Real Name: %s\n", user.RealName)
+		}
+		if user.DisplayName != "" {
+			fmt.Fprintf(&b, "Display Name: %s\n", user.DisplayName)
+		}
+		if user.Email != "" {
+			fmt.Fprintf(&b, "Email: %s\n", s.options.Redactor.Redact(user.Email))
+		}
+		if user.TZ != "" {
+			fmt.Fprintf(&b, "Time Zone: %s\n", user.TZ)
+		}
+		fmt.Fprintf(&b, "Mention: <@%s>\n", user.ID)
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("user %s not found", query)
+}
+
+func (s *MCPServer) getChannelHistory(args json.RawMessage) (string, error) {
+	var params struct {
+		Channel  string `json:"channel"`
+		From     string `json:"from"`
+		To       string `json:"to"`
+		User     string `json:"user"`
+		Contains string `json:"contains"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	channelID, _, err := s.resolveChannel(params.Channel)
+	if err != nil {
+		return "", err
+	}
+
+	filter, err := buildMessageFilter(params.From, params.To, params.User, "", params.Contains, 0, s.options.Location)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := s.exporter("text", filter).WriteText(&b, channelID); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (s *MCPServer) listResources() (interface{}, *rpcError) {
+	channels, err := s.store.GetChannels()
+	if err != nil {
+		return nil, &rpcError{Code: rpcInternalError, Message: err.Error()}
+	}
+
+	resources := make([]map[string]string, 0, len(channels))
+	for id, name := range channels {
+		resources = append(resources, map[string]string{
+			"uri":         mcpResourcePrefix + id,
+			"name":        "#" + name,
+			"description": fmt.Sprintf("Full text export of #%s", name),
+			"mimeType":    "text/plain",
+		})
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i]["name"] < resources[j]["name"] })
+	return map[string]interface{}{"resources": resources}, nil
+}
+
+func (s *MCPServer) readResource(params json.RawMessage) (interface{}, *rpcError) {
+	var req struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	u, err := url.Parse(req.URI)
+	if err != nil || !strings.HasPrefix(req.URI, mcpResourcePrefix) {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "unknown resource: " + req.URI}
+	}
+	channelID, _, err := s.resolveChannel(strings.TrimPrefix(strings.SplitN(req.URI, "?", 2)[0], mcpResourcePrefix))
+	if err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	format := u.Query().Get("format")
+	mimeType := "text/plain"
+	exporter := s.exporter("text", MessageFilter{})
+	write := exporter.WriteText
+	switch format {
+	case "", "text":
+	case "json":
+		mimeType, write = "application/json", exporter.WriteJSON
+	case "jsonl":
+		mimeType, write = "application/jsonl", exporter.WriteJSONL
+	default:
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "unsupported format: " + format}
+	}
+
+	var b strings.Builder
+	if err := write(&b, channelID); err != nil {
+		return nil, &rpcError{Code: rpcInternalError, Message: err.Error()}
+	}
+	return map[string]interface{}{
+		"contents": []map[string]string{{"uri": req.URI, "mimeType": mimeType, "text": b.String()}},
+	}, nil
+}
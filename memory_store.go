@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryKey struct {
+	channelID string
+	ts        string
+}
+
+type memoryMessage struct {
+	userID     string
+	text       string
+	threadTS   string
+	replyCount int
+	teamID     string
+}
+
+type memoryReply struct {
+	threadTS string
+	userID   string
+	text     string
+	teamID   string
+}
+
+// MemoryStore is a Store that keeps everything in memory. It is selected
+// with -db memory: and is mainly useful for tests and dry runs; nothing is
+// persisted when the process exits.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	channels     map[string]string
+	channelTeams map[string]string
+	teams        map[string]Team
+	users        map[string]User
+	userTeams    map[string]map[string]bool
+	messages     map[memoryKey]memoryMessage
+	replies      map[memoryKey]memoryReply
+	fetchStates  map[string]FetchState
+	locks        map[string]memoryLock
+	channelJoins map[string]time.Time
+}
+
+type memoryLock struct {
+	owner     string
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		channels:     make(map[string]string),
+		channelTeams: make(map[string]string),
+		teams:        make(map[string]Team),
+		users:        make(map[string]User),
+		userTeams:    make(map[string]map[string]bool),
+		messages:     make(map[memoryKey]memoryMessage),
+		replies:      make(map[memoryKey]memoryReply),
+		fetchStates:  make(map[string]FetchState),
+		locks:        make(map[string]memoryLock),
+		channelJoins: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) SaveChannel(id, name, teamID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[id] = name
+	s.channelTeams[id] = teamID
+	return nil
+}
+
+func (s *MemoryStore) GetChannelName(channelID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.channels[channelID], nil
+}
+
+func (s *MemoryStore) GetChannels() (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	channels := make(map[string]string, len(s.channels))
+	for id, name := range s.channels {
+		channels[id] = name
+	}
+	return channels, nil
+}
+
+func (s *MemoryStore) SaveUser(id, name, realName, displayName, email, profileImage, tz, teamID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saveUser(id, name, realName, displayName, email, profileImage, tz, teamID)
+	return nil
+}
+
+func (s *MemoryStore) saveUser(id, name, realName, displayName, email, profileImage, tz, teamID string) {
+	s.users[id] = User{
+		ID:           id,
+		Name:         name,
+		RealName:     realName,
+		DisplayName:  displayName,
+		Email:        email,
+		ProfileImage: profileImage,
+		TZ:           tz,
+		TeamID:       teamID,
+	}
+}
+
+func (s *MemoryStore) saveUserTeams(userID string, teamIDs []string) {
+	if s.userTeams[userID] == nil {
+		s.userTeams[userID] = make(map[string]bool)
+	}
+	for _, teamID := range teamIDs {
+		s.userTeams[userID][teamID] = true
+	}
+}
+
+func (s *MemoryStore) GetUsers() ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		user.Teams = nil
+		for teamID := range s.userTeams[user.ID] {
+			user.Teams = append(user.Teams, teamID)
+		}
+		sort.Strings(user.Teams)
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users, nil
+}
+
+func (s *MemoryStore) SaveMessage(ts, channelID, userID, text, threadTS string, replyCount int, teamID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[memoryKey{channelID, ts}] = memoryMessage{userID: userID, text: text, threadTS: threadTS, replyCount: replyCount, teamID: teamID}
+	return nil
+}
+
+func (s *MemoryStore) SaveReply(ts, threadTS, channelID, userID, text, teamID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies[memoryKey{channelID, ts}] = memoryReply{threadTS: threadTS, userID: userID, text: text, teamID: teamID}
+	return nil
+}
+
+// EachMessageWithReplies takes a snapshot of the matching threads before
+// calling fn, so fn may safely use the store.
+func (s *MemoryStore) EachMessageWithReplies(channelID string, filter MessageFilter, fn func(MessageWithReplies) error) error {
+	for _, msg := range s.threads(channelID, filter) {
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CountMessages(channelID string, filter MessageFilter) (int, error) {
+	return len(s.threads(channelID, filter)), nil
+}
+
+func (s *MemoryStore) threads(channelID string, filter MessageFilter) []MessageWithReplies {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	replies := make(map[string][]Reply)
+	for key, r := range s.replies {
+		if key.channelID != channelID {
+			continue
+		}
+		user := s.users[r.userID]
+		replies[r.threadTS] = append(replies[r.threadTS], Reply{
+			Timestamp:       key.ts,
+			UserID:          r.userID,
+			UserName:        user.Name,
+			UserRealName:    user.RealName,
+			UserDisplayName: user.DisplayName,
+			UserTZ:          user.TZ,
+			Text:            r.text,
+		})
+	}
+
+	var threads []MessageWithReplies
+	for key, m := range s.messages {
+		if key.channelID != channelID {
+			continue
+		}
+		channelName, ok := s.channels[channelID]
+		if !ok {
+			continue
+		}
+
+		user := s.users[m.userID]
+		msg := MessageWithReplies{
+			Timestamp:       key.ts,
+			ChannelID:       channelID,
+			ChannelName:     channelName,
+			TeamID:          m.teamID,
+			UserID:          m.userID,
+			UserName:        user.Name,
+			UserRealName:    user.RealName,
+			UserDisplayName: user.DisplayName,
+			UserTZ:          user.TZ,
+			Text:            m.text,
+			ThreadTS:        m.threadTS,
+			ReplyCount:      m.replyCount,
+		}
+		if m.replyCount > 0 && m.threadTS != "" {
+			msg.Replies = append([]Reply(nil), replies[m.threadTS]...)
+			sort.Slice(msg.Replies, func(i, j int) bool { return msg.Replies[i].Timestamp < msg.Replies[j].Timestamp })
+		}
+
+		if s.matches(filter, msg, replies[key.ts]) {
+			threads = append(threads, msg)
+		}
+	}
+
+	sort.Slice(threads, func(i, j int) bool { return threads[i].Timestamp < threads[j].Timestamp })
+	return threads
+}
+
+// matches applies filter the same way MessageFilter.sql does, with
+// threadReplies being the replies whose thread_ts is the message's own ts.
+func (s *MemoryStore) matches(f MessageFilter, msg MessageWithReplies, threadReplies []Reply) bool {
+	if !f.From.IsZero() && tsSeconds(msg.Timestamp) < unixSeconds(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && tsSeconds(msg.Timestamp) >= unixSeconds(f.To) {
+		return false
+	}
+
+	if f.User != "" {
+		user := strings.TrimPrefix(f.User, "@")
+		found := s.isUser(msg.UserID, user)
+		for _, r := range threadReplies {
+			found = found || s.isUser(r.UserID, user)
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.TeamID != "" && msg.TeamID != f.TeamID {
+		return false
+	}
+
+	if f.ThreadTS != "" && msg.Timestamp != f.ThreadTS && msg.ThreadTS != f.ThreadTS {
+		return false
+	}
+
+	if f.Contains != "" {
+		found := containsFold(msg.Text, f.Contains)
+		for _, r := range threadReplies {
+			found = found || containsFold(r.Text, f.Contains)
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return msg.ReplyCount >= f.MinReplies
+}
+
+func (s *MemoryStore) isUser(userID, user string) bool {
+	if userID == "" {
+		return false
+	}
+	if userID == user {
+		return true
+	}
+	u, ok := s.users[userID]
+	return ok && (u.Name == user || u.DisplayName == user)
+}
+
+// Search matches every query term as a case-insensitive substring, newest
+// results first.
+func (s *MemoryStore) Search(opts SearchOptions) ([]SearchResult, error) {
+	terms := strings.Fields(opts.Query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("search query is empty")
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	s.mu.RLock()
+	var results []SearchResult
+	add := func(kind string, key memoryKey, threadTS, userID, text, teamID string) {
+		if opts.ChannelID != "" && key.channelID != opts.ChannelID {
+			return
+		}
+		if opts.TeamID != "" && teamID != opts.TeamID {
+			return
+		}
+		if opts.User != "" && !s.isUser(userID, strings.TrimPrefix(opts.User, "@")) {
+			return
+		}
+		if !opts.From.IsZero() && tsSeconds(key.ts) < unixSeconds(opts.From) {
+			return
+		}
+		if !opts.To.IsZero() && tsSeconds(key.ts) >= unixSeconds(opts.To) {
+			return
+		}
+		for _, term := range terms {
+			if !containsFold(text, term) {
+				return
+			}
+		}
+
+		user := s.users[userID]
+		results = append(results, SearchResult{
+			Kind:            kind,
+			ChannelID:       key.channelID,
+			ChannelName:     s.channels[key.channelID],
+			TeamID:          teamID,
+			Timestamp:       key.ts,
+			ThreadTS:        threadTS,
+			UserID:          userID,
+			UserName:        user.Name,
+			UserRealName:    user.RealName,
+			UserDisplayName: user.DisplayName,
+			UserTZ:          user.TZ,
+			Text:            text,
+			Snippet:         highlightSnippet(text, terms),
+		})
+	}
+	for key, m := range s.messages {
+		add("message", key, key.ts, m.userID, m.text, m.teamID)
+	}
+	for key, r := range s.replies {
+		add("reply", key, r.threadTS, r.userID, r.text, r.teamID)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Timestamp > results[j].Timestamp })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) GetFetchState(channelID string) (FetchState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if state, ok := s.fetchStates[channelID]; ok {
+		return state, nil
+	}
+	return FetchState{ChannelID: channelID}, nil
+}
+
+func (s *MemoryStore) CompleteFetch(channelID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.fetchStates[channelID]
+	if !ok {
+		state = FetchState{ChannelID: channelID}
+	}
+	state.CompletedAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+	s.fetchStates[channelID] = state
+	return nil
+}
+
+func (s *MemoryStore) BeginBatch() (Batch, error) {
+	return &memoryBatch{store: s}, nil
+}
+
+func (s *MemoryStore) String() string {
+	return memoryStoreDSN
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// memoryBatch buffers writes and applies them, together with the fetch
+// state, under a single lock on Commit.
+type memoryBatch struct {
+	store        *MemoryStore
+	writes       []func(*MemoryStore)
+	pendingState *FetchState
+	commitCheck  func() error
+}
+
+func (b *memoryBatch) SaveMessage(ts, channelID, userID, text, threadTS string, replyCount int, teamID string) error {
+	b.writes = append(b.writes, func(s *MemoryStore) {
+		s.messages[memoryKey{channelID, ts}] = memoryMessage{userID: userID, text: text, threadTS: threadTS, replyCount: replyCount, teamID: teamID}
+	})
+	return nil
+}
+
+func (b *memoryBatch) SaveReply(ts, threadTS, channelID, userID, text, teamID string) error {
+	b.writes = append(b.writes, func(s *MemoryStore) {
+		s.replies[memoryKey{channelID, ts}] = memoryReply{threadTS: threadTS, userID: userID, text: text, teamID: teamID}
+	})
+	return nil
+}
+
+func (b *memoryBatch) SaveUser(id, name, realName, displayName, email, profileImage, tz, teamID string) error {
+	b.writes = append(b.writes, func(s *MemoryStore) {
+		s.saveUser(id, name, realName, displayName, email, profileImage, tz, teamID)
+	})
+	return nil
+}
+
+func (b *memoryBatch) SaveUserTeams(userID string, teamIDs []string) error {
+	b.writes = append(b.writes, func(s *MemoryStore) {
+		s.saveUserTeams(userID, teamIDs)
+	})
+	return nil
+}
+
+func (b *memoryBatch) SetFetchState(state FetchState) {
+	b.pendingState = &state
+}
+
+func (b *memoryBatch) SetCommitCheck(check func() error) {
+	b.commitCheck = check
+}
+
+func (b *memoryBatch) Commit() error {
+	if b.commitCheck != nil {
+		if err := b.commitCheck(); err != nil {
+			b.Rollback()
+			return err
+		}
+	}
+
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	for _, write := range b.writes {
+		write(b.store)
+	}
+	if b.pendingState != nil {
+		state := *b.pendingState
+		state.UpdatedAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+		b.store.fetchStates[state.ChannelID] = state
+	}
+	b.writes, b.pendingState = nil, nil
+	return nil
+}
+
+func (b *memoryBatch) Rollback() error {
+	b.writes, b.pendingState = nil, nil
+	return nil
+}
+
+func tsSeconds(ts string) float64 {
+	seconds, _ := strconv.ParseFloat(ts, 64)
+	return seconds
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics holds the process-wide instrumentation exposed on /metrics in the
+// Prometheus text format.
+var metrics = newMetricsRegistry()
+
+// defaultLatencyBuckets are upper bounds in seconds, as in the Prometheus
+// client libraries.
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricsRegistry struct {
+	slackRequests        *counterVec
+	slackRequestDuration *histogramVec
+	slackRateLimited     *counterVec
+	slackRetries         *counterVec
+	slackLimiterWait     *histogramVec
+
+	messagesSaved   *counterVec
+	repliesSaved    *counterVec
+	usersSaved      *counterVec
+	processorErrors *counterVec
+	redactions      *counterVec
+
+	dbWriteDuration *histogramVec
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		slackRequests:        newCounterVec("slack_archive_slack_requests_total", "Slack API requests by method and result (ok, error or rate_limited).", "method", "result"),
+		slackRequestDuration: newHistogramVec("slack_archive_slack_request_duration_seconds", "Slack API request latency.", defaultLatencyBuckets, "method"),
+		slackRateLimited:     newCounterVec("slack_archive_slack_rate_limited_total", "Slack API responses with HTTP 429.", "method"),
+		slackRetries:         newCounterVec("slack_archive_slack_retries_total", "Slack API requests retried after a rate limit.", "method"),
+		slackLimiterWait:     newHistogramVec("slack_archive_slack_limiter_wait_seconds", "Time spent waiting for the client-side rate limiter.", []float64{0.01, 0.1, 0.5, 1, 2, 5, 10, 30}, "method"),
+
+		messagesSaved:   newCounterVec("slack_archive_messages_saved_total", "Messages written by fetches."),
+		repliesSaved:    newCounterVec("slack_archive_replies_saved_total", "Thread replies written by fetches."),
+		usersSaved:      newCounterVec("slack_archive_users_saved_total", "Users written by fetches."),
+		processorErrors: newCounterVec("slack_archive_processor_errors_total", "Fetch errors by stage (channel, history, join, replies, users, save or commit).", "stage"),
+		redactions:      newCounterVec("slack_archive_redactions_total", "Items redacted from message text by rule.", "rule"),
+
+		dbWriteDuration: newHistogramVec("slack_archive_db_write_duration_seconds", "Database write latency by operation.", defaultLatencyBuckets, "operation"),
+	}
+}
+
+func (m *metricsRegistry) write(w io.Writer) {
+	m.slackRequests.write(w)
+	m.slackRequestDuration.write(w)
+	m.slackRateLimited.write(w)
+	m.slackRetries.write(w)
+	m.slackLimiterWait.write(w)
+	m.messagesSaved.write(w)
+	m.repliesSaved.write(w)
+	m.usersSaved.write(w)
+	m.processorErrors.write(w)
+	m.redactions.write(w)
+	m.dbWriteDuration.write(w)
+}
+
+// observeDuration records the time since start in h.
+func observeDuration(h *histogramVec, start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *counterVec) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelKey(labelValues)] += v
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, "", ""), formatFloat(c.values[key]))
+	}
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := labelKey(labelValues)
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hist := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", formatFloat(bound)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, "", ""), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, "", ""), hist.count)
+	}
+}
+
+// labelKey joins label values into a map key; formatLabels splits it again.
+func labelKey(values []string) string {
+	return strings.Join(values, "\x00")
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels renders {name="value",...}, appending extraName if set.
+func formatLabels(names []string, key, extraName, extraValue string) string {
+	var pairs []string
+	if len(names) > 0 {
+		values := strings.Split(key, "\x00")
+		for i, name := range names {
+			value := ""
+			if i < len(values) {
+				value = values[i]
+			}
+			pairs = append(pairs, name+`="`+escapeLabelValue(value)+`"`)
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Size reports the bytes used by the database: the SQLite file and its
+// WAL, or the PostgreSQL database. In-memory databases report zero.
+func (d *Database) Size() (int64, error) {
+	if d.dialect == dialectPostgres {
+		var size int64
+		err := d.db.QueryRow("SELECT pg_database_size(current_database())").Scan(&size)
+		return size, err
+	}
+
+	file := d.filePath()
+	if file == "" {
+		return 0, nil
+	}
+
+	var size int64
+	for _, path := range []string{file, file + "-wal"} {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+type channelSync struct {
+	ChannelID   string `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	// LastSync is when a fetch of the channel last finished successfully;
+	// pages committed by failed or running fetches do not count.
+	LastSync   string `json:"last_sync,omitempty"`
+	InProgress bool   `json:"in_progress"`
+
+	lastSync time.Time
+}
+
+// channelSyncs reads the fetch state of every channel, sorted by ID.
+func channelSyncs(store Store) ([]channelSync, error) {
+	channels, err := store.GetChannels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channels: %w", err)
+	}
+
+	syncs := make([]channelSync, 0, len(channels))
+	for id, name := range channels {
+		state, err := store.GetFetchState(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get fetch state: %w", err)
+		}
+		channel := channelSync{ChannelID: id, ChannelName: name, InProgress: state.InProgress()}
+		if t, err := parseUpdatedAt(state.CompletedAt); err == nil {
+			channel.lastSync = t
+			channel.LastSync = t.Format(time.RFC3339)
+		}
+		syncs = append(syncs, channel)
+	}
+	sort.Slice(syncs, func(i, j int) bool { return syncs[i].ChannelID < syncs[j].ChannelID })
+	return syncs, nil
+}
+
+// metricsHandler serves the registry plus gauges read from store at scrape
+// time.
+func metricsHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.write(w)
+
+		if db, ok := store.(*Database); ok {
+			if size, err := db.Size(); err != nil {
+				log.Printf("Failed to read database size: %v", err)
+			} else {
+				fmt.Fprintf(w, "# HELP slack_archive_db_size_bytes Size of the database.\n# TYPE slack_archive_db_size_bytes gauge\n")
+				fmt.Fprintf(w, "slack_archive_db_size_bytes %d\n", size)
+			}
+		}
+
+		syncs, err := channelSyncs(store)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "# HELP slack_archive_last_sync_timestamp_seconds Unix time of the last successful fetch per channel.\n# TYPE slack_archive_last_sync_timestamp_seconds gauge\n")
+		for _, channel := range syncs {
+			if channel.lastSync.IsZero() {
+				continue
+			}
+			labels := formatLabels([]string{"channel_id", "channel_name"}, labelKey([]string{channel.ChannelID, channel.ChannelName}), "", "")
+			fmt.Fprintf(w, "slack_archive_last_sync_timestamp_seconds%s %d\n", labels, channel.lastSync.Unix())
+		}
+	})
+}
+
+type healthResponse struct {
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Channels []channelSync `json:"channels"`
+	Stale    []string      `json:"stale,omitempty"`
+}
+
+// healthHandler reports the last sync of every channel. It answers 503 if
+// the store cannot be read or, with ?max_age=2h, if a channel has not been
+// synced within that long.
+func healthHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var maxAge time.Duration
+		if value := r.URL.Query().Get("max_age"); value != "" {
+			var err error
+			if maxAge, err = time.ParseDuration(value); err != nil {
+				writeHealth(w, http.StatusBadRequest, healthResponse{Status: "error", Error: "invalid max_age: " + err.Error()})
+				return
+			}
+		}
+
+		syncs, err := channelSyncs(store)
+		if err != nil {
+			writeHealth(w, http.StatusServiceUnavailable, healthResponse{Status: "error", Error: err.Error()})
+			return
+		}
+
+		resp := healthResponse{Status: "ok", Channels: syncs}
+		if maxAge > 0 {
+			for _, channel := range syncs {
+				if time.Since(channel.lastSync) > maxAge {
+					resp.Stale = append(resp.Stale, channel.ChannelID)
+				}
+			}
+		}
+		if len(resp.Stale) > 0 {
+			resp.Status = "stale"
+			writeHealth(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+		writeHealth(w, http.StatusOK, resp)
+	})
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(resp)
+}
+
+// withOpsEndpoints adds /metrics and /healthz in front of h.
+func withOpsEndpoints(h http.Handler, store Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler(store))
+	mux.Handle("/healthz", healthHandler(store))
+	mux.Handle("/", h)
+	return mux
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDatabaseSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slack.db")
+
+	for _, dsn := range []string{
+		path,
+		"file:" + path,
+		"file:" + path + "?_pragma=foreign_keys(1)",
+	} {
+		t.Run(dsn, func(t *testing.T) {
+			db, err := NewDatabase(dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			size, err := db.Size()
+			if err != nil {
+				t.Fatalf("Size failed: %v", err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size < info.Size() {
+				t.Errorf("Size = %d, want at least the %d bytes of %s", size, info.Size(), path)
+			}
+		})
+	}
+
+	for _, dsn := range []string{":memory:", "file::memory:?cache=shared", "file:mem?mode=memory"} {
+		t.Run(dsn, func(t *testing.T) {
+			db, err := OpenDatabase(dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if size, err := db.Size(); err != nil || size != 0 {
+				t.Errorf("Size = %d, %v; want 0 for an in-memory database", size, err)
+			}
+		})
+	}
+}
+
+func TestDatabaseSizeReportsStatErrors(t *testing.T) {
+	// A path below a regular file cannot be stat'ed for reasons other than
+	// not existing.
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db := &Database{path: filepath.Join(file, "slack.db"), dialect: dialectSQLite}
+	if _, err := db.Size(); err == nil {
+		t.Error("Size succeeded for a path below a regular file, want the stat error")
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	store := NewMemoryStore()
+	store.SaveChannel("C1", "general", "")
+	store.SaveChannel("C2", "random", "")
+
+	// C1 finished a fetch; C2 only committed a page of one that failed.
+	if err := store.CompleteFetch("C1"); err != nil {
+		t.Fatal(err)
+	}
+	batch, _ := store.BeginBatch()
+	batch.SetFetchState(FetchState{ChannelID: "C2", Cursor: "next"})
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		query      string
+		wantStatus int
+		wantStale  []string
+	}{
+		{"", http.StatusOK, nil},
+		{"?max_age=1h", http.StatusServiceUnavailable, []string{"C2"}},
+		{"?max_age=nope", http.StatusBadRequest, nil},
+	} {
+		rec := httptest.NewRecorder()
+		healthHandler(store).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz"+tc.query, nil))
+		if rec.Code != tc.wantStatus {
+			t.Errorf("GET /healthz%s = %d, want %d", tc.query, rec.Code, tc.wantStatus)
+		}
+
+		var resp healthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("GET /healthz%s: invalid JSON: %v", tc.query, err)
+		}
+		if len(resp.Stale) != len(tc.wantStale) || len(tc.wantStale) > 0 && resp.Stale[0] != tc.wantStale[0] {
+			t.Errorf("GET /healthz%s stale = %v, want %v", tc.query, resp.Stale, tc.wantStale)
+		}
+		if tc.wantStatus == http.StatusBadRequest {
+			continue
+		}
+		if len(resp.Channels) != 2 || resp.Channels[0].LastSync == "" || resp.Channels[1].LastSync != "" || !resp.Channels[1].InProgress {
+			t.Errorf("GET /healthz%s channels = %+v, want C1 synced and C2 in progress without a sync", tc.query, resp.Channels)
+		}
+	}
+}
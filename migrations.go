@@ -0,0 +1,423 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations are applied in order and recorded in schema_version. Databases
+// created before schema_version existed start at version 0, so every
+// migration must also succeed against a schema it has already been applied
+// to by hand.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create channels, users, messages and replies tables",
+		up:          execMigration(baseSchemaSQL),
+	},
+	{
+		version:     2,
+		description: "add users.tz",
+		up: func(tx *sql.Tx) error {
+			return addColumn(tx, "users", "tz", "TEXT")
+		},
+	},
+	{
+		version:     3,
+		description: "add full-text search index",
+		up:          createSearchIndex,
+	},
+	{
+		version:     4,
+		description: "key messages and replies by (channel_id, ts)",
+		up:          migrateChannelScopedKeys,
+	},
+	{
+		version:     5,
+		description: "add fetch_state for resumable incremental fetches",
+		up: execMigration(`
+			CREATE TABLE IF NOT EXISTS fetch_state (
+				channel_id TEXT PRIMARY KEY,
+				last_ts TEXT NOT NULL DEFAULT '',
+				oldest_ts TEXT NOT NULL DEFAULT '',
+				latest_ts TEXT NOT NULL DEFAULT '',
+				cursor TEXT NOT NULL DEFAULT '',
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			INSERT OR IGNORE INTO fetch_state (channel_id, last_ts)
+			SELECT channel_id, MAX(ts) FROM messages GROUP BY channel_id;
+		`),
+	},
+	{
+		version:     6,
+		description: "add locks for coordinating fetches",
+		up:          execMigration(locksSchemaSQL),
+	},
+	{
+		version:     7,
+		description: "add team_id for multi-workspace archives",
+		up:          addTeamColumns,
+	},
+	{
+		version:     8,
+		description: "add channel_joins for channels joined with -auto-join",
+		up:          execMigration(channelJoinsSchemaSQL),
+	},
+	{
+		version:     9,
+		description: "add fetch_state.completed_at for the last successful fetch",
+		// Channels without a cursor finished their last fetch when their
+		// last page was committed.
+		up: execMigration(`
+			ALTER TABLE fetch_state ADD COLUMN completed_at DATETIME;
+			UPDATE fetch_state SET completed_at = updated_at WHERE cursor = '';
+		`),
+	},
+}
+
+// locksSchemaSQL is shared with PostgreSQL; expires_at is in Unix seconds.
+const locksSchemaSQL = `
+	CREATE TABLE IF NOT EXISTS locks (
+		name TEXT PRIMARY KEY,
+		owner TEXT NOT NULL,
+		expires_at BIGINT NOT NULL
+	);
+`
+
+// channelJoinsSchemaSQL is shared with PostgreSQL; joined_at is in Unix
+// seconds. It records the channels the bot joined by itself, as opposed to
+// ones it was invited to.
+const channelJoinsSchemaSQL = `
+	CREATE TABLE IF NOT EXISTS channel_joins (
+		channel_id TEXT PRIMARY KEY,
+		joined_at BIGINT NOT NULL
+	);
+`
+
+// teamsSchemaSQL is shared with PostgreSQL and must run after the team_id
+// columns exist. user_teams lists every team an Enterprise Grid user
+// belongs to; users.team_id is only their home team.
+const teamsSchemaSQL = `
+	CREATE TABLE IF NOT EXISTS teams (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		domain TEXT NOT NULL DEFAULT '',
+		enterprise_id TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS user_teams (
+		user_id TEXT NOT NULL,
+		team_id TEXT NOT NULL,
+		PRIMARY KEY (user_id, team_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_channels_team_id ON channels(team_id);
+	CREATE INDEX IF NOT EXISTS idx_users_team_id ON users(team_id);
+	CREATE INDEX IF NOT EXISTS idx_messages_team_id ON messages(team_id);
+	CREATE INDEX IF NOT EXISTS idx_replies_team_id ON replies(team_id);
+	CREATE INDEX IF NOT EXISTS idx_user_teams_team_id ON user_teams(team_id);
+`
+
+// teamTables are the tables that record the workspace each row came from.
+var teamTables = []string{"channels", "users", "messages", "replies"}
+
+// addTeamColumns leaves existing rows with an empty team_id; the next fetch
+// of each channel fills it in.
+func addTeamColumns(tx *sql.Tx) error {
+	for _, table := range teamTables {
+		if err := addColumn(tx, table, "team_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(teamsSchemaSQL)
+	return err
+}
+
+const baseSchemaSQL = `
+	CREATE TABLE IF NOT EXISTS channels (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		name TEXT,
+		real_name TEXT,
+		display_name TEXT,
+		email TEXT,
+		profile_image TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS messages (
+		ts TEXT PRIMARY KEY,
+		channel_id TEXT NOT NULL,
+		user_id TEXT,
+		text TEXT,
+		thread_ts TEXT,
+		reply_count INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (channel_id) REFERENCES channels(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS replies (
+		ts TEXT PRIMARY KEY,
+		thread_ts TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		user_id TEXT,
+		text TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (thread_ts) REFERENCES messages(ts),
+		FOREIGN KEY (channel_id) REFERENCES channels(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_messages_channel_id ON messages(channel_id);
+	CREATE INDEX IF NOT EXISTS idx_messages_thread_ts ON messages(thread_ts);
+	CREATE INDEX IF NOT EXISTS idx_replies_thread_ts ON replies(thread_ts);
+	CREATE INDEX IF NOT EXISTS idx_replies_channel_id ON replies(channel_id);
+	CREATE INDEX IF NOT EXISTS idx_users_name ON users(name);
+`
+
+func execMigration(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+const schemaVersionSQL = `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+func (d *Database) SchemaVersion() (int, error) {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&count)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	var version int
+	err = d.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+func (d *Database) PendingMigrations() ([]migration, error) {
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations, each in its own transaction. If
+// the database already holds data it is backed up first.
+func (d *Database) Migrate() ([]migration, error) {
+	pending, err := d.PendingMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	hasData, err := d.hasTables()
+	if err != nil {
+		return nil, err
+	}
+	if hasData {
+		backupPath, err := d.Backup()
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+		if backupPath != "" {
+			log.Printf("Backed up database to %s before migrating", backupPath)
+		}
+	}
+
+	for _, m := range pending {
+		if err := d.applyMigration(m); err != nil {
+			return nil, fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+		if hasData {
+			log.Printf("Applied migration %d: %s", m.version, m.description)
+		}
+	}
+
+	return pending, nil
+}
+
+func (d *Database) applyMigration(m migration) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(schemaVersionSQL); err != nil {
+		return err
+	}
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT INTO schema_version (version, description) VALUES (?, ?)", m.version, m.description); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) hasTable(name string) (bool, error) {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	return count > 0, err
+}
+
+func (d *Database) hasTables() (bool, error) {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('channels', 'messages')").Scan(&count)
+	return count > 0, err
+}
+
+// Backup writes a consistent copy of the database next to the original and
+// returns its path. In-memory databases are not backed up.
+func (d *Database) Backup() (string, error) {
+	path := d.filePath()
+	if path == "" {
+		return "", nil
+	}
+
+	backupPath := fmt.Sprintf("%s.backup-%s", path, time.Now().Format("20060102-150405"))
+	if _, err := os.Stat(backupPath); err == nil {
+		return "", fmt.Errorf("backup file already exists: %s", backupPath)
+	}
+
+	if _, err := d.db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// filePath returns the SQLite file behind the DSN, without a file: prefix
+// or query parameters, or "" for an in-memory database.
+func (d *Database) filePath() string {
+	path, query := d.path, ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	path = strings.TrimPrefix(path, "file:")
+	if path == "" || path == ":memory:" || strings.Contains(query, "mode=memory") {
+		return ""
+	}
+	return path
+}
+
+// migrateChannelScopedKeys rebuilds messages and replies with composite
+// primary keys. Slack ts values are only unique within a channel, so rows
+// from different channels could previously overwrite each other; any damage
+// already done is reported before the tables are rebuilt.
+func migrateChannelScopedKeys(tx *sql.Tx) error {
+	collisions, err := detectCollisions(tx)
+	if err != nil {
+		return err
+	}
+	if len(collisions) > 0 {
+		log.Printf("Warning: found %d replies whose parent message is missing from their channel; run -mode collisions for details", len(collisions))
+	}
+
+	for _, source := range searchSources {
+		if _, err := tx.Exec(fmt.Sprintf(`
+			DROP TRIGGER IF EXISTS %[1]s_fts_ai;
+			DROP TRIGGER IF EXISTS %[1]s_fts_ad;
+			DROP TRIGGER IF EXISTS %[1]s_fts_au;
+			DROP TABLE IF EXISTS %[1]s_fts;`, source.table)); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE messages_v4 (
+			ts TEXT NOT NULL,
+			channel_id TEXT NOT NULL,
+			user_id TEXT,
+			text TEXT,
+			thread_ts TEXT,
+			reply_count INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (channel_id, ts),
+			FOREIGN KEY (channel_id) REFERENCES channels(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+
+		CREATE TABLE replies_v4 (
+			ts TEXT NOT NULL,
+			thread_ts TEXT NOT NULL,
+			channel_id TEXT NOT NULL,
+			user_id TEXT,
+			text TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (channel_id, ts),
+			FOREIGN KEY (channel_id, thread_ts) REFERENCES messages(channel_id, ts),
+			FOREIGN KEY (channel_id) REFERENCES channels(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+
+		INSERT INTO messages_v4 (ts, channel_id, user_id, text, thread_ts, reply_count, created_at)
+		SELECT ts, channel_id, user_id, text, thread_ts, reply_count, created_at FROM messages;
+
+		INSERT INTO replies_v4 (ts, thread_ts, channel_id, user_id, text, created_at)
+		SELECT ts, thread_ts, channel_id, user_id, text, created_at FROM replies;
+
+		DROP TABLE replies;
+		DROP TABLE messages;
+		ALTER TABLE messages_v4 RENAME TO messages;
+		ALTER TABLE replies_v4 RENAME TO replies;
+
+		CREATE INDEX idx_messages_thread_ts ON messages(channel_id, thread_ts);
+		CREATE INDEX idx_replies_thread_ts ON replies(channel_id, thread_ts);
+	`)
+	if err != nil {
+		return err
+	}
+
+	return createSearchIndex(tx)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// createBaselineDatabase creates a database the way versions before
+// schema_version did: the base schema with messages and replies keyed by ts
+// alone, no schema_version table and no search index.
+func createBaselineDatabase(t *testing.T, path string) {
+	t.Helper()
+
+	db, err := OpenDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.db.Exec(baseSchemaSQL); err != nil {
+		t.Fatalf("failed to create baseline schema: %v", err)
+	}
+	_, err = db.db.Exec(`
+		INSERT INTO channels (id, name) VALUES ('C1', 'general'), ('C2', 'random');
+		INSERT INTO users (id, name, real_name, display_name) VALUES ('U1', 'alice', 'Alice', 'alice');
+		INSERT INTO messages (ts, channel_id, user_id, text, thread_ts, reply_count) VALUES
+			('1700000000.000100', 'C1', 'U1', 'deploy finished on staging', '1700000000.000100', 1),
+			('1700000100.000100', 'C2', 'U1', 'lunch menu', '', 0);
+		INSERT INTO replies (ts, thread_ts, channel_id, user_id, text) VALUES
+			('1700000050.000100', '1700000000.000100', 'C1', 'U1', 'rollback plan ready');
+	`)
+	if err != nil {
+		t.Fatalf("failed to insert baseline rows: %v", err)
+	}
+}
+
+// backupGlob matches the backups Migrate writes next to the database, but not
+// the WAL files SQLite creates when a backup is opened.
+const backupGlob = ".backup-????????-??????"
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slack.db")
+	createBaselineDatabase(t, path)
+
+	db, err := OpenDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	applied, err := db.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+
+	latest := migrations[len(migrations)-1].version
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("failed to read schema version: %v", err)
+	}
+	if version != latest {
+		t.Errorf("schema version = %d, want %d", version, latest)
+	}
+
+	backups, err := filepath.Glob(path + backupGlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("found %d backups, want 1: %v", len(backups), backups)
+	}
+
+	// The backup is a copy of the database before any migration.
+	backup, err := OpenDatabase(backups[0])
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer backup.Close()
+	if version, err := backup.SchemaVersion(); err != nil || version != 0 {
+		t.Errorf("backup schema version = %d, %v; want 0", version, err)
+	}
+
+	var messages []MessageWithReplies
+	err = db.EachMessageWithReplies("C1", MessageFilter{}, func(msg MessageWithReplies) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Text != "deploy finished on staging" {
+		t.Fatalf("messages in C1 = %+v, want the baseline message", messages)
+	}
+	if len(messages[0].Replies) != 1 || messages[0].Replies[0].Text != "rollback plan ready" {
+		t.Errorf("replies = %+v, want the baseline reply", messages[0].Replies)
+	}
+	if n, err := db.CountMessages("C2", MessageFilter{}); err != nil || n != 1 {
+		t.Errorf("messages in C2 = %d, %v; want 1", n, err)
+	}
+
+	state, err := db.GetFetchState("C1")
+	if err != nil {
+		t.Fatalf("failed to read fetch state: %v", err)
+	}
+	if state.LastTS != "1700000000.000100" {
+		t.Errorf("fetch state last_ts = %q, want the newest baseline message", state.LastTS)
+	}
+	if state.CompletedAt == "" {
+		t.Error("fetch state completed_at is empty, want it backfilled for the finished baseline fetch")
+	}
+
+	// Rows inserted before the index existed must be searchable.
+	for query, want := range map[string]string{
+		"staging":  "message",
+		"rollback": "reply",
+	} {
+		results, err := db.Search(SearchOptions{Query: query})
+		if err != nil {
+			t.Fatalf("search %q failed: %v", query, err)
+		}
+		if len(results) != 1 || results[0].Kind != want {
+			t.Errorf("search %q = %+v, want one %s", query, results, want)
+		}
+	}
+
+	// With (channel_id, ts) keys the same ts can exist in two channels.
+	if err := db.SaveMessage("1700000000.000100", "C2", "U1", "same ts, other channel", "", 0, ""); err != nil {
+		t.Fatalf("failed to save message: %v", err)
+	}
+	if n, err := db.CountMessages("C1", MessageFilter{}); err != nil || n != 1 {
+		t.Errorf("messages in C1 after saving to C2 = %d, %v; want 1", n, err)
+	}
+
+	// A second run finds nothing to do and takes no further backup.
+	applied, err = db.Migrate()
+	if err != nil {
+		t.Fatalf("second Migrate failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("second Migrate applied %d migrations, want none", len(applied))
+	}
+	if version, err := db.SchemaVersion(); err != nil || version != latest {
+		t.Errorf("schema version after second Migrate = %d, %v; want %d", version, err, latest)
+	}
+	if backups, _ := filepath.Glob(path + backupGlob); len(backups) != 1 {
+		t.Errorf("found %d backups after second Migrate, want 1", len(backups))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	mrkdwnInlineCode = regexp.MustCompile("`[^`\n]+`")
+	mrkdwnEntity     = regexp.MustCompile(`<([^<>\n]+)>`)
+	mrkdwnBold       = regexp.MustCompile(`(^|[^\w*])\*([^*\n]+)\*($|[^\w*])`)
+	mrkdwnItalic     = regexp.MustCompile(`(^|[^\w_])_([^_\n]+)_($|[^\w_])`)
+	mrkdwnStrike     = regexp.MustCompile(`(^|[^\w~])~([^~\n]+)~($|[^\w~])`)
+)
+
+type mrkdwnRenderer struct {
+	users    map[string]User
+	channels map[string]string
+}
+
+func newMrkdwnRenderer(users []User, channels map[string]string) *mrkdwnRenderer {
+	byID := make(map[string]User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+	return &mrkdwnRenderer{users: byID, channels: channels}
+}
+
+// Render converts Slack mrkdwn into HTML. Slack stores <, > and & escaped as
+// entities, so the text is unescaped before being escaped again for HTML.
+func (r *mrkdwnRenderer) Render(text string) template.HTML {
+	var b strings.Builder
+
+	segments := strings.Split(text, "```")
+	for i, segment := range segments {
+		if i%2 == 1 && i < len(segments)-1 {
+			b.WriteString("<pre>")
+			b.WriteString(html.EscapeString(unescapeSlackText(strings.Trim(segment, "\n"))))
+			b.WriteString("</pre>")
+			continue
+		}
+		if i%2 == 1 {
+			segment = "```" + segment
+		}
+		b.WriteString(r.renderLines(segment))
+	}
+
+	return template.HTML(b.String())
+}
+
+func (r *mrkdwnRenderer) renderLines(text string) string {
+	lines := strings.Split(text, "\n")
+	rendered := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.HasPrefix(line, "&gt;") {
+			quoted := strings.TrimPrefix(strings.TrimPrefix(line, "&gt;"), " ")
+			rendered = append(rendered, "<blockquote>"+r.renderInline(quoted)+"</blockquote>")
+			continue
+		}
+		rendered = append(rendered, r.renderInline(line))
+	}
+	return strings.Join(rendered, "<br>\n")
+}
+
+func (r *mrkdwnRenderer) renderInline(text string) string {
+	var b strings.Builder
+
+	last := 0
+	for _, loc := range mrkdwnInlineCode.FindAllStringIndex(text, -1) {
+		b.WriteString(r.renderEntities(text[last:loc[0]]))
+		code := text[loc[0]+1 : loc[1]-1]
+		b.WriteString("<code>" + html.EscapeString(unescapeSlackText(code)) + "</code>")
+		last = loc[1]
+	}
+	b.WriteString(r.renderEntities(text[last:]))
+
+	return b.String()
+}
+
+func (r *mrkdwnRenderer) renderEntities(text string) string {
+	var b strings.Builder
+
+	last := 0
+	for _, loc := range mrkdwnEntity.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(formatMrkdwnEmphasis(html.EscapeString(unescapeSlackText(text[last:loc[0]]))))
+		b.WriteString(r.renderEntity(text[loc[2]:loc[3]]))
+		last = loc[1]
+	}
+	b.WriteString(formatMrkdwnEmphasis(html.EscapeString(unescapeSlackText(text[last:]))))
+
+	return b.String()
+}
+
+func (r *mrkdwnRenderer) renderEntity(entity string) string {
+	target, label, _ := strings.Cut(entity, "|")
+	label = unescapeSlackText(label)
+
+	switch {
+	case strings.HasPrefix(target, "@"):
+		userID := target[1:]
+		name := label
+		if user, ok := r.users[userID]; ok {
+			name = user.Name
+			if user.DisplayName != "" {
+				name = user.DisplayName
+			}
+		}
+		if name == "" {
+			name = userID
+		}
+		return `<span class="mention">@` + html.EscapeString(name) + `</span>`
+	case strings.HasPrefix(target, "#"):
+		channelID := target[1:]
+		name := label
+		if channelName, ok := r.channels[channelID]; ok {
+			name = channelName
+		}
+		if name == "" {
+			name = channelID
+		}
+		return `<span class="mention">#` + html.EscapeString(name) + `</span>`
+	case strings.HasPrefix(target, "!"):
+		special := strings.TrimPrefix(target, "!")
+		if label != "" {
+			return `<span class="mention">` + html.EscapeString(label) + `</span>`
+		}
+		return `<span class="mention">@` + html.EscapeString(special) + `</span>`
+	default:
+		url := unescapeSlackText(target)
+		if label == "" {
+			label = strings.TrimPrefix(url, "mailto:")
+		}
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "mailto:") {
+			return html.EscapeString(label)
+		}
+		return fmt.Sprintf(`<a href="%s" rel="noopener noreferrer">%s</a>`, html.EscapeString(url), html.EscapeString(label))
+	}
+}
+
+func formatMrkdwnEmphasis(text string) string {
+	text = mrkdwnBold.ReplaceAllString(text, "$1<strong>$2</strong>$3")
+	text = mrkdwnItalic.ReplaceAllString(text, "$1<em>$2</em>$3")
+	text = mrkdwnStrike.ReplaceAllString(text, "$1<del>$2</del>$3")
+	return text
+}
+
+func unescapeSlackText(text string) string {
+	text = strings.ReplaceAll(text, "&lt;", "<")
+	text = strings.ReplaceAll(text, "&gt;", ">")
+	return strings.ReplaceAll(text, "&amp;", "&")
+}
+
+// Plain strips mrkdwn entities down to readable text for the search index.
+func (r *mrkdwnRenderer) Plain(text string) string {
+	text = mrkdwnEntity.ReplaceAllStringFunc(text, func(match string) string {
+		target, label, _ := strings.Cut(match[1:len(match)-1], "|")
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if user, ok := r.users[target[1:]]; ok {
+				return "@" + user.Name
+			}
+		case strings.HasPrefix(target, "#"):
+			if name, ok := r.channels[target[1:]]; ok {
+				return "#" + name
+			}
+		}
+		if label != "" {
+			return label
+		}
+		return strings.TrimLeft(target, "@#!")
+	})
+	return unescapeSlackText(text)
+}
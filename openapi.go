@@ -0,0 +1,176 @@
+package main
+
+// openAPISpec describes the API served by serve mode at /api/openapi.json.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "slack-all-contexts archive API",
+    "version": "1.0.0",
+    "description": "Read-only access to the Slack messages archived by slack-all-contexts. Responses carry an ETag and, once channels have been fetched, a Last-Modified header; send If-None-Match or If-Modified-Since to get 304 Not Modified when nothing changed."
+  },
+  "paths": {
+    "/api/channels": {
+      "get": {
+        "summary": "List archived channels",
+        "responses": {
+          "200": {"description": "Channels", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Channel"}}}}},
+          "304": {"description": "Not modified"}
+        }
+      }
+    },
+    "/api/users": {
+      "get": {
+        "summary": "List users",
+        "responses": {
+          "200": {"description": "Users", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/User"}}}}},
+          "304": {"description": "Not modified"}
+        }
+      }
+    },
+    "/api/channels/{channel}/messages": {
+      "get": {
+        "summary": "List a channel's threads, oldest first",
+        "description": "Filters select whole threads, like the export filters of the CLI.",
+        "parameters": [
+          {"$ref": "#/components/parameters/Channel"},
+          {"name": "from", "in": "query", "description": "Threads started at or after this time (YYYY-MM-DD, YYYY-MM-DD HH:MM or RFC 3339)", "schema": {"type": "string"}},
+          {"name": "to", "in": "query", "description": "Threads started before the end of this time", "schema": {"type": "string"}},
+          {"name": "user", "in": "query", "description": "Threads where this user ID or name posted", "schema": {"type": "string"}},
+          {"name": "contains", "in": "query", "description": "Threads containing this text", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Limit"},
+          {"name": "cursor", "in": "query", "description": "next_cursor from the previous page", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "A page of threads", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/MessagePage"}}}},
+          "304": {"description": "Not modified"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/channels/{channel}/threads/{ts}": {
+      "get": {
+        "summary": "Get a thread by its parent message timestamp",
+        "parameters": [
+          {"$ref": "#/components/parameters/Channel"},
+          {"name": "ts", "in": "path", "required": true, "schema": {"type": "string"}, "example": "1704072645.123456"}
+        ],
+        "responses": {
+          "200": {"description": "The thread", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+          "304": {"description": "Not modified"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/search": {
+      "get": {
+        "summary": "Search messages and replies",
+        "parameters": [
+          {"name": "q", "in": "query", "required": true, "description": "Space-separated terms; all must match", "schema": {"type": "string"}},
+          {"name": "channel", "in": "query", "schema": {"type": "string"}},
+          {"name": "team", "in": "query", "description": "Only results from this workspace (team ID)", "schema": {"type": "string"}},
+          {"name": "user", "in": "query", "schema": {"type": "string"}},
+          {"name": "from", "in": "query", "schema": {"type": "string"}},
+          {"name": "to", "in": "query", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Limit"}
+        ],
+        "responses": {
+          "200": {"description": "Results, best matches first", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/SearchResult"}}}}},
+          "304": {"description": "Not modified"},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "Channel": {"name": "channel", "in": "path", "required": true, "schema": {"type": "string"}, "example": "C1234567890"},
+      "Limit": {"name": "limit", "in": "query", "schema": {"type": "integer", "default": 100, "maximum": 1000}}
+    },
+    "responses": {
+      "Error": {"description": "Error", "content": {"application/json": {"schema": {"type": "object", "properties": {"error": {"type": "string"}}}}}}
+    },
+    "schemas": {
+      "Channel": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "name": {"type": "string"},
+          "team_id": {"type": "string"},
+          "message_count": {"type": "integer"},
+          "last_fetched": {"type": "string", "format": "date-time"}
+        }
+      },
+      "User": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "name": {"type": "string"},
+          "real_name": {"type": "string"},
+          "display_name": {"type": "string"},
+          "email": {"type": "string"},
+          "profile_image": {"type": "string"},
+          "tz": {"type": "string"},
+          "team_id": {"type": "string", "description": "Home workspace"},
+          "teams": {"type": "array", "items": {"type": "string"}, "description": "Every workspace of an Enterprise Grid org the user belongs to"}
+        }
+      },
+      "Reply": {
+        "type": "object",
+        "properties": {
+          "ts": {"type": "string"},
+          "time": {"type": "string", "format": "date-time"},
+          "user_id": {"type": "string"},
+          "user_name": {"type": "string"},
+          "user_real_name": {"type": "string"},
+          "user_display_name": {"type": "string"},
+          "text": {"type": "string"}
+        }
+      },
+      "Message": {
+        "type": "object",
+        "properties": {
+          "ts": {"type": "string"},
+          "time": {"type": "string", "format": "date-time"},
+          "channel_id": {"type": "string"},
+          "team_id": {"type": "string"},
+          "thread_ts": {"type": "string"},
+          "user_id": {"type": "string"},
+          "user_name": {"type": "string"},
+          "user_real_name": {"type": "string"},
+          "user_display_name": {"type": "string"},
+          "text": {"type": "string"},
+          "reply_count": {"type": "integer"},
+          "replies": {"type": "array", "items": {"$ref": "#/components/schemas/Reply"}}
+        }
+      },
+      "MessagePage": {
+        "type": "object",
+        "properties": {
+          "channel_id": {"type": "string"},
+          "channel_name": {"type": "string"},
+          "messages": {"type": "array", "items": {"$ref": "#/components/schemas/Message"}},
+          "next_cursor": {"type": "string", "description": "Absent on the last page"}
+        }
+      },
+      "SearchResult": {
+        "type": "object",
+        "properties": {
+          "kind": {"type": "string", "enum": ["message", "reply"]},
+          "channel_id": {"type": "string"},
+          "channel_name": {"type": "string"},
+          "team_id": {"type": "string"},
+          "ts": {"type": "string"},
+          "time": {"type": "string", "format": "date-time"},
+          "thread_ts": {"type": "string"},
+          "user_id": {"type": "string"},
+          "user_name": {"type": "string"},
+          "text": {"type": "string"},
+          "snippet": {"type": "string"},
+          "rank": {"type": "number"}
+        }
+      }
+    }
+  }
+}
+`
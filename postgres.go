@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresSchemaSQL mirrors the current SQLite schema. PostgreSQL databases
+// start at the latest layout, so they do not go through the SQLite
+// migrations; full-text search falls back to ILIKE matching.
+const postgresSchemaSQL = `
+	CREATE TABLE IF NOT EXISTS channels (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		team_id TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		name TEXT,
+		real_name TEXT,
+		display_name TEXT,
+		email TEXT,
+		profile_image TEXT,
+		tz TEXT NOT NULL DEFAULT '',
+		team_id TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS messages (
+		ts TEXT NOT NULL,
+		channel_id TEXT NOT NULL REFERENCES channels(id),
+		user_id TEXT,
+		text TEXT,
+		thread_ts TEXT,
+		reply_count INTEGER DEFAULT 0,
+		team_id TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (channel_id, ts)
+	);
+
+	CREATE TABLE IF NOT EXISTS replies (
+		ts TEXT NOT NULL,
+		thread_ts TEXT NOT NULL,
+		channel_id TEXT NOT NULL REFERENCES channels(id),
+		user_id TEXT,
+		text TEXT,
+		team_id TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (channel_id, ts)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_messages_thread_ts ON messages(channel_id, thread_ts);
+	CREATE INDEX IF NOT EXISTS idx_replies_thread_ts ON replies(channel_id, thread_ts);
+
+	CREATE TABLE IF NOT EXISTS fetch_state (
+		channel_id TEXT PRIMARY KEY,
+		last_ts TEXT NOT NULL DEFAULT '',
+		oldest_ts TEXT NOT NULL DEFAULT '',
+		latest_ts TEXT NOT NULL DEFAULT '',
+		cursor TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP,
+		completed_at TIMESTAMP
+	);
+`
+
+// postgresTeamColumnsSQL adds team_id to databases created before it was
+// part of postgresSchemaSQL.
+const postgresTeamColumnsSQL = `
+	ALTER TABLE channels ADD COLUMN IF NOT EXISTS team_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS team_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS team_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE replies ADD COLUMN IF NOT EXISTS team_id TEXT NOT NULL DEFAULT '';
+`
+
+// postgresCompletedAtSQL adds fetch_state.completed_at to databases created
+// before it was part of postgresSchemaSQL.
+const postgresCompletedAtSQL = `
+	ALTER TABLE fetch_state ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP;
+`
+
+// NewPostgresDatabase connects to the PostgreSQL database at dsn and creates
+// the schema if it does not exist yet.
+func NewPostgresDatabase(dsn string) (*Database, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(postgresSchemaSQL + postgresTeamColumnsSQL + postgresCompletedAtSQL + teamsSchemaSQL + locksSchemaSQL + channelJoinsSchemaSQL); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	return &Database{db: db, path: redactDSN(dsn), dialect: dialectPostgres}, nil
+}
+
+// redactDSN hides the password in dsn so it can be logged.
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "postgres"
+	}
+	return u.Redacted()
+}
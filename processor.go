@@ -2,20 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync/atomic"
 
 	"github.com/slack-go/slack"
 )
 
+// errProcessingStopped is returned by ProcessChannel when Stop was called;
+// the last committed page is recorded in the fetch state.
+var errProcessingStopped = errors.New("processing stopped")
+
 type MessageProcessor struct {
-	slackClient *SlackClient
-	db          *Database
+	slackClient SlackAPI
+	db          Store
 	userCache   map[string]bool
+	stopped     int32
+	autoJoin    bool
+	redactor    *Redactor
+	lease       *Lease
+
+	// team is the workspace of the token, looked up once with auth.test.
+	team *Team
 }
 
-func NewMessageProcessor(slackClient *SlackClient, db *Database) *MessageProcessor {
+func NewMessageProcessor(slackClient SlackAPI, db Store) *MessageProcessor {
 	return &MessageProcessor{
 		slackClient: slackClient,
 		db:          db,
@@ -23,140 +36,321 @@ func NewMessageProcessor(slackClient *SlackClient, db *Database) *MessageProcess
 	}
 }
 
+// SetAutoJoin makes ProcessChannel join public channels the bot is not a
+// member of, instead of failing with not_in_channel.
+func (mp *MessageProcessor) SetAutoJoin(autoJoin bool) {
+	mp.autoJoin = autoJoin
+}
+
+// SetRedactor makes the processor redact message and reply text before it
+// is stored. A nil redactor stores text as fetched.
+func (mp *MessageProcessor) SetRedactor(redactor *Redactor) {
+	mp.redactor = redactor
+}
+
+// SetLease makes the processor check the lease before every write, so it
+// stops writing as soon as the lock is lost or has expired.
+func (mp *MessageProcessor) SetLease(lease *Lease) {
+	mp.lease = lease
+}
+
+// checkLease reports whether the processor may still write.
+func (mp *MessageProcessor) checkLease() error {
+	if mp.lease == nil {
+		return nil
+	}
+	return mp.lease.Check()
+}
+
+// Stop makes a running ProcessChannel return after the page it is working
+// on has been committed. It is safe to call from another goroutine.
+func (mp *MessageProcessor) Stop() {
+	atomic.StoreInt32(&mp.stopped, 1)
+}
+
 func (mp *MessageProcessor) ProcessChannel(ctx context.Context, channelID string) error {
 	channel, err := mp.slackClient.GetChannelInfo(ctx, channelID)
 	if err != nil {
+		metrics.processorErrors.Inc("channel")
 		return fmt.Errorf("failed to get channel info: %w", err)
 	}
 
-	if err := mp.db.SaveChannel(channelID, channel.Name); err != nil {
+	team := mp.workspace(ctx)
+	if err := mp.checkLease(); err != nil {
+		return err
+	}
+	if err := mp.db.SaveChannel(channelID, channel.Name, team.ID); err != nil {
 		return fmt.Errorf("failed to save channel: %w", err)
 	}
+	if team.ID != "" {
+		if err := mp.db.AssignTeam(channelID, team.ID); err != nil {
+			return err
+		}
+	}
 
 	log.Printf("Processing channel: %s (%s)", channel.Name, channelID)
 
-	lastTimestamp, err := mp.db.GetLastMessageTimestamp(channelID)
+	state, err := mp.db.GetFetchState(channelID)
+	if err != nil {
+		return fmt.Errorf("failed to get fetch state: %w", err)
+	}
+
+	err = mp.fetchAllMessages(ctx, channelID, state)
+	if err != nil && mp.autoJoin && isSlackError(err, "not_in_channel") {
+		if err := mp.joinChannel(ctx, channel, err); err != nil {
+			return err
+		}
+		// Pages committed before the bot was removed from the channel are kept.
+		state, err = mp.db.GetFetchState(channelID)
+		if err != nil {
+			return fmt.Errorf("failed to get fetch state: %w", err)
+		}
+		err = mp.fetchAllMessages(ctx, channelID, state)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get last timestamp: %w", err)
+		return err
 	}
 
-	return mp.fetchAllMessages(ctx, channelID, lastTimestamp)
+	if err := mp.checkLease(); err != nil {
+		return err
+	}
+	if err := mp.db.CompleteFetch(channelID); err != nil {
+		return fmt.Errorf("failed to record completed fetch: %w", err)
+	}
+	return nil
 }
 
-func (mp *MessageProcessor) fetchAllMessages(ctx context.Context, channelID, oldest string) error {
-	var cursor string
+// workspace returns the team the token belongs to and records it in the
+// store. If auth.test fails the team is left empty, as it is for data
+// archived before teams were recorded, rather than failing the fetch.
+func (mp *MessageProcessor) workspace(ctx context.Context) Team {
+	if mp.team != nil {
+		return *mp.team
+	}
+
+	mp.team = &Team{}
+	resp, err := mp.slackClient.AuthTest(ctx)
+	if err != nil {
+		log.Printf("Failed to identify the workspace, saving without a team: %v", err)
+		return *mp.team
+	}
+
+	*mp.team = teamFromAuthTest(resp)
+	if err := mp.db.SaveTeam(*mp.team); err != nil {
+		log.Printf("Failed to save team %s: %v", mp.team.ID, err)
+	}
+	return *mp.team
+}
+
+// fetchAllMessages pages through the channel history newer than the
+// watermark. Each page is written in one batch together with the updated
+// fetch state, so an interrupted run resumes from the last committed page.
+func (mp *MessageProcessor) fetchAllMessages(ctx context.Context, channelID string, state FetchState) error {
+	if state.InProgress() {
+		log.Printf("Resuming interrupted fetch for channel %s", channelID)
+	} else {
+		state.OldestTS = state.LastTS
+		state.LatestTS = ""
+	}
+
 	messageCount := 0
 
 	for {
-		resp, err := mp.slackClient.GetConversationHistory(ctx, channelID, cursor, 200)
+		resp, err := mp.slackClient.GetConversationHistory(ctx, channelID, state.Cursor, state.OldestTS, 200)
 		if err != nil {
+			if state.Cursor != "" && isSlackError(err, "invalid_cursor") {
+				log.Printf("Saved cursor for channel %s is no longer valid, restarting from the watermark", channelID)
+				state.Cursor = ""
+				continue
+			}
+			metrics.processorErrors.Inc("history")
 			return fmt.Errorf("failed to get conversation history: %w", err)
 		}
 
-		for _, message := range resp.Messages {
-			if oldest != "" && message.Timestamp <= oldest {
-				continue
+		// Replies and users are fetched before the batch is opened, so the
+		// write transaction is not held across rate-limited Slack calls.
+		page := mp.fetchPage(ctx, channelID, state.OldestTS, resp.Messages)
+		for _, message := range page.messages {
+			if message.Timestamp > state.LatestTS {
+				state.LatestTS = message.Timestamp
 			}
+		}
 
-			if err := mp.saveMessage(ctx, channelID, message); err != nil {
-				log.Printf("Failed to save message %s: %v", message.Timestamp, err)
-				continue
+		if resp.HasMore && resp.ResponseMetaData.NextCursor != "" {
+			state.Cursor = resp.ResponseMetaData.NextCursor
+		} else {
+			state.Cursor = ""
+			if state.LatestTS > state.LastTS {
+				state.LastTS = state.LatestTS
 			}
+			state.OldestTS, state.LatestTS = "", ""
+		}
 
-			messageCount++
-
-			if message.ThreadTimestamp != "" && message.ReplyCount > 0 {
-				if err := mp.fetchThreadReplies(ctx, channelID, message.ThreadTimestamp); err != nil {
-					log.Printf("Failed to fetch replies for thread %s: %v", message.ThreadTimestamp, err)
-				}
-			}
+		if err := mp.savePage(channelID, page, state); err != nil {
+			return err
 		}
 
-		if !resp.HasMore {
+		messageCount += len(page.messages)
+
+		if state.Cursor == "" {
 			break
 		}
-		cursor = resp.ResponseMetaData.NextCursor
+		if atomic.LoadInt32(&mp.stopped) == 1 {
+			log.Printf("Stopped after %d messages for channel %s; the next run resumes from the saved cursor", messageCount, channelID)
+			return errProcessingStopped
+		}
 	}
 
 	log.Printf("Processed %d messages for channel %s", messageCount, channelID)
 	return nil
 }
 
-func (mp *MessageProcessor) fetchThreadReplies(ctx context.Context, channelID, threadTS string) error {
-	var cursor string
-	replyCount := 0
+// fetchedPage is a page of channel history together with the thread
+// replies and the not yet stored users it refers to.
+type fetchedPage struct {
+	messages []slack.Message
+	replies  []threadReply
+	users    []*slack.User
+}
 
-	for {
-		replies, hasMore, nextCursor, err := mp.slackClient.GetConversationReplies(ctx, channelID, threadTS, cursor, 200)
-		if err != nil {
-			return fmt.Errorf("failed to get conversation replies: %w", err)
-		}
+type threadReply struct {
+	threadTS string
+	message  slack.Message
+}
 
-		for i, reply := range replies {
-			if i == 0 {
-				continue
-			}
+// fetchPage fetches the replies and users for the messages newer than
+// oldest. Failures are logged, and the page is then saved without the
+// replies or users concerned.
+func (mp *MessageProcessor) fetchPage(ctx context.Context, channelID, oldest string, messages []slack.Message) *fetchedPage {
+	page := &fetchedPage{}
+	seen := make(map[string]bool)
 
-			if err := mp.saveReply(ctx, channelID, threadTS, reply); err != nil {
-				log.Printf("Failed to save reply %s: %v", reply.Timestamp, err)
-				continue
+	for _, message := range messages {
+		if oldest != "" && message.Timestamp <= oldest {
+			continue
+		}
+		page.messages = append(page.messages, message)
+		mp.fetchUser(ctx, page, seen, message.User)
+
+		if message.ThreadTimestamp != "" && message.ReplyCount > 0 {
+			replies, err := mp.fetchThreadReplies(ctx, channelID, message.ThreadTimestamp)
+			if err != nil {
+				metrics.processorErrors.Inc("replies")
+				log.Printf("Failed to fetch replies for thread %s: %v", message.ThreadTimestamp, err)
+			}
+			for _, reply := range replies {
+				page.replies = append(page.replies, threadReply{threadTS: message.ThreadTimestamp, message: reply})
+				mp.fetchUser(ctx, page, seen, reply.User)
 			}
-			replyCount++
 		}
+	}
+	return page
+}
 
-		if !hasMore {
-			break
-		}
-		cursor = nextCursor
+// savePage writes the page and the fetch state describing it in one batch.
+func (mp *MessageProcessor) savePage(channelID string, page *fetchedPage, state FetchState) error {
+	batch, err := mp.db.BeginBatch()
+	if err != nil {
+		return fmt.Errorf("failed to begin batch: %w", err)
+	}
+	if mp.lease != nil {
+		batch.SetCommitCheck(mp.lease.Check)
 	}
 
-	if replyCount > 0 {
-		log.Printf("Fetched %d replies for thread %s", replyCount, threadTS)
+	if err := mp.writePage(batch, channelID, page); err != nil {
+		batch.Rollback()
+		metrics.processorErrors.Inc("save")
+		return err
+	}
+
+	batch.SetFetchState(state)
+	if err := batch.Commit(); err != nil {
+		metrics.processorErrors.Inc("commit")
+		return fmt.Errorf("failed to commit page: %w", err)
+	}
+
+	for _, user := range page.users {
+		mp.userCache[user.ID] = true
 	}
 	return nil
 }
 
-func (mp *MessageProcessor) saveMessage(ctx context.Context, channelID string, message slack.Message) error {
-	if message.User != "" {
-		if err := mp.ensureUserInfo(ctx, message.User); err != nil {
-			log.Printf("Failed to fetch user info for %s: %v", message.User, err)
+func (mp *MessageProcessor) writePage(batch Batch, channelID string, page *fetchedPage) error {
+	for _, user := range page.users {
+		if err := mp.saveUser(batch, user); err != nil {
+			return fmt.Errorf("failed to save user %s: %w", user.ID, err)
 		}
 	}
 
-	text := message.Text
-	if message.SubType == "bot_message" && len(message.Attachments) > 0 {
-		var attachmentTexts []string
-		for _, attachment := range message.Attachments {
-			if attachment.Text != "" {
-				attachmentTexts = append(attachmentTexts, attachment.Text)
-			}
-		}
-		if len(attachmentTexts) > 0 {
-			text = text + "\n" + strings.Join(attachmentTexts, "\n")
+	for _, message := range page.messages {
+		if err := batch.SaveMessage(
+			message.Timestamp,
+			channelID,
+			message.User,
+			mp.redactor.Redact(messageText(message)),
+			message.ThreadTimestamp,
+			message.ReplyCount,
+			mp.team.ID,
+		); err != nil {
+			return fmt.Errorf("failed to save message %s: %w", message.Timestamp, err)
 		}
+		metrics.messagesSaved.Inc()
 	}
 
-	return mp.db.SaveMessage(
-		message.Timestamp,
-		channelID,
-		message.User,
-		text,
-		message.ThreadTimestamp,
-		message.ReplyCount,
-	)
+	for _, reply := range page.replies {
+		if err := batch.SaveReply(
+			reply.message.Timestamp,
+			reply.threadTS,
+			channelID,
+			reply.message.User,
+			mp.redactor.Redact(messageText(reply.message)),
+			mp.team.ID,
+		); err != nil {
+			return fmt.Errorf("failed to save reply %s: %w", reply.message.Timestamp, err)
+		}
+		metrics.repliesSaved.Inc()
+	}
+	return nil
 }
 
-func (mp *MessageProcessor) saveReply(ctx context.Context, channelID, threadTS string, reply slack.Message) error {
-	if reply.User != "" {
-		if err := mp.ensureUserInfo(ctx, reply.User); err != nil {
-			log.Printf("Failed to fetch user info for %s: %v", reply.User, err)
+// fetchThreadReplies returns the replies in the thread, without its parent
+// message. On error it returns the replies fetched so far.
+func (mp *MessageProcessor) fetchThreadReplies(ctx context.Context, channelID, threadTS string) ([]slack.Message, error) {
+	var cursor string
+	var replies []slack.Message
+
+	for {
+		page, hasMore, nextCursor, err := mp.slackClient.GetConversationReplies(ctx, channelID, threadTS, cursor, 200)
+		if err != nil {
+			return replies, fmt.Errorf("failed to get conversation replies: %w", err)
 		}
+
+		for i, reply := range page {
+			if i == 0 {
+				continue
+			}
+			replies = append(replies, reply)
+		}
+
+		if !hasMore {
+			break
+		}
+		cursor = nextCursor
 	}
 
-	text := reply.Text
-	if reply.SubType == "bot_message" && len(reply.Attachments) > 0 {
+	if len(replies) > 0 {
+		log.Printf("Fetched %d replies for thread %s", len(replies), threadTS)
+	}
+	return replies, nil
+}
+
+// messageText returns the text to store for a message; for bot messages the
+// text of their attachments is appended.
+func messageText(message slack.Message) string {
+	text := message.Text
+	if message.SubType == "bot_message" && len(message.Attachments) > 0 {
 		var attachmentTexts []string
-		for _, attachment := range reply.Attachments {
+		for _, attachment := range message.Attachments {
 			if attachment.Text != "" {
 				attachmentTexts = append(attachmentTexts, attachment.Text)
 			}
@@ -165,26 +359,27 @@ func (mp *MessageProcessor) saveReply(ctx context.Context, channelID, threadTS s
 			text = text + "\n" + strings.Join(attachmentTexts, "\n")
 		}
 	}
-
-	return mp.db.SaveReply(
-		reply.Timestamp,
-		threadTS,
-		channelID,
-		reply.User,
-		text,
-	)
+	return text
 }
 
-func (mp *MessageProcessor) ensureUserInfo(ctx context.Context, userID string) error {
-	if mp.userCache[userID] {
-		return nil
+// fetchUser adds the user to the page unless they are already stored or
+// on the page.
+func (mp *MessageProcessor) fetchUser(ctx context.Context, page *fetchedPage, seen map[string]bool, userID string) {
+	if userID == "" || mp.userCache[userID] || seen[userID] {
+		return
 	}
+	seen[userID] = true
 
 	user, err := mp.slackClient.GetUserInfo(ctx, userID)
 	if err != nil {
-		return err
+		metrics.processorErrors.Inc("users")
+		log.Printf("Failed to fetch user info for %s: %v", userID, err)
+		return
 	}
+	page.users = append(page.users, user)
+}
 
+func (mp *MessageProcessor) saveUser(batch Batch, user *slack.User) error {
 	var email, profileImage string
 	if user.Profile.Email != "" {
 		email = user.Profile.Email
@@ -193,17 +388,52 @@ func (mp *MessageProcessor) ensureUserInfo(ctx context.Context, userID string) e
 		profileImage = user.Profile.Image512
 	}
 
-	if err := mp.db.SaveUser(
+	teamID := user.TeamID
+	if teamID == "" {
+		teamID = mp.team.ID
+	}
+
+	if err := batch.SaveUser(
 		user.ID,
 		user.Name,
 		user.RealName,
 		user.Profile.DisplayName,
 		email,
 		profileImage,
+		user.TZ,
+		teamID,
 	); err != nil {
 		return err
 	}
 
-	mp.userCache[userID] = true
+	if teams := userTeams(user); len(teams) > 0 {
+		if err := batch.SaveUserTeams(user.ID, teams); err != nil {
+			return err
+		}
+	}
+
+	metrics.usersSaved.Inc()
 	return nil
-}
\ No newline at end of file
+}
+
+// userTeams lists the user's home team and, in an Enterprise Grid org, the
+// other teams they belong to.
+func userTeams(user *slack.User) []string {
+	var teams []string
+	seen := make(map[string]bool)
+	for _, teamID := range append([]string{user.TeamID}, user.Enterprise.Teams...) {
+		if teamID != "" && !seen[teamID] {
+			seen[teamID] = true
+			teams = append(teams, teamID)
+		}
+	}
+	return teams
+}
+
+func isSlackError(err error, code string) bool {
+	var slackErr slack.SlackErrorResponse
+	if errors.As(err, &slackErr) {
+		return slackErr.Err == code
+	}
+	return err.Error() == code
+}
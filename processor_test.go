@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"golang.org/x/time/rate"
+
+	"github.com/takutakahashi/slack-all-contexts/fakeslack"
+)
+
+func newFakeSlack(t *testing.T, fixtures fakeslack.Fixtures) (*fakeslack.Server, *SlackClient) {
+	t.Helper()
+
+	server := fakeslack.NewServer(fixtures)
+	t.Cleanup(server.Close)
+
+	client := NewSlackClient("xoxb-test", slack.OptionAPIURL(server.APIURL()))
+	// The fake server needs no pacing; 429 retries still wait for Retry-After.
+	client.limiter = rate.NewLimiter(rate.Inf, 1)
+	return server, client
+}
+
+func processorFixtures() fakeslack.Fixtures {
+	return fakeslack.Fixtures{
+		Team: fakeslack.Team{ID: "T1", Name: "Acme", Domain: "acme"},
+		Channels: []fakeslack.Channel{{
+			ID:       "C1",
+			Name:     "general",
+			IsMember: true,
+			Messages: []fakeslack.Message{
+				{TS: "1704067200.000100", User: "U1", Text: "first"},
+				{TS: "1704067300.000100", User: "U2", Text: "second", Replies: []fakeslack.Message{
+					{TS: "1704067310.000100", User: "U1", Text: "reply one"},
+					{TS: "1704067320.000100", User: "U3", Text: "reply two"},
+				}},
+				{TS: "1704067400.000100", User: "U1", Text: "third"},
+			},
+		}},
+		Users: []fakeslack.User{
+			{ID: "U1", Name: "alice", RealName: "Alice"},
+			{ID: "U2", Name: "bob", RealName: "Bob"},
+			{ID: "U3", Name: "carol", RealName: "Carol"},
+		},
+	}
+}
+
+// storedThreads returns the stored messages of the channel as "ts text"
+// strings, with each reply indented below its thread.
+func storedThreads(t *testing.T, store Store, channelID string) []string {
+	t.Helper()
+
+	var lines []string
+	err := store.EachMessageWithReplies(channelID, MessageFilter{}, func(msg MessageWithReplies) error {
+		lines = append(lines, msg.Timestamp+" "+msg.Text)
+		for _, reply := range msg.Replies {
+			lines = append(lines, "  "+reply.Timestamp+" "+reply.Text)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read messages: %v", err)
+	}
+	return lines
+}
+
+func TestProcessChannelInitialAndIncremental(t *testing.T) {
+	server, client := newFakeSlack(t, processorFixtures())
+	server.MaxPageSize = 2
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	if err := NewMessageProcessor(client, store).ProcessChannel(ctx, "C1"); err != nil {
+		t.Fatalf("ProcessChannel failed: %v", err)
+	}
+
+	want := []string{
+		"1704067200.000100 first",
+		"1704067300.000100 second",
+		"  1704067310.000100 reply one",
+		"  1704067320.000100 reply two",
+		"1704067400.000100 third",
+	}
+	if got := storedThreads(t, store, "C1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("stored messages = %q, want %q", got, want)
+	}
+	if got := server.Calls("conversations.history"); got != 2 {
+		t.Errorf("conversations.history calls = %d, want 2 pages", got)
+	}
+
+	state, err := store.GetFetchState("C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.LastTS != "1704067400.000100" || state.InProgress() || state.CompletedAt == "" {
+		t.Errorf("fetch state = %+v, want the newest message as watermark, no cursor and a completed fetch", state)
+	}
+
+	if name, _ := store.GetChannelName("C1"); name != "general" {
+		t.Errorf("channel name = %q, want general", name)
+	}
+	users, err := store.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 {
+		t.Errorf("stored %d users, want the 3 authors", len(users))
+	}
+	for _, user := range users {
+		if user.TeamID != "T1" {
+			t.Errorf("user %s has team %q, want T1", user.ID, user.TeamID)
+		}
+	}
+	if teams, _ := store.GetChannelTeams(); teams["C1"] != "T1" {
+		t.Errorf("channel teams = %v, want C1 in T1", teams)
+	}
+
+	// An incremental run only asks for messages after the watermark.
+	server.AddMessage("C1", fakeslack.Message{TS: "1704067500.000100", User: "U2", Text: "fourth"})
+	historyCalls, userCalls := server.Calls("conversations.history"), server.Calls("users.info")
+
+	if err := NewMessageProcessor(client, store).ProcessChannel(ctx, "C1"); err != nil {
+		t.Fatalf("incremental ProcessChannel failed: %v", err)
+	}
+	if got := storedThreads(t, store, "C1"); len(got) != len(want)+1 || got[len(got)-1] != "1704067500.000100 fourth" {
+		t.Errorf("stored messages after incremental fetch = %q, want the new message appended", got)
+	}
+	if got := server.Calls("conversations.history") - historyCalls; got != 1 {
+		t.Errorf("incremental conversations.history calls = %d, want 1", got)
+	}
+	if got := server.Calls("conversations.replies"); got != 1 {
+		t.Errorf("conversations.replies calls = %d, want the one thread fetched once", got)
+	}
+	if got := server.Calls("users.info") - userCalls; got != 1 {
+		t.Errorf("incremental users.info calls = %d, want 1 for the new processor", got)
+	}
+	if state, _ := store.GetFetchState("C1"); state.LastTS != "1704067500.000100" {
+		t.Errorf("watermark after incremental fetch = %q, want the new message", state.LastTS)
+	}
+}
+
+func TestProcessChannelResumesFromSavedCursor(t *testing.T) {
+	server, client := newFakeSlack(t, processorFixtures())
+	server.MaxPageSize = 2
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	// Stopping before the run makes it return after the first page.
+	stopped := NewMessageProcessor(client, store)
+	stopped.Stop()
+	if err := stopped.ProcessChannel(ctx, "C1"); !errors.Is(err, errProcessingStopped) {
+		t.Fatalf("ProcessChannel = %v, want errProcessingStopped", err)
+	}
+
+	state, err := store.GetFetchState("C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.InProgress() || state.LastTS != "" || state.LatestTS != "1704067400.000100" {
+		t.Fatalf("fetch state after first page = %+v, want a cursor and the page's newest message", state)
+	}
+	if state.CompletedAt != "" {
+		t.Errorf("fetch state completed_at = %q after a stopped run, want it empty", state.CompletedAt)
+	}
+	if got := storedThreads(t, store, "C1"); len(got) != 4 {
+		t.Errorf("stored after first page = %q, want the newest two messages and their replies", got)
+	}
+
+	if err := NewMessageProcessor(client, store).ProcessChannel(ctx, "C1"); err != nil {
+		t.Fatalf("resumed ProcessChannel failed: %v", err)
+	}
+	if got := server.Calls("conversations.history"); got != 2 {
+		t.Errorf("conversations.history calls = %d, want the resumed run to fetch only the second page", got)
+	}
+	if got := storedThreads(t, store, "C1"); len(got) != 5 {
+		t.Errorf("stored after resuming = %q, want all messages and replies", got)
+	}
+	state, _ = store.GetFetchState("C1")
+	if state.InProgress() || state.LastTS != "1704067400.000100" {
+		t.Errorf("fetch state after resuming = %+v, want the newest message as watermark", state)
+	}
+}
+
+// historyHook runs before each conversations.history call.
+type historyHook struct {
+	SlackAPI
+	calls  int
+	before func(call int)
+}
+
+func (h *historyHook) GetConversationHistory(ctx context.Context, channelID, cursor, oldest string, limit int) (*slack.GetConversationHistoryResponse, error) {
+	h.calls++
+	h.before(h.calls)
+	return h.SlackAPI.GetConversationHistory(ctx, channelID, cursor, oldest, limit)
+}
+
+func TestProcessChannelStopsWritingWhenLeaseExpires(t *testing.T) {
+	server, client := newFakeSlack(t, processorFixtures())
+	server.MaxPageSize = 2
+	store := NewMemoryStore()
+
+	lease, err := AcquireLease(store, writerLockName, lockTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.Release()
+
+	// Before the second page the process stalls past the lease's expiry and
+	// another writer takes the lock.
+	hooked := &historyHook{SlackAPI: client, before: func(call int) {
+		if call != 2 {
+			return
+		}
+		atomic.StoreInt64(&lease.validUntil, time.Now().Add(-time.Second).UnixNano())
+		store.mu.Lock()
+		store.locks[writerLockName] = memoryLock{owner: lease.Owner(), expiresAt: time.Now().Add(-time.Second)}
+		store.mu.Unlock()
+		if ok, err := store.AcquireLock(writerLockName, "other", lockTTL); !ok || err != nil {
+			t.Fatalf("other writer could not take the expired lock: %v, %v", ok, err)
+		}
+	}}
+
+	processor := NewMessageProcessor(hooked, store)
+	processor.SetLease(lease)
+	err = processor.ProcessChannel(context.Background(), "C1")
+	if !errors.Is(err, errLockLost) {
+		t.Fatalf("ProcessChannel = %v, want errLockLost", err)
+	}
+	select {
+	case <-lease.Lost():
+	default:
+		t.Error("lease not marked lost")
+	}
+
+	if got := storedThreads(t, store, "C1"); len(got) != 4 {
+		t.Errorf("stored messages = %q, want only the first page", got)
+	}
+	state, err := store.GetFetchState("C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.InProgress() || state.CompletedAt != "" {
+		t.Errorf("fetch state = %+v, want the first page's cursor and no completed fetch", state)
+	}
+	if owner, _, _ := store.GetLock(writerLockName); owner != "other" {
+		t.Errorf("lock owner = %q, want the other writer to keep it", owner)
+	}
+}
+
+func TestLeaseContextCancelledWhenLost(t *testing.T) {
+	store := NewMemoryStore()
+	lease, err := AcquireLease(store, writerLockName, lockTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.Release()
+
+	ctx, cancel := lease.Context(context.Background())
+	defer cancel()
+	if err := lease.Check(); err != nil {
+		t.Fatalf("Check on a fresh lease = %v", err)
+	}
+
+	atomic.StoreInt64(&lease.validUntil, 0)
+	if err := lease.Check(); !errors.Is(err, errLockLost) {
+		t.Fatalf("Check on an expired lease = %v, want errLockLost", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("lease context not cancelled after the lease expired")
+	}
+}
+
+func TestProcessChannelRetriesRateLimitedRequests(t *testing.T) {
+	server, client := newFakeSlack(t, processorFixtures())
+	server.RateLimit("conversations.history", 1, 1)
+	store := NewMemoryStore()
+
+	start := time.Now()
+	if err := NewMessageProcessor(client, store).ProcessChannel(context.Background(), "C1"); err != nil {
+		t.Fatalf("ProcessChannel failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("ProcessChannel took %s, want it to wait for Retry-After", elapsed)
+	}
+	if got := server.Calls("conversations.history"); got != 2 {
+		t.Errorf("conversations.history calls = %d, want the rate limited call and its retry", got)
+	}
+	if got := storedThreads(t, store, "C1"); len(got) != 5 {
+		t.Errorf("stored messages = %q, want all of them", got)
+	}
+}
+
+func TestProcessChannelGivesUpAfterMaxRetries(t *testing.T) {
+	server, client := newFakeSlack(t, processorFixtures())
+	server.RateLimit("conversations.history", maxRateLimitRetries+1, 0)
+
+	store := NewMemoryStore()
+	err := NewMessageProcessor(client, store).ProcessChannel(context.Background(), "C1")
+	var rateLimited *slack.RateLimitedError
+	if !errors.As(err, &rateLimited) {
+		t.Errorf("ProcessChannel = %v, want a rate limit error", err)
+	}
+	if got := server.Calls("conversations.history"); got != maxRateLimitRetries+1 {
+		t.Errorf("conversations.history calls = %d, want the first call and %d retries", got, maxRateLimitRetries)
+	}
+	if state, _ := store.GetFetchState("C1"); state.CompletedAt != "" {
+		t.Errorf("fetch state completed_at = %q after a failed run, want it empty", state.CompletedAt)
+	}
+}
+
+func TestProcessChannelNotInChannel(t *testing.T) {
+	fixtures := processorFixtures()
+	fixtures.Channels[0].IsMember = false
+	fixtures.Channels = append(fixtures.Channels, fakeslack.Channel{ID: "G1", Name: "secret", IsPrivate: true})
+	ctx := context.Background()
+
+	t.Run("without auto-join", func(t *testing.T) {
+		server, client := newFakeSlack(t, fixtures)
+		store := NewMemoryStore()
+
+		err := NewMessageProcessor(client, store).ProcessChannel(ctx, "C1")
+		if err == nil || !isSlackError(err, "not_in_channel") {
+			t.Fatalf("ProcessChannel = %v, want not_in_channel", err)
+		}
+		if got := server.Calls("conversations.history"); got != 1 {
+			t.Errorf("conversations.history calls = %d, want 1", got)
+		}
+		if got := server.Calls("conversations.join"); got != 0 {
+			t.Errorf("conversations.join calls = %d, want none", got)
+		}
+		if got := storedThreads(t, store, "C1"); len(got) != 0 {
+			t.Errorf("stored messages = %q, want none", got)
+		}
+	})
+
+	t.Run("with auto-join", func(t *testing.T) {
+		server, client := newFakeSlack(t, fixtures)
+		store := NewMemoryStore()
+		processor := NewMessageProcessor(client, store)
+		processor.SetAutoJoin(true)
+
+		if err := processor.ProcessChannel(ctx, "C1"); err != nil {
+			t.Fatalf("ProcessChannel failed: %v", err)
+		}
+		if got := server.Calls("conversations.join"); got != 1 {
+			t.Errorf("conversations.join calls = %d, want 1", got)
+		}
+		if got := storedThreads(t, store, "C1"); len(got) != 5 {
+			t.Errorf("stored messages = %q, want all of them after joining", got)
+		}
+		joins, err := store.GetChannelJoins()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := joins["C1"]; !ok {
+			t.Errorf("channel joins = %v, want C1 recorded", joins)
+		}
+
+		// Private channels cannot be joined; the not_in_channel error is kept.
+		err = processor.ProcessChannel(ctx, "G1")
+		if err == nil || !isSlackError(err, "not_in_channel") {
+			t.Errorf("ProcessChannel(G1) = %v, want it to fail with not_in_channel", err)
+		}
+		if got := server.Calls("conversations.join"); got != 1 {
+			t.Errorf("conversations.join calls = %d, want no attempt for the private channel", got)
+		}
+	})
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultQuestionPhrases are the phrases that mark a message as a question
+// when it starts with one, in addition to messages ending in ? or ？.
+const defaultQuestionPhrases = "how,what,why,where,when,who,which,is there,are there,does anyone,do we,can i,can someone,could someone,any idea,anyone know,教えて,誰か,どなたか,どうすれば,どうやって,なぜ,質問"
+
+type QuestionOptions struct {
+	ChannelID string
+	Filter    MessageFilter
+	Phrases   []string
+	Within    time.Duration
+	// WorkspaceURL overrides the workspace used in permalinks, which is
+	// otherwise the recorded domain of each channel's team.
+	WorkspaceURL string
+	// Now is when the report is made; questions asked less than Within
+	// before it are not reported yet. Zero means time.Now().
+	Now time.Time
+}
+
+// UnansweredQuestion is a top-level question that got no reply from anyone
+// other than its author, or only got one after the allowed time.
+type UnansweredQuestion struct {
+	ChannelID       string
+	ChannelName     string
+	Timestamp       string
+	UserID          string
+	UserName        string
+	UserRealName    string
+	UserDisplayName string
+	UserTZ          string
+	Text            string
+	ReplyCount      int
+	// FirstReplyAfter is how long the first reply from someone else took;
+	// zero when there was none.
+	FirstReplyAfter time.Duration
+	// Permalink is empty when the workspace's domain is unknown.
+	Permalink string
+}
+
+var leadingMentionPattern = regexp.MustCompile(`^(\s*<[@!#][^>]*>[\s,:]*)+`)
+
+// parseQuestionPhrases splits a comma-separated phrase list.
+func parseQuestionPhrases(value string) []string {
+	var phrases []string
+	for _, phrase := range strings.Split(value, ",") {
+		if phrase = strings.ToLower(strings.TrimSpace(phrase)); phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+	return phrases
+}
+
+// isQuestion reports whether text ends with a question mark or, ignoring
+// leading mentions, starts with one of phrases.
+func isQuestion(text string, phrases []string) bool {
+	text = strings.TrimSpace(text)
+	if strings.HasSuffix(text, "?") || strings.HasSuffix(text, "？") {
+		return true
+	}
+
+	lower := strings.ToLower(leadingMentionPattern.ReplaceAllString(text, ""))
+	for _, phrase := range phrases {
+		if !strings.HasPrefix(lower, phrase) {
+			continue
+		}
+		// Latin phrases must end on a word boundary so "who" does not
+		// match "whole".
+		rest := strings.TrimPrefix(lower, phrase)
+		if rest == "" || !isWordByte(rest[0]) || !isWordByte(phrase[len(phrase)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z'
+}
+
+// FindUnansweredQuestions lists questions in the selected channels that got
+// no reply from someone other than the author within opts.Within. Questions
+// whose Within has not passed yet are left out. A zero Within only reports
+// questions without any such reply.
+func FindUnansweredQuestions(store Store, opts QuestionOptions) ([]UnansweredQuestion, error) {
+	channels, err := store.GetChannels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channels: %w", err)
+	}
+	if opts.ChannelID != "" {
+		name, ok := channels[opts.ChannelID]
+		if !ok {
+			return nil, fmt.Errorf("channel %s not found in database", opts.ChannelID)
+		}
+		channels = map[string]string{opts.ChannelID: name}
+	}
+
+	workspaceURLs, err := channelWorkspaceURLs(store, opts.WorkspaceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	var questions []UnansweredQuestion
+	for channelID := range channels {
+		err := store.EachMessageWithReplies(channelID, opts.Filter, func(msg MessageWithReplies) error {
+			if !isQuestion(msg.Text, opts.Phrases) {
+				return nil
+			}
+
+			delay, answered := firstReplyDelay(msg)
+			if answered && (opts.Within == 0 || delay <= opts.Within) {
+				return nil
+			}
+			if !answered {
+				asked, err := parseSlackTimestamp(msg.Timestamp)
+				if err == nil && opts.Within > 0 && asked.Add(opts.Within).After(now) {
+					return nil
+				}
+				delay = 0
+			}
+
+			questions = append(questions, UnansweredQuestion{
+				ChannelID:       channelID,
+				ChannelName:     msg.ChannelName,
+				Timestamp:       msg.Timestamp,
+				UserID:          msg.UserID,
+				UserName:        msg.UserName,
+				UserRealName:    msg.UserRealName,
+				UserDisplayName: msg.UserDisplayName,
+				UserTZ:          msg.UserTZ,
+				Text:            msg.Text,
+				ReplyCount:      msg.ReplyCount,
+				FirstReplyAfter: delay,
+				Permalink:       permalink(workspaceURLs[channelID], channelID, msg.Timestamp),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read channel %s: %w", channelID, err)
+		}
+	}
+
+	sort.Slice(questions, func(i, j int) bool {
+		if questions[i].Timestamp != questions[j].Timestamp {
+			return questions[i].Timestamp < questions[j].Timestamp
+		}
+		return questions[i].ChannelID < questions[j].ChannelID
+	})
+	return questions, nil
+}
+
+// channelWorkspaceURLs maps channel IDs to the URL of their workspace,
+// e.g. https://example.slack.com, built from the team's recorded domain.
+// Channels whose domain is unknown are left out unless override is set, in
+// which case it is used for every channel.
+func channelWorkspaceURLs(store Store, override string) (map[string]string, error) {
+	channelTeams, err := store.GetChannelTeams()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel teams: %w", err)
+	}
+
+	urls := make(map[string]string, len(channelTeams))
+	if override = strings.TrimRight(override, "/"); override != "" {
+		for channelID := range channelTeams {
+			urls[channelID] = override
+		}
+		return urls, nil
+	}
+
+	teams, err := store.GetTeams()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get teams: %w", err)
+	}
+	domains := make(map[string]string, len(teams))
+	for _, team := range teams {
+		domains[team.ID] = team.Domain
+	}
+	for channelID, teamID := range channelTeams {
+		if domain := domains[teamID]; domain != "" {
+			urls[channelID] = "https://" + domain + ".slack.com"
+		}
+	}
+	return urls, nil
+}
+
+// permalink builds a Slack message link, e.g.
+// https://example.slack.com/archives/C123/p1700000000000100, or returns ""
+// when the workspace URL is unknown.
+func permalink(workspaceURL, channelID, ts string) string {
+	if workspaceURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/archives/%s/p%s", workspaceURL, channelID, strings.ReplaceAll(ts, ".", ""))
+}
+
+func (q UnansweredQuestion) status() string {
+	if q.FirstReplyAfter == 0 {
+		return "no reply"
+	}
+	return "first reply after " + q.FirstReplyAfter.Round(time.Minute).String()
+}
+
+func (e *Exporter) WriteUnansweredQuestions(w io.Writer, questions []UnansweredQuestion) error {
+	if len(questions) == 0 {
+		fmt.Fprintln(w, "No unanswered questions found.")
+		return nil
+	}
+
+	fmt.Fprintf(w, "Found %d unanswered questions:\n\n", len(questions))
+	for i, q := range questions {
+		user := e.formatUserDisplay(q.UserID, q.UserName, q.UserRealName, q.UserDisplayName)
+		fmt.Fprintf(w, "%d. #%s | %s | %s | %s\n", i+1, q.ChannelName, e.formatTimestamp(q.Timestamp, q.UserTZ), user, q.status())
+		fmt.Fprintf(w, "   %s\n", indentText(e.options.Redactor.Redact(q.Text), "   "))
+		if q.Permalink != "" {
+			fmt.Fprintf(w, "   %s\n", q.Permalink)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+type questionRecord struct {
+	ChannelID              string   `json:"channel_id"`
+	ChannelName            string   `json:"channel_name"`
+	TS                     string   `json:"ts"`
+	Time                   string   `json:"time"`
+	UserID                 string   `json:"user_id"`
+	UserName               string   `json:"user_name,omitempty"`
+	Text                   string   `json:"text"`
+	ReplyCount             int      `json:"reply_count"`
+	FirstReplyAfterSeconds *float64 `json:"first_reply_after_seconds"`
+	Permalink              string   `json:"permalink,omitempty"`
+}
+
+func (e *Exporter) questionRecord(q UnansweredQuestion) questionRecord {
+	record := questionRecord{
+		ChannelID:   q.ChannelID,
+		ChannelName: q.ChannelName,
+		TS:          q.Timestamp,
+		Time:        e.formatISOTimestamp(q.Timestamp),
+		UserID:      q.UserID,
+		UserName:    q.UserName,
+		Text:        e.options.Redactor.Redact(q.Text),
+		ReplyCount:  q.ReplyCount,
+		Permalink:   q.Permalink,
+	}
+	if q.FirstReplyAfter > 0 {
+		seconds := q.FirstReplyAfter.Seconds()
+		record.FirstReplyAfterSeconds = &seconds
+	}
+	return record
+}
+
+func (e *Exporter) WriteUnansweredQuestionsJSON(w io.Writer, questions []UnansweredQuestion) error {
+	records := make([]questionRecord, 0, len(questions))
+	for _, q := range questions {
+		records = append(records, e.questionRecord(q))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+func (e *Exporter) WriteUnansweredQuestionsCSV(w io.Writer, questions []UnansweredQuestion) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"channel_id", "channel_name", "ts", "time", "user_id", "user_name", "reply_count", "first_reply_after_seconds", "permalink", "text"})
+	for _, q := range questions {
+		r := e.questionRecord(q)
+		cw.Write([]string{
+			r.ChannelID, r.ChannelName, r.TS, r.Time, r.UserID, r.UserName,
+			strconv.Itoa(r.ReplyCount), csvSeconds(r.FirstReplyAfterSeconds), r.Permalink, r.Text,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
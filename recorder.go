@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+	"golang.org/x/time/rate"
+)
+
+const scrubbedToken = "xoxx-REDACTED"
+
+var slackTokenPattern = regexp.MustCompile(`xox[a-z]-[A-Za-z0-9-]+`)
+
+// recordedHeaders are the response headers kept in a recording; the rest are
+// dropped to keep recordings small and free of cookies.
+var recordedHeaders = []string{"Content-Type", "Retry-After", "X-OAuth-Scopes", "X-Accepted-OAuth-Scopes"}
+
+// interaction is one recorded Slack API request and its response, stored as
+// NNNN-<api method>.json in the recording directory.
+type interaction struct {
+	Method   string            `json:"method"`
+	Form     url.Values        `json:"form"`
+	Status   int               `json:"status"`
+	Header   map[string]string `json:"header,omitempty"`
+	Body     json.RawMessage   `json:"body,omitempty"`
+	BodyText string            `json:"body_text,omitempty"`
+}
+
+// newSlackClient creates the client for a fetch run. With recordDir set every
+// request and response is written there; with replayDir set responses are
+// served from an earlier recording instead of Slack.
+func newSlackClient(token, recordDir, replayDir string, limits RateLimits) (*SlackClient, error) {
+	var transport http.RoundTripper = http.DefaultTransport
+	switch {
+	case recordDir != "" && replayDir != "":
+		return nil, fmt.Errorf("-record and -replay cannot be used together")
+	case replayDir != "":
+		replay, err := newReplayTransport(replayDir)
+		if err != nil {
+			return nil, err
+		}
+		transport = replay
+	case recordDir != "":
+		if err := os.MkdirAll(recordDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create record directory: %w", err)
+		}
+		transport = &recordingTransport{dir: recordDir, next: http.DefaultTransport}
+	}
+
+	scopes := &scopeCapture{next: transport}
+	client := NewSlackClient(token, slack.OptionHTTPClient(&http.Client{Transport: scopes}))
+	client.scopes = scopes
+	if replayDir != "" {
+		client.SetRateLimits(RateLimits{MaxRetries: limits.MaxRetries})
+		// Recorded responses don't need to be paced.
+		client.limiter.SetLimit(rate.Inf)
+	} else {
+		client.SetRateLimits(limits)
+	}
+	return client, nil
+}
+
+// apiMethod returns the Slack API method name, e.g. conversations.history,
+// from a request URL.
+func apiMethod(u *url.URL) string {
+	return path.Base(u.Path)
+}
+
+// requestForm returns the query and form parameters of req with the token
+// removed, leaving the request body readable again.
+func requestForm(req *http.Request) (url.Values, error) {
+	form := req.URL.Query()
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			values, err := url.ParseQuery(string(body))
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range values {
+				form[k] = append(form[k], v...)
+			}
+		}
+	}
+	form.Del("token")
+	return form, nil
+}
+
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+
+	mu  sync.Mutex
+	seq int
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	form, err := requestForm(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request: %w", err)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec := interaction{
+		Method: apiMethod(req.URL),
+		Form:   form,
+		Status: resp.StatusCode,
+		Header: make(map[string]string),
+	}
+	for _, name := range recordedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			rec.Header[name] = value
+		}
+	}
+	scrubbed := slackTokenPattern.ReplaceAll(body, []byte(scrubbedToken))
+	if json.Valid(scrubbed) {
+		rec.Body = scrubbed
+	} else {
+		rec.BodyText = string(scrubbed)
+	}
+
+	if err := t.write(rec); err != nil {
+		return nil, fmt.Errorf("failed to record %s: %w", rec.Method, err)
+	}
+	return resp, nil
+}
+
+func (t *recordingTransport) write(rec interaction) error {
+	t.mu.Lock()
+	t.seq++
+	name := fmt.Sprintf("%04d-%s.json", t.seq, rec.Method)
+	t.mu.Unlock()
+
+	data, err := marshalIndentJSON(rec, "")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(t.dir, name), append(data, '\n'), 0644)
+}
+
+// replayTransport answers requests from a recording. Requests are matched on
+// API method and parameters, and repeated requests get the recorded
+// responses in their original order.
+type replayTransport struct {
+	mu        sync.Mutex
+	responses map[string][]interaction
+}
+
+func newReplayTransport(dir string) (*replayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recorded requests found in %s", dir)
+	}
+	sort.Strings(files)
+
+	t := &replayTransport{responses: make(map[string][]interaction)}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recording: %w", err)
+		}
+		var rec interaction
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("failed to parse recording %s: %w", file, err)
+		}
+		key := replayKey(rec.Method, rec.Form)
+		t.responses[key] = append(t.responses[key], rec)
+	}
+	return t, nil
+}
+
+func replayKey(method string, form url.Values) string {
+	return method + "?" + form.Encode()
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	form, err := requestForm(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request: %w", err)
+	}
+
+	key := replayKey(apiMethod(req.URL), form)
+	t.mu.Lock()
+	recs := t.responses[key]
+	if len(recs) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("no recorded response for %s", key)
+	}
+	rec := recs[0]
+	t.responses[key] = recs[1:]
+	t.mu.Unlock()
+
+	body := []byte(rec.Body)
+	if rec.BodyText != "" {
+		body = []byte(rec.BodyText)
+	}
+
+	header := make(http.Header)
+	for name, value := range rec.Header {
+		header.Set(name, value)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+		StatusCode:    rec.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redaction modes for -redact. At ingest, text is redacted before it is
+// stored, so the secrets never reach the database; at export the database
+// keeps the original text and only the output is redacted.
+const (
+	redactIngest = "ingest"
+	redactExport = "export"
+)
+
+// builtinRedactionRules detect common secrets and personal data. They are
+// applied in this order, before any custom rules.
+var builtinRedactionRules = []struct {
+	name    string
+	pattern string
+}{
+	{"private-key", `(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`},
+	{"slack-token", `\b(?:xox[abposre](?:\.xox[bp])?|xapp)-[0-9A-Za-z-]{10,}`},
+	{"aws-access-key", `\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[0-9A-Z]{16}\b`},
+	{"aws-secret-key", `(?i)\baws_?secret_?access_?key["']?\s*[:=]\s*["']?[0-9A-Za-z/+]{40}\b`},
+	{"email", `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`},
+	// Numbers must start with + or an area code in parentheses, so dates,
+	// version strings, ranges and IPs are left alone.
+	{"phone", `\+\d{1,3}(?:[\s-]?\(?\d{1,4}\)?){1,3}[\s-]?\d{3,4}\b|\(\d{2,4}\)\s?\d{2,4}-\d{3,4}\b`},
+}
+
+// RedactionRuleConfig is a custom rule in the -redact-rules file:
+//
+//	rules:
+//	  - name: employee-id
+//	    pattern: 'EMP-\d{6}'
+//	    replacement: '[EMPLOYEE]'
+type RedactionRuleConfig struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	// Replacement defaults to [REDACTED:<name>].
+	Replacement string `yaml:"replacement"`
+}
+
+type redactionRule struct {
+	name        string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// Redactor replaces matches of its rules in message text and counts them
+// per rule. A nil Redactor leaves text unchanged.
+type Redactor struct {
+	rules []redactionRule
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewRedactor builds a redactor from the named built-in rules ("all" for
+// every one) followed by the custom rules.
+func NewRedactor(builtins []string, custom []RedactionRuleConfig) (*Redactor, error) {
+	r := &Redactor{counts: make(map[string]int)}
+
+	enabled := make(map[string]bool)
+	for _, name := range builtins {
+		if name == "all" {
+			for _, rule := range builtinRedactionRules {
+				enabled[rule.name] = true
+			}
+			continue
+		}
+		if !isBuiltinRedactionRule(name) {
+			return nil, fmt.Errorf("unknown built-in redaction rule %q (available: %s)", name, strings.Join(builtinRedactionRuleNames(), ", "))
+		}
+		enabled[name] = true
+	}
+	for _, rule := range builtinRedactionRules {
+		if enabled[rule.name] {
+			r.add(rule.name, regexp.MustCompile(rule.pattern), "")
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, rule := range r.rules {
+		seen[rule.name] = true
+	}
+	for i, rule := range custom {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("redaction rule %d has no name", i+1)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("duplicate redaction rule %q", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for redaction rule %q: %w", rule.Name, err)
+		}
+		r.add(rule.Name, pattern, rule.Replacement)
+	}
+
+	if len(r.rules) == 0 {
+		return nil, fmt.Errorf("no redaction rules enabled")
+	}
+	return r, nil
+}
+
+func (r *Redactor) add(name string, pattern *regexp.Regexp, replacement string) {
+	if replacement == "" {
+		replacement = "[REDACTED:" + name + "]"
+	}
+	r.rules = append(r.rules, redactionRule{name: name, pattern: pattern, replacement: replacement})
+	r.counts[name] = 0
+}
+
+// Redact returns text with every rule applied in order. The replacement is
+// literal; $ is not expanded.
+func (r *Redactor) Redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+
+	for _, rule := range r.rules {
+		n := 0
+		text = rule.pattern.ReplaceAllStringFunc(text, func(string) string {
+			n++
+			return rule.replacement
+		})
+		if n > 0 {
+			r.mu.Lock()
+			r.counts[rule.name] += n
+			r.mu.Unlock()
+			metrics.redactions.Add(float64(n), rule.name)
+		}
+	}
+	return text
+}
+
+// Counts returns how many items each rule has redacted so far.
+func (r *Redactor) Counts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[string]int, len(r.counts))
+	for name, n := range r.counts {
+		counts[name] = n
+	}
+	return counts
+}
+
+// WriteReport lists the rules in order with the number of items each one
+// redacted.
+func (r *Redactor) WriteReport(w io.Writer) {
+	counts := r.Counts()
+	total := 0
+	fmt.Fprintf(w, "%-24s %8s\n", "Rule", "Redacted")
+	for _, rule := range r.rules {
+		fmt.Fprintf(w, "%-24s %8d\n", rule.name, counts[rule.name])
+		total += counts[rule.name]
+	}
+	fmt.Fprintf(w, "%-24s %8d\n", "total", total)
+}
+
+// LogReport logs the redaction report, if r is not nil.
+func (r *Redactor) LogReport() {
+	if r == nil {
+		return
+	}
+	var b strings.Builder
+	r.WriteReport(&b)
+	log.Printf("Redaction report:\n%s", b.String())
+}
+
+func isBuiltinRedactionRule(name string) bool {
+	for _, rule := range builtinRedactionRules {
+		if rule.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func builtinRedactionRuleNames() []string {
+	names := make([]string, 0, len(builtinRedactionRules))
+	for _, rule := range builtinRedactionRules {
+		names = append(names, rule.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadRedactionRules reads custom rules from a YAML file.
+func LoadRedactionRules(path string) ([]RedactionRuleConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redaction rules: %w", err)
+	}
+
+	var file struct {
+		Rules []RedactionRuleConfig `yaml:"rules"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse redaction rules %s: %w", path, err)
+	}
+	return file.Rules, nil
+}
+
+// newRedactorFromFlags builds the redactor for -redact, -redact-builtins and
+// -redact-rules. It returns nil when redaction is off.
+func newRedactorFromFlags(mode, builtins, rulesPath string) (*Redactor, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case redactIngest, redactExport:
+	default:
+		return nil, fmt.Errorf("invalid -redact %q: use %s or %s", mode, redactIngest, redactExport)
+	}
+
+	var names []string
+	if builtins != "none" {
+		names = strings.FieldsFunc(builtins, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+
+	var custom []RedactionRuleConfig
+	if rulesPath != "" {
+		rules, err := LoadRedactionRules(rulesPath)
+		if err != nil {
+			return nil, err
+		}
+		custom = rules
+	}
+	return NewRedactor(names, custom)
+}
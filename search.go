@@ -0,0 +1,396 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The search index uses the trigram tokenizer so that Japanese and other CJK
+// text, which has no word boundaries, can be searched. Trigram matching needs
+// at least three characters per term; shorter terms fall back to LIKE.
+const searchIndexSQL = `
+	CREATE VIRTUAL TABLE %[1]s_fts USING fts5(
+		text,
+		content='%[1]s',
+		content_rowid='rowid',
+		tokenize='trigram'
+	);
+
+	CREATE TRIGGER %[1]s_fts_ai AFTER INSERT ON %[1]s BEGIN
+		INSERT INTO %[1]s_fts(rowid, text) VALUES (new.rowid, new.text);
+	END;
+
+	CREATE TRIGGER %[1]s_fts_ad AFTER DELETE ON %[1]s BEGIN
+		INSERT INTO %[1]s_fts(%[1]s_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+	END;
+
+	CREATE TRIGGER %[1]s_fts_au AFTER UPDATE ON %[1]s BEGIN
+		INSERT INTO %[1]s_fts(%[1]s_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+		INSERT INTO %[1]s_fts(rowid, text) VALUES (new.rowid, new.text);
+	END;
+
+	INSERT INTO %[1]s_fts(%[1]s_fts) VALUES ('rebuild');
+`
+
+const minSearchTermLength = 3
+
+type SearchOptions struct {
+	Query     string
+	ChannelID string
+	TeamID    string
+	User      string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+type SearchResult struct {
+	Kind            string
+	ChannelID       string
+	ChannelName     string
+	TeamID          string
+	Timestamp       string
+	ThreadTS        string
+	UserID          string
+	UserName        string
+	UserRealName    string
+	UserDisplayName string
+	UserTZ          string
+	Text            string
+	Snippet         string
+	Rank            float64
+}
+
+type searchSource struct {
+	kind     string
+	table    string
+	threadTS string
+}
+
+var searchSources = []searchSource{
+	{kind: "message", table: "messages", threadTS: "x.ts"},
+	{kind: "reply", table: "replies", threadTS: "x.thread_ts"},
+}
+
+func createSearchIndex(tx *sql.Tx) error {
+	for _, source := range searchSources {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", source.table+"_fts").Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf(searchIndexSQL, source.table)); err != nil {
+			return fmt.Errorf("failed to create search index for %s: %w", source.table, err)
+		}
+	}
+	return nil
+}
+
+// Search returns messages and replies matching every term in the query,
+// best matches first.
+func (d *Database) Search(opts SearchOptions) ([]SearchResult, error) {
+	terms := strings.Fields(opts.Query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("search query is empty")
+	}
+
+	var ftsTerms, likeTerms []string
+	for _, term := range terms {
+		if d.dialect == dialectSQLite && utf8.RuneCountInString(term) >= minSearchTermLength {
+			ftsTerms = append(ftsTerms, term)
+		} else {
+			likeTerms = append(likeTerms, term)
+		}
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	var parts []string
+	var args []interface{}
+	for _, source := range searchSources {
+		part, partArgs := source.query(opts, ftsTerms, likeTerms, d.dialect)
+		parts = append(parts, part)
+		args = append(args, partArgs...)
+	}
+
+	query := strings.Join(parts, "\nUNION ALL\n") + "\nORDER BY rank ASC, ts DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.db.Query(d.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		err := rows.Scan(&r.Kind, &r.ChannelID, &r.ChannelName, &r.TeamID, &r.Timestamp, &r.ThreadTS,
+			&r.UserID, &r.UserName, &r.UserRealName, &r.UserDisplayName, &r.UserTZ,
+			&r.Text, &r.Snippet, &r.Rank)
+		if err != nil {
+			return nil, err
+		}
+		if r.Snippet == "" {
+			r.Snippet = highlightSnippet(r.Text, terms)
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+func (s searchSource) query(opts SearchOptions, ftsTerms, likeTerms []string, dialect string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	from := s.table + " x"
+	snippet := "''"
+	rank := "0.0"
+	if len(ftsTerms) > 0 {
+		fts := s.table + "_fts"
+		from = fmt.Sprintf("%s JOIN %s x ON x.rowid = %s.rowid", fts, s.table, fts)
+		snippet = fmt.Sprintf("snippet(%s, 0, '**', '**', '…', 32)", fts)
+		rank = fmt.Sprintf("bm25(%s)", fts)
+		conds = append(conds, fts+" MATCH ?")
+		args = append(args, ftsMatchExpression(ftsTerms))
+	}
+
+	for _, term := range likeTerms {
+		conds = append(conds, "x.text "+likeOperator(dialect)+` ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(term)+"%")
+	}
+
+	if opts.ChannelID != "" {
+		conds = append(conds, "x.channel_id = ?")
+		args = append(args, opts.ChannelID)
+	}
+
+	if opts.TeamID != "" {
+		conds = append(conds, "x.team_id = ?")
+		args = append(args, opts.TeamID)
+	}
+
+	if opts.User != "" {
+		user := strings.TrimPrefix(opts.User, "@")
+		conds = append(conds, "x.user_id IN (SELECT id FROM users WHERE id = ? OR name = ? OR display_name = ? UNION SELECT CAST(? AS TEXT))")
+		args = append(args, user, user, user, user)
+	}
+
+	if !opts.From.IsZero() {
+		conds = append(conds, "CAST(x.ts AS DOUBLE PRECISION) >= ?")
+		args = append(args, unixSeconds(opts.From))
+	}
+
+	if !opts.To.IsZero() {
+		conds = append(conds, "CAST(x.ts AS DOUBLE PRECISION) < ?")
+		args = append(args, unixSeconds(opts.To))
+	}
+
+	if len(conds) == 0 {
+		conds = append(conds, "1 = 1")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT '%s' AS kind, x.channel_id, COALESCE(c.name, ''), x.team_id, x.ts AS ts, COALESCE(%s, ''),
+		       COALESCE(x.user_id, ''), COALESCE(u.name, ''), COALESCE(u.real_name, ''),
+		       COALESCE(u.display_name, ''), COALESCE(u.tz, ''),
+		       COALESCE(x.text, ''), %s, %s AS rank
+		FROM %s
+		LEFT JOIN channels c ON c.id = x.channel_id
+		LEFT JOIN users u ON u.id = x.user_id
+		WHERE %s`, s.kind, s.threadTS, snippet, rank, from, strings.Join(conds, " AND "))
+
+	return query, args
+}
+
+func ftsMatchExpression(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// highlightSnippet builds a snippet around the first matching term for
+// results that did not come from the full-text index.
+func highlightSnippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+
+	start := -1
+	for _, term := range terms {
+		if i := indexRunes(lower, []rune(strings.ToLower(term))); i >= 0 && (start < 0 || i < start) {
+			start = i
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	from := start - 30
+	if from < 0 {
+		from = 0
+	}
+	to := start + 60
+	if to > len(runes) {
+		to = len(runes)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	for i := from; i < to; {
+		matched := 0
+		for _, term := range terms {
+			termRunes := []rune(strings.ToLower(term))
+			if i+len(termRunes) <= len(lower) && string(lower[i:i+len(termRunes)]) == string(termRunes) {
+				matched = len(termRunes)
+				break
+			}
+		}
+		if matched > 0 {
+			b.WriteString("**" + string(runes[i:i+matched]) + "**")
+			i += matched
+			continue
+		}
+		b.WriteRune(runes[i])
+		i++
+	}
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+
+	return b.String()
+}
+
+func indexRunes(s, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if string(s[i:i+len(sub)]) == string(sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+// redactSearchResults redacts the text of each result and rebuilds its
+// snippet from the redacted text. The snippet cut by the search index is
+// dropped: truncation and highlight markers can split a secret so that no
+// rule matches it any more. A nil redactor leaves the results unchanged.
+func redactSearchResults(redactor *Redactor, results []SearchResult, query string) {
+	if redactor == nil {
+		return
+	}
+	terms := strings.Fields(query)
+	for i := range results {
+		results[i].Text = redactor.Redact(results[i].Text)
+		results[i].Snippet = highlightSnippet(results[i].Text, terms)
+	}
+}
+
+// WriteSearchResults prints each result with its snippet followed by the
+// thread it belongs to, showing up to contextSize replies around the match.
+// Results must already have gone through redactSearchResults.
+func (e *Exporter) WriteSearchResults(w io.Writer, results []SearchResult, contextSize int) error {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "No results found.")
+		return nil
+	}
+
+	for i, result := range results {
+		user := e.formatUserDisplay(result.UserID, result.UserName, result.UserRealName, result.UserDisplayName)
+		fmt.Fprintf(w, "%d. #%s | %s | %s", i+1, result.ChannelName, e.formatTimestamp(result.Timestamp, result.UserTZ), user)
+		if result.Kind == "reply" {
+			fmt.Fprintf(w, " | reply in thread %s", result.ThreadTS)
+		}
+		fmt.Fprintf(w, "\n   %s\n\n", indentText(strings.ReplaceAll(result.Snippet, "\n", " "), "   "))
+
+		if err := e.writeSearchContext(w, result, contextSize); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, strings.Repeat("-", 80))
+	}
+
+	return nil
+}
+
+func (e *Exporter) writeSearchContext(w io.Writer, result SearchResult, contextSize int) error {
+	threadTS := result.ThreadTS
+	if threadTS == "" {
+		threadTS = result.Timestamp
+	}
+
+	var thread *MessageWithReplies
+	err := e.db.EachMessageWithReplies(result.ChannelID, MessageFilter{ThreadTS: threadTS}, func(msg MessageWithReplies) error {
+		if thread == nil || msg.Timestamp == threadTS {
+			thread = &msg
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load thread %s: %w", threadTS, err)
+	}
+	if thread == nil {
+		return nil
+	}
+	e.redactMessage(thread)
+
+	writeLine := func(marker, ts, userID, userName, realName, displayName, userTZ, text string) {
+		user := e.formatUserDisplay(userID, userName, realName, displayName)
+		fmt.Fprintf(w, "   %s [%s | %s] %s: %s\n", marker, e.formatTimestamp(ts, userTZ), ts, user, indentText(text, "        "))
+	}
+
+	marker := " "
+	if thread.Timestamp == result.Timestamp {
+		marker = "*"
+	}
+	writeLine(marker, thread.Timestamp, thread.UserID, thread.UserName, thread.UserRealName, thread.UserDisplayName, thread.UserTZ, thread.Text)
+
+	hit := 0
+	for i, reply := range thread.Replies {
+		if reply.Timestamp == result.Timestamp {
+			hit = i
+		}
+	}
+
+	from, to := hit-contextSize, hit+contextSize+1
+	if from < 0 {
+		from = 0
+	}
+	if to > len(thread.Replies) {
+		to = len(thread.Replies)
+	}
+
+	if from > 0 {
+		fmt.Fprintf(w, "       ... %d earlier replies\n", from)
+	}
+	for _, reply := range thread.Replies[from:to] {
+		marker := " "
+		if reply.Timestamp == result.Timestamp {
+			marker = "*"
+		}
+		writeLine("  "+marker, reply.Timestamp, reply.UserID, reply.UserName, reply.UserRealName, reply.UserDisplayName, reply.UserTZ, reply.Text)
+	}
+	if to < len(thread.Replies) {
+		fmt.Fprintf(w, "       ... %d more replies\n", len(thread.Replies)-to)
+	}
+	fmt.Fprintln(w)
+
+	return nil
+}
+
+func indentText(text, indent string) string {
+	return strings.ReplaceAll(text, "\n", "\n"+indent)
+}
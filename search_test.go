@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func searchTimestamps(t *testing.T, store Store, opts SearchOptions) []string {
+	t.Helper()
+
+	results, err := store.Search(opts)
+	if err != nil {
+		t.Fatalf("search %q failed: %v", opts.Query, err)
+	}
+	var timestamps []string
+	for _, result := range results {
+		timestamps = append(timestamps, result.Timestamp)
+	}
+	return timestamps
+}
+
+func TestStoreSearchQueries(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		seedStore(t, store)
+		store.SaveMessage("1704240000.000100", "C1", "U1", "本番環境へのデプロイが完了しました", "", 0, "T1")
+		store.SaveMessage("1704240060.000100", "C1", "U2", `the "DB" migration AND NEAR(it) failed`, "", 0, "T1")
+
+		for _, tc := range []struct {
+			name string
+			opts SearchOptions
+			want []string
+		}{
+			{"every term must match", SearchOptions{Query: "deploy started"}, []string{"1704067200.000100"}},
+			{"terms in any order", SearchOptions{Query: "started deploy"}, []string{"1704067200.000100"}},
+			{"no match for one term", SearchOptions{Query: "deploy finished"}, nil},
+			{"case-insensitive", SearchOptions{Query: "LUNCH"}, []string{"1704070800.000100"}},
+			{"japanese", SearchOptions{Query: "デプロイ"}, []string{"1704240000.000100"}},
+			{"short japanese term", SearchOptions{Query: "本番"}, []string{"1704240000.000100"}},
+			{"short term", SearchOptions{Query: "DB"}, []string{"1704240060.000100"}},
+			{"quotes", SearchOptions{Query: `"DB"`}, []string{"1704240060.000100"}},
+			{"query syntax is literal", SearchOptions{Query: "NEAR(it)"}, []string{"1704240060.000100"}},
+			{"operators are literal", SearchOptions{Query: "migration AND"}, []string{"1704240060.000100"}},
+			{"wildcards are literal", SearchOptions{Query: "%"}, nil},
+			{"user", SearchOptions{Query: "thanks", User: "@alice"}, []string{"1704067320.000100"}},
+			{"other user", SearchOptions{Query: "thanks", User: "bob"}, nil},
+			{"team", SearchOptions{Query: "other channel", TeamID: "T2"}, []string{"1704067200.000100"}},
+			{"from", SearchOptions{Query: "day", From: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}, []string{"1704153600.000100"}},
+			{"to is exclusive", SearchOptions{Query: "next", To: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}, nil},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				if got := searchTimestamps(t, store, tc.opts); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+					t.Errorf("search = %v, want %v", got, tc.want)
+				}
+			})
+		}
+
+		// Results are limited, 20 by default.
+		for i := 0; i < 25; i++ {
+			store.SaveMessage(fmt.Sprintf("%d.000100", 1704300000+i), "C1", "U1", "status update", "", 0, "T1")
+		}
+		if got := searchTimestamps(t, store, SearchOptions{Query: "status"}); len(got) != 20 {
+			t.Errorf("default limit returned %d results, want 20", len(got))
+		}
+		if got := searchTimestamps(t, store, SearchOptions{Query: "status", Limit: 3}); len(got) != 3 {
+			t.Errorf("limit 3 returned %d results", len(got))
+		}
+	})
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	for _, tc := range []struct {
+		text  string
+		terms []string
+		want  string
+	}{
+		{"Deploy started", []string{"deploy"}, "**Deploy** started"},
+		{"deploy and redeploy", []string{"DEPLOY"}, "**deploy** and re**deploy**"},
+		{"no match here", []string{"xyz"}, "no match here"},
+		{"本番環境へのデプロイ", []string{"デプロイ"}, "本番環境への**デプロイ**"},
+		{strings.Repeat("a", 40) + " needle " + strings.Repeat("b", 80), []string{"needle"},
+			"…" + strings.Repeat("a", 29) + " **needle** " + strings.Repeat("b", 53) + "…"},
+	} {
+		if got := highlightSnippet(tc.text, tc.terms); got != tc.want {
+			t.Errorf("highlightSnippet(%q, %q) = %q, want %q", tc.text, tc.terms, got, tc.want)
+		}
+	}
+}
+
+func TestFTSMatchExpression(t *testing.T) {
+	if got := ftsMatchExpression([]string{"deploy", `say "hi"`, "NEAR(x)"}); got != `"deploy" "say ""hi""" "NEAR(x)"` {
+		t.Errorf("ftsMatchExpression = %s, want each term quoted", got)
+	}
+}
+
+func TestWriteSearchResults(t *testing.T) {
+	store := NewMemoryStore()
+	seedStore(t, store)
+	store.SaveReply("1704067380.000100", "1704067200.000100", "C1", "U2", "rollback done", "T1")
+
+	results, err := store.Search(SearchOptions{Query: "thanks"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	exporter := NewExporter(store, ExportOptions{Location: time.UTC})
+	if err := exporter.WriteSearchResults(&buf, results, 0); err != nil {
+		t.Fatalf("WriteSearchResults failed: %v", err)
+	}
+	for _, want := range []string{
+		"1. #general | 2024-01-01 00:02:00 UTC | ali (@alice) | reply in thread 1704067200.000100\n   **thanks**\n",
+		"     [2024-01-01 00:00:00 UTC | 1704067200.000100] ali (@alice): Deploy started\n",
+		"       ... 1 earlier replies\n",
+		"     * [2024-01-01 00:02:00 UTC | 1704067320.000100] ali (@alice): thanks\n",
+		"       ... 1 more replies\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("search output is missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := exporter.WriteSearchResults(&buf, nil, 2); err != nil || buf.String() != "No results found.\n" {
+		t.Errorf("no results = %q, %v", buf.String(), err)
+	}
+}
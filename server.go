@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAPIPageSize = 100
+	maxAPIPageSize     = 1000
+)
+
+// errStopIteration ends an EachMessageWithReplies walk early.
+var errStopIteration = errors.New("stop iteration")
+
+// APIServer serves a read-only JSON API over the archive.
+type APIServer struct {
+	store    Store
+	exporter *Exporter
+	location *time.Location
+	mux      *http.ServeMux
+}
+
+// NewAPIServer creates the API handler. loc is used to interpret from/to
+// parameters given without a time zone. redactor, if not nil, is applied to
+// message text and user emails in every response.
+func NewAPIServer(store Store, loc *time.Location, redactor *Redactor) *APIServer {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	s := &APIServer{
+		store:    store,
+		exporter: NewExporter(store, ExportOptions{Location: loc, Redactor: redactor}),
+		location: loc,
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/api/openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("/api/channels", s.handleChannels)
+	s.mux.HandleFunc("/api/channels/", s.handleChannel)
+	s.mux.HandleFunc("/api/users", s.handleUsers)
+	s.mux.HandleFunc("/api/search", s.handleSearch)
+	return s
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: message})
+}
+
+// writeAPIResponse encodes v and answers conditional requests: the ETag is a
+// hash of the body, and Last-Modified is when the data was last fetched.
+func writeAPIResponse(w http.ResponseWriter, r *http.Request, v interface{}, lastModified time.Time) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if etagMatches(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.IsZero() {
+		if !lastModified.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// lastModified returns the latest fetch time of the given channels, or of
+// all channels when none are given.
+func (s *APIServer) lastModified(channelIDs ...string) (time.Time, error) {
+	if len(channelIDs) == 0 {
+		channels, err := s.store.GetChannels()
+		if err != nil {
+			return time.Time{}, err
+		}
+		for id := range channels {
+			channelIDs = append(channelIDs, id)
+		}
+	}
+
+	var latest time.Time
+	for _, id := range channelIDs {
+		state, err := s.store.GetFetchState(id)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t, err := parseUpdatedAt(state.UpdatedAt); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	return latest, nil
+}
+
+// parseUpdatedAt parses fetch_state.updated_at or completed_at, stored in
+// UTC as CURRENT_TIMESTAMP text.
+func parseUpdatedAt(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano} {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid updated_at %q", value)
+}
+
+type apiChannel struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	TeamID       string `json:"team_id,omitempty"`
+	MessageCount int    `json:"message_count"`
+	LastFetched  string `json:"last_fetched,omitempty"`
+}
+
+func (s *APIServer) handleChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := s.store.GetChannels()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	teams, err := s.store.GetChannelTeams()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := make([]apiChannel, 0, len(channels))
+	var latest time.Time
+	for id, name := range channels {
+		count, err := s.store.CountMessages(id, MessageFilter{})
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		channel := apiChannel{ID: id, Name: name, TeamID: teams[id], MessageCount: count}
+
+		state, err := s.store.GetFetchState(id)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if t, err := parseUpdatedAt(state.UpdatedAt); err == nil {
+			channel.LastFetched = t.Format(time.RFC3339)
+			if t.After(latest) {
+				latest = t
+			}
+		}
+		result = append(result, channel)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	writeAPIResponse(w, r, result, latest)
+}
+
+type apiUser struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	RealName     string   `json:"real_name,omitempty"`
+	DisplayName  string   `json:"display_name,omitempty"`
+	Email        string   `json:"email,omitempty"`
+	ProfileImage string   `json:"profile_image,omitempty"`
+	TZ           string   `json:"tz,omitempty"`
+	TeamID       string   `json:"team_id,omitempty"`
+	Teams        []string `json:"teams,omitempty"`
+}
+
+func (s *APIServer) handleUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.store.GetUsers()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := make([]apiUser, 0, len(users))
+	for _, u := range users {
+		u.Email = s.exporter.options.Redactor.Redact(u.Email)
+		result = append(result, apiUser(u))
+	}
+
+	lastModified, err := s.lastModified()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeAPIResponse(w, r, result, lastModified)
+}
+
+// handleChannel routes /api/channels/{id}/messages and
+// /api/channels/{id}/threads/{ts}.
+func (s *APIServer) handleChannel(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/channels/"), "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[1] == "messages":
+		s.handleMessages(w, r, parts[0])
+	case len(parts) == 3 && parts[1] == "threads":
+		s.handleThread(w, r, parts[0], parts[2])
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found")
+	}
+}
+
+type apiReply struct {
+	TS              string `json:"ts"`
+	Time            string `json:"time"`
+	UserID          string `json:"user_id"`
+	UserName        string `json:"user_name,omitempty"`
+	UserRealName    string `json:"user_real_name,omitempty"`
+	UserDisplayName string `json:"user_display_name,omitempty"`
+	Text            string `json:"text"`
+}
+
+type apiMessage struct {
+	TS              string     `json:"ts"`
+	Time            string     `json:"time"`
+	ChannelID       string     `json:"channel_id"`
+	TeamID          string     `json:"team_id,omitempty"`
+	ThreadTS        string     `json:"thread_ts,omitempty"`
+	UserID          string     `json:"user_id"`
+	UserName        string     `json:"user_name,omitempty"`
+	UserRealName    string     `json:"user_real_name,omitempty"`
+	UserDisplayName string     `json:"user_display_name,omitempty"`
+	Text            string     `json:"text"`
+	ReplyCount      int        `json:"reply_count"`
+	Replies         []apiReply `json:"replies"`
+}
+
+func (s *APIServer) apiMessage(msg MessageWithReplies) apiMessage {
+	s.exporter.redactMessage(&msg)
+	m := apiMessage{
+		TS:              msg.Timestamp,
+		Time:            s.exporter.formatISOTimestamp(msg.Timestamp),
+		ChannelID:       msg.ChannelID,
+		TeamID:          msg.TeamID,
+		ThreadTS:        msg.ThreadTS,
+		UserID:          msg.UserID,
+		UserName:        msg.UserName,
+		UserRealName:    msg.UserRealName,
+		UserDisplayName: msg.UserDisplayName,
+		Text:            msg.Text,
+		ReplyCount:      msg.ReplyCount,
+		Replies:         make([]apiReply, 0, len(msg.Replies)),
+	}
+	for _, reply := range msg.Replies {
+		m.Replies = append(m.Replies, apiReply{
+			TS:              reply.Timestamp,
+			Time:            s.exporter.formatISOTimestamp(reply.Timestamp),
+			UserID:          reply.UserID,
+			UserName:        reply.UserName,
+			UserRealName:    reply.UserRealName,
+			UserDisplayName: reply.UserDisplayName,
+			Text:            reply.Text,
+		})
+	}
+	return m
+}
+
+type apiMessagePage struct {
+	ChannelID   string       `json:"channel_id"`
+	ChannelName string       `json:"channel_name"`
+	Messages    []apiMessage `json:"messages"`
+	NextCursor  string       `json:"next_cursor,omitempty"`
+}
+
+// handleMessages returns a channel's threads oldest first. The cursor is
+// the ts of the last message on the previous page.
+func (s *APIServer) handleMessages(w http.ResponseWriter, r *http.Request, channelID string) {
+	name, ok := s.channelName(w, channelID)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	filter, err := buildMessageFilter(q.Get("from"), q.Get("to"), q.Get("user"), "", q.Get("contains"), 0, s.location)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, err := queryLimit(q.Get("limit"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cursor := q.Get("cursor")
+	if cursor != "" {
+		after, err := parseSlackTimestamp(cursor)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		if after.After(filter.From) {
+			filter.From = after
+		}
+	}
+
+	page := apiMessagePage{ChannelID: channelID, ChannelName: name, Messages: []apiMessage{}}
+	err = s.store.EachMessageWithReplies(channelID, filter, func(msg MessageWithReplies) error {
+		if cursor != "" && msg.Timestamp <= cursor {
+			return nil
+		}
+		if len(page.Messages) == limit {
+			page.NextCursor = page.Messages[limit-1].TS
+			return errStopIteration
+		}
+		page.Messages = append(page.Messages, s.apiMessage(msg))
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	lastModified, err := s.lastModified(channelID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeAPIResponse(w, r, page, lastModified)
+}
+
+func (s *APIServer) handleThread(w http.ResponseWriter, r *http.Request, channelID, threadTS string) {
+	if _, ok := s.channelName(w, channelID); !ok {
+		return
+	}
+
+	var thread *MessageWithReplies
+	err := s.store.EachMessageWithReplies(channelID, MessageFilter{ThreadTS: threadTS}, func(msg MessageWithReplies) error {
+		if thread == nil || msg.Timestamp == threadTS {
+			thread = &msg
+		}
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if thread == nil {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("thread %s not found in channel %s", threadTS, channelID))
+		return
+	}
+
+	lastModified, err := s.lastModified(channelID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeAPIResponse(w, r, s.apiMessage(*thread), lastModified)
+}
+
+type apiSearchResult struct {
+	Kind        string  `json:"kind"`
+	ChannelID   string  `json:"channel_id"`
+	ChannelName string  `json:"channel_name"`
+	TeamID      string  `json:"team_id,omitempty"`
+	TS          string  `json:"ts"`
+	Time        string  `json:"time"`
+	ThreadTS    string  `json:"thread_ts,omitempty"`
+	UserID      string  `json:"user_id"`
+	UserName    string  `json:"user_name,omitempty"`
+	Text        string  `json:"text"`
+	Snippet     string  `json:"snippet"`
+	Rank        float64 `json:"rank"`
+}
+
+func (s *APIServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if strings.TrimSpace(q.Get("q")) == "" {
+		writeAPIError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	filter, err := buildMessageFilter(q.Get("from"), q.Get("to"), q.Get("user"), "", "", 0, s.location)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, err := queryLimit(q.Get("limit"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := s.store.Search(SearchOptions{
+		Query:     q.Get("q"),
+		ChannelID: q.Get("channel"),
+		TeamID:    q.Get("team"),
+		User:      filter.User,
+		From:      filter.From,
+		To:        filter.To,
+		Limit:     limit,
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	redactSearchResults(s.exporter.options.Redactor, results, q.Get("q"))
+
+	response := make([]apiSearchResult, 0, len(results))
+	for _, result := range results {
+		response = append(response, apiSearchResult{
+			Kind:        result.Kind,
+			ChannelID:   result.ChannelID,
+			ChannelName: result.ChannelName,
+			TeamID:      result.TeamID,
+			TS:          result.Timestamp,
+			Time:        s.exporter.formatISOTimestamp(result.Timestamp),
+			ThreadTS:    result.ThreadTS,
+			UserID:      result.UserID,
+			UserName:    result.UserName,
+			Text:        result.Text,
+			Snippet:     result.Snippet,
+			Rank:        result.Rank,
+		})
+	}
+
+	var channelIDs []string
+	if channel := q.Get("channel"); channel != "" {
+		channelIDs = append(channelIDs, channel)
+	}
+	lastModified, err := s.lastModified(channelIDs...)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeAPIResponse(w, r, response, lastModified)
+}
+
+func (s *APIServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPISpec))
+}
+
+func (s *APIServer) channelName(w http.ResponseWriter, channelID string) (string, bool) {
+	channels, err := s.store.GetChannels()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return "", false
+	}
+	name, ok := channels[channelID]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("channel %s not found", channelID))
+		return "", false
+	}
+	return name, true
+}
+
+func queryLimit(value string) (int, error) {
+	if value == "" {
+		return defaultAPIPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit %q", value)
+	}
+	if limit > maxAPIPageSize {
+		limit = maxAPIPageSize
+	}
+	return limit, nil
+}
+
+// logRequests logs each API request with its status and duration.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Printf("%s %s %d %s", r.Method, r.URL.RequestURI(), rec.status, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -2,61 +2,196 @@ package main
 
 import (
 	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/slack-go/slack"
 	"golang.org/x/time/rate"
 )
 
+// SlackAPI is the part of the Slack Web API used by MessageProcessor, daemon
+// mode and the channel and doctor checks. SlackClient implements it against
+// Slack; the fakeslack package provides a local server it can be pointed at
+// instead.
+type SlackAPI interface {
+	GetChannelInfo(ctx context.Context, channelID string) (*slack.Channel, error)
+	GetConversationHistory(ctx context.Context, channelID string, cursor string, oldest string, limit int) (*slack.GetConversationHistoryResponse, error)
+	GetConversationReplies(ctx context.Context, channelID, timestamp string, cursor string, limit int) ([]slack.Message, bool, string, error)
+	GetUserInfo(ctx context.Context, userID string) (*slack.User, error)
+	GetConversations(ctx context.Context, types []string, excludeArchived bool) ([]slack.Channel, error)
+	AuthTest(ctx context.Context) (*slack.AuthTestResponse, error)
+	JoinConversation(ctx context.Context, channelID string) (*slack.Channel, error)
+}
+
+// maxRateLimitRetries is how many times a request answered with HTTP 429 is
+// retried, after waiting for the Retry-After period, before giving up.
+const maxRateLimitRetries = 5
+
 type SlackClient struct {
-	client  *slack.Client
-	limiter *rate.Limiter
+	client     *slack.Client
+	limiter    *rate.Limiter
+	maxRetries int
+	scopes     *scopeCapture
 }
 
-func NewSlackClient(token string) *SlackClient {
-	client := slack.New(token)
+// RateLimits configures client-side pacing and 429 retries.
+type RateLimits struct {
+	RequestsPerSecond float64
+	Burst             int
+	// MaxRetries is a pointer so that 0, which disables retries, can be
+	// told apart from not setting it.
+	MaxRetries *int
+}
+
+// NewSlackClient creates a client for token. Options are passed to the
+// underlying slack client, e.g. slack.OptionAPIURL to target a fake server.
+func NewSlackClient(token string, options ...slack.Option) *SlackClient {
+	client := slack.New(token, options...)
 	limiter := rate.NewLimiter(rate.Every(time.Second), 1)
 
 	return &SlackClient{
-		client:  client,
-		limiter: limiter,
+		client:     client,
+		limiter:    limiter,
+		maxRetries: maxRateLimitRetries,
+	}
+}
+
+// SetRateLimits replaces the default of one request per second and
+// maxRateLimitRetries retries. Zero rates and bursts and a nil MaxRetries
+// keep the current setting.
+func (sc *SlackClient) SetRateLimits(limits RateLimits) {
+	if limits.RequestsPerSecond > 0 {
+		sc.limiter.SetLimit(rate.Limit(limits.RequestsPerSecond))
+	}
+	if limits.Burst > 0 {
+		sc.limiter.SetBurst(limits.Burst)
+	}
+	if limits.MaxRetries != nil && *limits.MaxRetries >= 0 {
+		sc.maxRetries = *limits.MaxRetries
+	}
+}
+
+// GrantedScopes returns the OAuth scopes of the token as reported in the
+// X-OAuth-Scopes header of the last response. ok is false until a response
+// carrying the header has been seen, e.g. for clients built without
+// newSlackClient.
+func (sc *SlackClient) GrantedScopes() (scopes []string, ok bool) {
+	if sc.scopes == nil {
+		return nil, false
+	}
+	return sc.scopes.get()
+}
+
+// scopeCapture remembers the X-OAuth-Scopes header Slack sends with every
+// Web API response.
+type scopeCapture struct {
+	next http.RoundTripper
+
+	mu     sync.Mutex
+	scopes []string
+	seen   bool
+}
+
+func (c *scopeCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if header, ok := resp.Header["X-Oauth-Scopes"]; ok && len(header) > 0 {
+		var scopes []string
+		for _, scope := range strings.Split(header[0], ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+		c.mu.Lock()
+		c.scopes, c.seen = scopes, true
+		c.mu.Unlock()
 	}
+	return resp, nil
+}
+
+func (c *scopeCapture) get() ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.scopes, c.seen
 }
 
 func (sc *SlackClient) waitForRateLimit(ctx context.Context) error {
 	return sc.limiter.Wait(ctx)
 }
 
-func (sc *SlackClient) GetChannelInfo(ctx context.Context, channelID string) (*slack.Channel, error) {
-	if err := sc.waitForRateLimit(ctx); err != nil {
-		return nil, err
+// call runs fn after waiting for the rate limiter, retrying when Slack
+// responds with a rate limit error.
+func (sc *SlackClient) call(ctx context.Context, method string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		waitStart := time.Now()
+		if err := sc.waitForRateLimit(ctx); err != nil {
+			return err
+		}
+		observeDuration(metrics.slackLimiterWait, waitStart, method)
+
+		start := time.Now()
+		err := fn()
+		observeDuration(metrics.slackRequestDuration, start, method)
+
+		var rateLimited *slack.RateLimitedError
+		switch {
+		case errors.As(err, &rateLimited):
+			metrics.slackRequests.Inc(method, "rate_limited")
+			metrics.slackRateLimited.Inc(method)
+		case err != nil:
+			metrics.slackRequests.Inc(method, "error")
+		default:
+			metrics.slackRequests.Inc(method, "ok")
+		}
+
+		if rateLimited == nil || attempt >= sc.maxRetries {
+			return err
+		}
+
+		metrics.slackRetries.Inc(method)
+		log.Printf("Rate limited on %s, retrying in %s", method, rateLimited.RetryAfter)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(rateLimited.RetryAfter):
+		}
 	}
+}
 
-	channel, err := sc.client.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{
-		ChannelID: channelID,
+func (sc *SlackClient) GetChannelInfo(ctx context.Context, channelID string) (*slack.Channel, error) {
+	var channel *slack.Channel
+	err := sc.call(ctx, "conversations.info", func() (err error) {
+		channel, err = sc.client.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{
+			ChannelID: channelID,
+		})
+		return err
 	})
 	return channel, err
 }
 
-func (sc *SlackClient) GetConversationHistory(ctx context.Context, channelID string, cursor string, limit int) (*slack.GetConversationHistoryResponse, error) {
-	if err := sc.waitForRateLimit(ctx); err != nil {
-		return nil, err
-	}
-
+func (sc *SlackClient) GetConversationHistory(ctx context.Context, channelID string, cursor string, oldest string, limit int) (*slack.GetConversationHistoryResponse, error) {
 	params := &slack.GetConversationHistoryParameters{
 		ChannelID: channelID,
 		Cursor:    cursor,
+		Oldest:    oldest,
 		Limit:     limit,
 	}
 
-	return sc.client.GetConversationHistoryContext(ctx, params)
+	var resp *slack.GetConversationHistoryResponse
+	err := sc.call(ctx, "conversations.history", func() (err error) {
+		resp, err = sc.client.GetConversationHistoryContext(ctx, params)
+		return err
+	})
+	return resp, err
 }
 
 func (sc *SlackClient) GetConversationReplies(ctx context.Context, channelID, timestamp string, cursor string, limit int) ([]slack.Message, bool, string, error) {
-	if err := sc.waitForRateLimit(ctx); err != nil {
-		return nil, false, "", err
-	}
-
 	params := &slack.GetConversationRepliesParameters{
 		ChannelID: channelID,
 		Timestamp: timestamp,
@@ -64,14 +199,75 @@ func (sc *SlackClient) GetConversationReplies(ctx context.Context, channelID, ti
 		Limit:     limit,
 	}
 
-	return sc.client.GetConversationRepliesContext(ctx, params)
+	var (
+		msgs       []slack.Message
+		hasMore    bool
+		nextCursor string
+	)
+	err := sc.call(ctx, "conversations.replies", func() (err error) {
+		msgs, hasMore, nextCursor, err = sc.client.GetConversationRepliesContext(ctx, params)
+		return err
+	})
+	return msgs, hasMore, nextCursor, err
 }
 
 func (sc *SlackClient) GetUserInfo(ctx context.Context, userID string) (*slack.User, error) {
-	if err := sc.waitForRateLimit(ctx); err != nil {
-		return nil, err
+	var user *slack.User
+	err := sc.call(ctx, "users.info", func() (err error) {
+		user, err = sc.client.GetUserInfoContext(ctx, userID)
+		return err
+	})
+	return user, err
+}
+
+// GetConversations lists all conversations of the given types, following
+// pagination.
+func (sc *SlackClient) GetConversations(ctx context.Context, types []string, excludeArchived bool) ([]slack.Channel, error) {
+	params := &slack.GetConversationsParameters{
+		Types:           types,
+		ExcludeArchived: excludeArchived,
+		Limit:           200,
 	}
 
-	user, err := sc.client.GetUserInfoContext(ctx, userID)
-	return user, err
-}
\ No newline at end of file
+	var all []slack.Channel
+	for {
+		var (
+			channels   []slack.Channel
+			nextCursor string
+		)
+		err := sc.call(ctx, "conversations.list", func() (err error) {
+			channels, nextCursor, err = sc.client.GetConversationsContext(ctx, params)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, channels...)
+		if nextCursor == "" {
+			return all, nil
+		}
+		params.Cursor = nextCursor
+	}
+}
+
+// AuthTest identifies the token's workspace, user and, for bot tokens, bot.
+func (sc *SlackClient) AuthTest(ctx context.Context) (*slack.AuthTestResponse, error) {
+	var resp *slack.AuthTestResponse
+	err := sc.call(ctx, "auth.test", func() (err error) {
+		resp, err = sc.client.AuthTestContext(ctx)
+		return err
+	})
+	return resp, err
+}
+
+// JoinConversation adds the token's user to a public channel. Joining a
+// channel the user is already in succeeds.
+func (sc *SlackClient) JoinConversation(ctx context.Context, channelID string) (*slack.Channel, error) {
+	var channel *slack.Channel
+	err := sc.call(ctx, "conversations.join", func() (err error) {
+		channel, _, _, err = sc.client.JoinConversationContext(ctx, channelID)
+		return err
+	})
+	return channel, err
+}
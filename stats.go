@@ -0,0 +1,546 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsOptions selects what the stats report covers. Filter is applied per
+// thread like in exports, so replies are counted with the thread they
+// belong to.
+type StatsOptions struct {
+	ChannelID string
+	Filter    MessageFilter
+	Location  *time.Location
+}
+
+type StatsReport struct {
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Timezone string `json:"timezone"`
+
+	Messages                int      `json:"messages"`
+	Replies                 int      `json:"replies"`
+	Threads                 int      `json:"threads"`
+	MedianFirstReplySeconds *float64 `json:"median_first_reply_seconds"`
+
+	// Teams groups the totals by workspace; it is empty for archives of a
+	// single workspace fetched before teams were recorded.
+	Teams    []TeamStats    `json:"teams,omitempty"`
+	Channels []ChannelStats `json:"channels"`
+	Users    []UserStats    `json:"users"`
+	Hours    []HourStats    `json:"hours"`
+	Weeks    []WeekStats    `json:"weeks"`
+}
+
+type TeamStats struct {
+	TeamID       string `json:"team_id"`
+	TeamName     string `json:"team_name"`
+	Messages     int    `json:"messages"`
+	Replies      int    `json:"replies"`
+	Threads      int    `json:"threads"`
+	Channels     int    `json:"channels"`
+	Participants int    `json:"participants"`
+}
+
+type ChannelStats struct {
+	ChannelID               string   `json:"channel_id"`
+	ChannelName             string   `json:"channel_name"`
+	TeamID                  string   `json:"team_id,omitempty"`
+	Messages                int      `json:"messages"`
+	Replies                 int      `json:"replies"`
+	Threads                 int      `json:"threads"`
+	ThreadRatio             float64  `json:"thread_ratio"`
+	ActiveDays              int      `json:"active_days"`
+	Participants            int      `json:"participants"`
+	MedianFirstReplySeconds *float64 `json:"median_first_reply_seconds"`
+}
+
+type UserStats struct {
+	UserID     string `json:"user_id"`
+	UserName   string `json:"user_name"`
+	Messages   int    `json:"messages"`
+	Replies    int    `json:"replies"`
+	ActiveDays int    `json:"active_days"`
+	Channels   int    `json:"channels"`
+}
+
+type HourStats struct {
+	Hour  int `json:"hour"`
+	Posts int `json:"posts"`
+}
+
+type WeekStats struct {
+	WeekStart   string `json:"week_start"`
+	Messages    int    `json:"messages"`
+	Replies     int    `json:"replies"`
+	ActiveUsers int    `json:"active_users"`
+}
+
+type statsCounter struct {
+	messages, replies, threads int
+	days                       map[string]bool
+	users                      map[string]bool
+	channels                   map[string]bool
+	firstReplies               []time.Duration
+}
+
+func newStatsCounter() *statsCounter {
+	return &statsCounter{
+		days:     make(map[string]bool),
+		users:    make(map[string]bool),
+		channels: make(map[string]bool),
+	}
+}
+
+// ComputeStats walks the stored messages and replies once and aggregates
+// them per channel, per user, per hour of day and per week.
+func ComputeStats(store Store, opts StatsOptions) (*StatsReport, error) {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	channels, err := store.GetChannels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channels: %w", err)
+	}
+	if opts.ChannelID != "" {
+		name, ok := channels[opts.ChannelID]
+		if !ok {
+			return nil, fmt.Errorf("channel %s not found in database", opts.ChannelID)
+		}
+		channels = map[string]string{opts.ChannelID: name}
+	}
+
+	channelTeams, err := store.GetChannelTeams()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel teams: %w", err)
+	}
+	if opts.Filter.TeamID != "" {
+		for id := range channels {
+			if channelTeams[id] != opts.Filter.TeamID {
+				delete(channels, id)
+			}
+		}
+	}
+
+	teams, err := store.GetTeams()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get teams: %w", err)
+	}
+	teamNames := make(map[string]string, len(teams))
+	for _, team := range teams {
+		teamNames[team.ID] = team.Name
+	}
+
+	users, err := store.GetUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	userNames := make(map[string]string, len(users))
+	for _, u := range users {
+		userNames[u.ID] = u.Name
+	}
+
+	total := newStatsCounter()
+	perChannel := make(map[string]*statsCounter)
+	perTeam := make(map[string]*statsCounter)
+	perUser := make(map[string]*statsCounter)
+	perWeek := make(map[string]*statsCounter)
+	var hours [24]int
+
+	count := func(channelID, userID, ts string, reply bool) {
+		t, err := parseSlackTimestamp(ts)
+		if err != nil {
+			return
+		}
+		local := t.In(loc)
+		day := local.Format("2006-01-02")
+		week := weekStart(local).Format("2006-01-02")
+		hours[local.Hour()]++
+
+		counters := []*statsCounter{total, perChannel[channelID], counterFor(perTeam, channelTeams[channelID]), counterFor(perWeek, week)}
+		if userID != "" {
+			counters = append(counters, counterFor(perUser, userID))
+		}
+		for _, c := range counters {
+			if reply {
+				c.replies++
+			} else {
+				c.messages++
+			}
+			c.days[day] = true
+			c.channels[channelID] = true
+			if userID != "" {
+				c.users[userID] = true
+			}
+		}
+	}
+
+	for channelID := range channels {
+		perChannel[channelID] = newStatsCounter()
+		err := store.EachMessageWithReplies(channelID, opts.Filter, func(msg MessageWithReplies) error {
+			count(channelID, msg.UserID, msg.Timestamp, false)
+			for _, reply := range msg.Replies {
+				count(channelID, reply.UserID, reply.Timestamp, true)
+			}
+
+			if len(msg.Replies) > 0 {
+				total.threads++
+				perChannel[channelID].threads++
+				counterFor(perTeam, channelTeams[channelID]).threads++
+				if d, ok := firstReplyDelay(msg); ok {
+					total.firstReplies = append(total.firstReplies, d)
+					perChannel[channelID].firstReplies = append(perChannel[channelID].firstReplies, d)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read channel %s: %w", channelID, err)
+		}
+	}
+
+	report := &StatsReport{
+		Timezone:                loc.String(),
+		Messages:                total.messages,
+		Replies:                 total.replies,
+		Threads:                 total.threads,
+		MedianFirstReplySeconds: medianSeconds(total.firstReplies),
+	}
+	if !opts.Filter.From.IsZero() {
+		report.From = opts.Filter.From.In(loc).Format(time.RFC3339)
+	}
+	if !opts.Filter.To.IsZero() {
+		report.To = opts.Filter.To.In(loc).Format(time.RFC3339)
+	}
+
+	report.Teams = teamStats(perTeam, teamNames)
+
+	for channelID, c := range perChannel {
+		if c.messages == 0 {
+			continue
+		}
+		report.Channels = append(report.Channels, ChannelStats{
+			ChannelID:               channelID,
+			ChannelName:             channels[channelID],
+			TeamID:                  channelTeams[channelID],
+			Messages:                c.messages,
+			Replies:                 c.replies,
+			Threads:                 c.threads,
+			ThreadRatio:             float64(c.threads) / float64(c.messages),
+			ActiveDays:              len(c.days),
+			Participants:            len(c.users),
+			MedianFirstReplySeconds: medianSeconds(c.firstReplies),
+		})
+	}
+	sort.Slice(report.Channels, func(i, j int) bool {
+		a, b := report.Channels[i], report.Channels[j]
+		if a.Messages+a.Replies != b.Messages+b.Replies {
+			return a.Messages+a.Replies > b.Messages+b.Replies
+		}
+		return a.ChannelName < b.ChannelName
+	})
+
+	for userID, c := range perUser {
+		report.Users = append(report.Users, UserStats{
+			UserID:     userID,
+			UserName:   userNames[userID],
+			Messages:   c.messages,
+			Replies:    c.replies,
+			ActiveDays: len(c.days),
+			Channels:   len(c.channels),
+		})
+	}
+	sort.Slice(report.Users, func(i, j int) bool {
+		a, b := report.Users[i], report.Users[j]
+		if a.Messages+a.Replies != b.Messages+b.Replies {
+			return a.Messages+a.Replies > b.Messages+b.Replies
+		}
+		return a.UserID < b.UserID
+	})
+
+	for hour, posts := range hours {
+		report.Hours = append(report.Hours, HourStats{Hour: hour, Posts: posts})
+	}
+
+	report.Weeks = weekTrend(perWeek, loc)
+
+	return report, nil
+}
+
+// teamStats lists the workspaces with activity, busiest first. Rows stored
+// without a team are grouped under an empty ID, and nothing is listed if no
+// team was ever recorded.
+func teamStats(perTeam map[string]*statsCounter, teamNames map[string]string) []TeamStats {
+	var stats []TeamStats
+	known := false
+	for teamID, c := range perTeam {
+		known = known || teamID != ""
+		stats = append(stats, TeamStats{
+			TeamID:       teamID,
+			TeamName:     teamNames[teamID],
+			Messages:     c.messages,
+			Replies:      c.replies,
+			Threads:      c.threads,
+			Channels:     len(c.channels),
+			Participants: len(c.users),
+		})
+	}
+	if !known {
+		return nil
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Messages+a.Replies != b.Messages+b.Replies {
+			return a.Messages+a.Replies > b.Messages+b.Replies
+		}
+		return a.TeamID < b.TeamID
+	})
+	return stats
+}
+
+func counterFor(m map[string]*statsCounter, key string) *statsCounter {
+	c, ok := m[key]
+	if !ok {
+		c = newStatsCounter()
+		m[key] = c
+	}
+	return c
+}
+
+// firstReplyDelay returns how long the thread waited for its first reply
+// from someone other than the author.
+func firstReplyDelay(msg MessageWithReplies) (time.Duration, bool) {
+	start, err := parseSlackTimestamp(msg.Timestamp)
+	if err != nil {
+		return 0, false
+	}
+	for _, reply := range msg.Replies {
+		if reply.UserID == msg.UserID {
+			continue
+		}
+		t, err := parseSlackTimestamp(reply.Timestamp)
+		if err != nil {
+			continue
+		}
+		return t.Sub(start), true
+	}
+	return 0, false
+}
+
+func medianSeconds(durations []time.Duration) *float64 {
+	if len(durations) == 0 {
+		return nil
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	mid := len(sorted) / 2
+	median := sorted[mid]
+	if len(sorted)%2 == 0 {
+		median = (sorted[mid-1] + sorted[mid]) / 2
+	}
+	seconds := median.Seconds()
+	return &seconds
+}
+
+// weekStart returns midnight on the Monday of t's week, in t's location.
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	y, m, d := t.AddDate(0, 0, -offset).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// weekTrend lists every week between the first and last active week, so
+// quiet weeks show up as zeros.
+func weekTrend(perWeek map[string]*statsCounter, loc *time.Location) []WeekStats {
+	if len(perWeek) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(perWeek))
+	for key := range perWeek {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	first, _ := time.ParseInLocation("2006-01-02", keys[0], loc)
+	last, _ := time.ParseInLocation("2006-01-02", keys[len(keys)-1], loc)
+
+	var weeks []WeekStats
+	for week := first; !week.After(last); week = week.AddDate(0, 0, 7) {
+		key := week.Format("2006-01-02")
+		stats := WeekStats{WeekStart: key}
+		if c, ok := perWeek[key]; ok {
+			stats.Messages = c.messages
+			stats.Replies = c.replies
+			stats.ActiveUsers = len(c.users)
+		}
+		weeks = append(weeks, stats)
+	}
+	return weeks
+}
+
+func formatSeconds(seconds *float64) string {
+	if seconds == nil {
+		return "-"
+	}
+	return time.Duration(*seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// WriteText writes a human-readable report, listing at most topUsers users.
+func (r *StatsReport) WriteText(w io.Writer, topUsers int) {
+	fmt.Fprintf(w, "# Slack Activity Report\n")
+	if r.From != "" || r.To != "" {
+		fmt.Fprintf(w, "Period: %s - %s\n", r.From, r.To)
+	}
+	fmt.Fprintf(w, "Time Zone: %s\n", r.Timezone)
+	fmt.Fprintf(w, "Messages: %d, Replies: %d, Threads: %d\n", r.Messages, r.Replies, r.Threads)
+	fmt.Fprintf(w, "Median Time to First Reply: %s\n\n", formatSeconds(r.MedianFirstReplySeconds))
+
+	if len(r.Teams) > 1 {
+		fmt.Fprintf(w, "## Workspaces\n\n")
+		fmt.Fprintf(w, "%-24s %8s %8s %8s %8s %6s\n", "Workspace", "Messages", "Replies", "Threads", "Channels", "Users")
+		for _, t := range r.Teams {
+			name := t.TeamName
+			if name == "" {
+				name = t.TeamID
+			}
+			if name == "" {
+				name = "(unknown)"
+			}
+			fmt.Fprintf(w, "%-24s %8d %8d %8d %8d %6d\n", name, t.Messages, t.Replies, t.Threads, t.Channels, t.Participants)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
+	fmt.Fprintf(w, "## Channels\n\n")
+	fmt.Fprintf(w, "%-24s %8s %8s %8s %7s %6s %6s %12s\n", "Channel", "Messages", "Replies", "Threads", "Thread%", "Days", "Users", "First Reply")
+	for _, c := range r.Channels {
+		fmt.Fprintf(w, "%-24s %8d %8d %8d %6.1f%% %6d %6d %12s\n",
+			"#"+c.ChannelName, c.Messages, c.Replies, c.Threads, c.ThreadRatio*100,
+			c.ActiveDays, c.Participants, formatSeconds(c.MedianFirstReplySeconds))
+	}
+
+	fmt.Fprintf(w, "\n## Top Contributors\n\n")
+	fmt.Fprintf(w, "%-24s %8s %8s %6s %8s\n", "User", "Messages", "Replies", "Days", "Channels")
+	for i, u := range r.Users {
+		if topUsers > 0 && i >= topUsers {
+			fmt.Fprintf(w, "... and %d more\n", len(r.Users)-topUsers)
+			break
+		}
+		name := u.UserID
+		if u.UserName != "" {
+			name = "@" + u.UserName
+		}
+		fmt.Fprintf(w, "%-24s %8d %8d %6d %8d\n", name, u.Messages, u.Replies, u.ActiveDays, u.Channels)
+	}
+
+	fmt.Fprintf(w, "\n## Busiest Hours (%s)\n\n", r.Timezone)
+	max := 0
+	for _, h := range r.Hours {
+		if h.Posts > max {
+			max = h.Posts
+		}
+	}
+	for _, h := range r.Hours {
+		bar := 0
+		if max > 0 {
+			bar = h.Posts * 40 / max
+		}
+		fmt.Fprintf(w, "%02d:00 %6d %s\n", h.Hour, h.Posts, strings.Repeat("#", bar))
+	}
+
+	fmt.Fprintf(w, "\n## Weekly Trend\n\n")
+	fmt.Fprintf(w, "%-10s %8s %8s %6s\n", "Week", "Messages", "Replies", "Users")
+	for _, week := range r.Weeks {
+		fmt.Fprintf(w, "%-10s %8d %8d %6d\n", week.WeekStart, week.Messages, week.Replies, week.ActiveUsers)
+	}
+}
+
+func (r *StatsReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteCSV writes one CSV file per table of the report into dir.
+func (r *StatsReport) WriteCSV(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	tables := []struct {
+		name   string
+		header []string
+		rows   [][]string
+	}{
+		{name: "channels.csv", header: []string{"channel_id", "channel_name", "team_id", "messages", "replies", "threads", "thread_ratio", "active_days", "participants", "median_first_reply_seconds"}},
+		{name: "users.csv", header: []string{"user_id", "user_name", "messages", "replies", "active_days", "channels"}},
+		{name: "hours.csv", header: []string{"hour", "posts"}},
+		{name: "weeks.csv", header: []string{"week_start", "messages", "replies", "active_users"}},
+		{name: "teams.csv", header: []string{"team_id", "team_name", "messages", "replies", "threads", "channels", "participants"}},
+	}
+
+	for _, c := range r.Channels {
+		tables[0].rows = append(tables[0].rows, []string{
+			c.ChannelID, c.ChannelName, c.TeamID, strconv.Itoa(c.Messages), strconv.Itoa(c.Replies), strconv.Itoa(c.Threads),
+			strconv.FormatFloat(c.ThreadRatio, 'f', 4, 64), strconv.Itoa(c.ActiveDays), strconv.Itoa(c.Participants),
+			csvSeconds(c.MedianFirstReplySeconds),
+		})
+	}
+	for _, u := range r.Users {
+		tables[1].rows = append(tables[1].rows, []string{
+			u.UserID, u.UserName, strconv.Itoa(u.Messages), strconv.Itoa(u.Replies), strconv.Itoa(u.ActiveDays), strconv.Itoa(u.Channels),
+		})
+	}
+	for _, h := range r.Hours {
+		tables[2].rows = append(tables[2].rows, []string{strconv.Itoa(h.Hour), strconv.Itoa(h.Posts)})
+	}
+	for _, week := range r.Weeks {
+		tables[3].rows = append(tables[3].rows, []string{
+			week.WeekStart, strconv.Itoa(week.Messages), strconv.Itoa(week.Replies), strconv.Itoa(week.ActiveUsers),
+		})
+	}
+
+	for _, t := range r.Teams {
+		tables[4].rows = append(tables[4].rows, []string{
+			t.TeamID, t.TeamName, strconv.Itoa(t.Messages), strconv.Itoa(t.Replies), strconv.Itoa(t.Threads),
+			strconv.Itoa(t.Channels), strconv.Itoa(t.Participants),
+		})
+	}
+
+	for _, table := range tables {
+		path := filepath.Join(dir, table.name)
+		err := exportToFile(path, func(w io.Writer) error {
+			cw := csv.NewWriter(w)
+			cw.Write(table.header)
+			cw.WriteAll(table.rows)
+			return cw.Error()
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func csvSeconds(seconds *float64) string {
+	if seconds == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*seconds, 'f', 0, 64)
+}
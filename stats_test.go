@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		seedStore(t, store)
+
+		report, err := ComputeStats(store, StatsOptions{Location: time.UTC})
+		if err != nil {
+			t.Fatalf("ComputeStats failed: %v", err)
+		}
+
+		if report.Messages != 4 || report.Replies != 2 || report.Threads != 1 || report.Timezone != "UTC" {
+			t.Errorf("totals = %d messages, %d replies, %d threads in %s; want 4, 2, 1 in UTC", report.Messages, report.Replies, report.Threads, report.Timezone)
+		}
+		// bob answered alice's thread after a minute.
+		if report.MedianFirstReplySeconds == nil || *report.MedianFirstReplySeconds != 60 {
+			t.Errorf("median first reply = %v, want 60s", report.MedianFirstReplySeconds)
+		}
+
+		median := 60.0
+		wantChannels := []ChannelStats{
+			{ChannelID: "C1", ChannelName: "general", TeamID: "T1", Messages: 3, Replies: 2, Threads: 1, ThreadRatio: 1.0 / 3, ActiveDays: 2, Participants: 2, MedianFirstReplySeconds: &median},
+			{ChannelID: "C2", ChannelName: "random", TeamID: "T2", Messages: 1, ActiveDays: 1, Participants: 1},
+		}
+		if !reflect.DeepEqual(report.Channels, wantChannels) {
+			t.Errorf("channels = %+v, want %+v", report.Channels, wantChannels)
+		}
+
+		// alice and bob both posted three times; ties are broken by ID.
+		wantUsers := []UserStats{
+			{UserID: "U1", UserName: "alice", Messages: 2, Replies: 1, ActiveDays: 2, Channels: 1},
+			{UserID: "U2", UserName: "bob", Messages: 2, Replies: 1, ActiveDays: 1, Channels: 2},
+		}
+		if !reflect.DeepEqual(report.Users, wantUsers) {
+			t.Errorf("users = %+v, want %+v", report.Users, wantUsers)
+		}
+
+		if len(report.Hours) != 24 || report.Hours[0].Posts != 5 || report.Hours[1].Posts != 1 {
+			t.Errorf("hours = %+v, want 5 posts at 00:00 and 1 at 01:00", report.Hours)
+		}
+		if want := []WeekStats{{WeekStart: "2024-01-01", Messages: 4, Replies: 2, ActiveUsers: 2}}; !reflect.DeepEqual(report.Weeks, want) {
+			t.Errorf("weeks = %+v, want %+v", report.Weeks, want)
+		}
+
+		wantTeams := []TeamStats{
+			{TeamID: "T1", Messages: 3, Replies: 2, Threads: 1, Channels: 1, Participants: 2},
+			{TeamID: "T2", Messages: 1, Channels: 1, Participants: 1},
+		}
+		if !reflect.DeepEqual(report.Teams, wantTeams) {
+			t.Errorf("teams = %+v, want %+v", report.Teams, wantTeams)
+		}
+	})
+}
+
+func TestComputeStatsOptions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		seedStore(t, store)
+
+		report, err := ComputeStats(store, StatsOptions{ChannelID: "C2", Location: time.UTC})
+		if err != nil {
+			t.Fatalf("ComputeStats failed: %v", err)
+		}
+		if len(report.Channels) != 1 || report.Channels[0].ChannelID != "C2" || report.Messages != 1 {
+			t.Errorf("channel C2 = %+v", report.Channels)
+		}
+		if _, err := ComputeStats(store, StatsOptions{ChannelID: "C9"}); err == nil {
+			t.Error("unknown channel: want an error")
+		}
+
+		report, err = ComputeStats(store, StatsOptions{Filter: MessageFilter{TeamID: "T1"}, Location: time.UTC})
+		if err != nil {
+			t.Fatalf("ComputeStats failed: %v", err)
+		}
+		if len(report.Channels) != 1 || report.Channels[0].ChannelID != "C1" || len(report.Teams) != 1 {
+			t.Errorf("team T1 = %+v, %+v; want C1 only", report.Channels, report.Teams)
+		}
+
+		from := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+		report, err = ComputeStats(store, StatsOptions{Filter: MessageFilter{From: from}, Location: time.UTC})
+		if err != nil {
+			t.Fatalf("ComputeStats failed: %v", err)
+		}
+		if report.Messages != 1 || report.From != "2024-01-02T00:00:00Z" || report.MedianFirstReplySeconds != nil {
+			t.Errorf("from 2024-01-02 = %d messages from %q, median %v; want 1 message and no median", report.Messages, report.From, report.MedianFirstReplySeconds)
+		}
+
+		// Hours and weeks follow the report's time zone.
+		tokyo := time.FixedZone("JST", 9*60*60)
+		report, err = ComputeStats(store, StatsOptions{Location: tokyo})
+		if err != nil {
+			t.Fatalf("ComputeStats failed: %v", err)
+		}
+		if report.Hours[9].Posts != 5 || report.Hours[10].Posts != 1 || report.Timezone != "JST" {
+			t.Errorf("hours in JST = %+v", report.Hours)
+		}
+	})
+}
+
+func TestFirstReplyDelay(t *testing.T) {
+	msg := MessageWithReplies{
+		Timestamp: "1704067200.000000",
+		UserID:    "U1",
+		Replies: []Reply{
+			{Timestamp: "1704067230.000000", UserID: "U1"},
+			{Timestamp: "1704067290.500000", UserID: "U2"},
+			{Timestamp: "1704067300.000000", UserID: "U3"},
+		},
+	}
+	// The author's own follow-up does not count as an answer.
+	if d, ok := firstReplyDelay(msg); !ok || d != 90500*time.Millisecond {
+		t.Errorf("firstReplyDelay = %v, %v; want 90.5s", d, ok)
+	}
+
+	msg.Replies = msg.Replies[:1]
+	if _, ok := firstReplyDelay(msg); ok {
+		t.Error("thread with only self-replies has a first reply delay")
+	}
+}
+
+func TestMedianSeconds(t *testing.T) {
+	for _, tc := range []struct {
+		durations []time.Duration
+		want      float64
+	}{
+		{[]time.Duration{time.Minute}, 60},
+		{[]time.Duration{3 * time.Minute, time.Minute, 2 * time.Minute}, 120},
+		{[]time.Duration{4 * time.Minute, time.Minute, 2 * time.Minute, time.Hour}, 180},
+	} {
+		got := medianSeconds(tc.durations)
+		if got == nil || *got != tc.want {
+			t.Errorf("medianSeconds(%v) = %v, want %v", tc.durations, got, tc.want)
+		}
+	}
+	if got := medianSeconds(nil); got != nil {
+		t.Errorf("medianSeconds(nil) = %v, want nil", *got)
+	}
+
+	durations := []time.Duration{2 * time.Second, time.Second}
+	medianSeconds(durations)
+	if durations[0] != 2*time.Second {
+		t.Error("medianSeconds sorted its argument in place")
+	}
+}
+
+func TestWeekTrend(t *testing.T) {
+	// Sunday 2024-01-07 belongs to the week starting Monday 2024-01-01.
+	if got := weekStart(time.Date(2024, 1, 7, 23, 0, 0, 0, time.UTC)); !got.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("weekStart(Sunday) = %v, want the previous Monday", got)
+	}
+
+	perWeek := map[string]*statsCounter{
+		"2024-01-01": {messages: 2, users: map[string]bool{"U1": true}},
+		"2024-01-22": {replies: 1, users: map[string]bool{"U1": true, "U2": true}},
+	}
+	want := []WeekStats{
+		{WeekStart: "2024-01-01", Messages: 2, ActiveUsers: 1},
+		{WeekStart: "2024-01-08"},
+		{WeekStart: "2024-01-15"},
+		{WeekStart: "2024-01-22", Replies: 1, ActiveUsers: 2},
+	}
+	if got := weekTrend(perWeek, time.UTC); !reflect.DeepEqual(got, want) {
+		t.Errorf("weekTrend = %+v, want quiet weeks filled with zeros: %+v", got, want)
+	}
+}
+
+func TestStatsReportOutput(t *testing.T) {
+	store := NewMemoryStore()
+	seedStore(t, store)
+	report, err := ComputeStats(store, StatsOptions{Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text bytes.Buffer
+	report.WriteText(&text, 1)
+	for _, want := range []string{
+		"Messages: 4, Replies: 2, Threads: 1\n",
+		"Median Time to First Reply: 1m0s\n",
+		"## Workspaces\n",
+		"#general                        3        2        1   33.3%      2      2         1m0s\n",
+		"#random                         1        0        0    0.0%      1      1            -\n",
+		"@alice                          2        1      2        1\n... and 1 more\n",
+		"00:00      5 ########################################\n",
+		"01:00      1 ########\n",
+		"2024-01-01        4        2      2\n",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report is missing %q:\n%s", want, text.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("JSON report is invalid: %v", err)
+	}
+	if decoded["median_first_reply_seconds"] != 60.0 || decoded["channels"].([]interface{})[1].(map[string]interface{})["median_first_reply_seconds"] != nil {
+		t.Errorf("JSON medians = %v", decoded)
+	}
+
+	dir := filepath.Join(t.TempDir(), "stats")
+	if err := report.WriteCSV(dir); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	channels, err := os.ReadFile(filepath.Join(dir, "channels.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "channel_id,channel_name,team_id,messages,replies,threads,thread_ratio,active_days,participants,median_first_reply_seconds\n" +
+		"C1,general,T1,3,2,1,0.3333,2,2,60\n" +
+		"C2,random,T2,1,0,0,0.0000,1,1,\n"
+	if string(channels) != want {
+		t.Errorf("channels.csv = %q, want %q", channels, want)
+	}
+	for _, name := range []string{"users.csv", "hours.csv", "weeks.csv", "teams.csv"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was not written: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Store is the storage backend for teams, channels, users, messages,
+// replies and fetch state. Database implements it for SQLite and PostgreSQL, and
+// MemoryStore keeps everything in memory for tests and one-off runs.
+type Store interface {
+	SaveChannel(id, name, teamID string) error
+	GetChannelName(channelID string) (string, error)
+	GetChannels() (map[string]string, error)
+	// GetChannelTeams maps channel IDs to the team they were fetched from.
+	GetChannelTeams() (map[string]string, error)
+
+	SaveTeam(team Team) error
+	GetTeams() ([]Team, error)
+	// AssignTeam fills in the team of the channel's rows stored without one.
+	AssignTeam(channelID, teamID string) error
+
+	SaveUser(id, name, realName, displayName, email, profileImage, tz, teamID string) error
+	GetUsers() ([]User, error)
+
+	SaveMessage(ts, channelID, userID, text, threadTS string, replyCount int, teamID string) error
+	SaveReply(ts, threadTS, channelID, userID, text, teamID string) error
+	EachMessageWithReplies(channelID string, filter MessageFilter, fn func(MessageWithReplies) error) error
+	CountMessages(channelID string, filter MessageFilter) (int, error)
+	Search(opts SearchOptions) ([]SearchResult, error)
+
+	GetFetchState(channelID string) (FetchState, error)
+	// CompleteFetch records that a fetch of the channel finished
+	// successfully, as opposed to a page being committed.
+	CompleteFetch(channelID string) error
+	BeginBatch() (Batch, error)
+
+	// AcquireLock takes or renews the named lock for owner until ttl from
+	// now. It reports false when another owner holds an unexpired lock.
+	AcquireLock(name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLock(name, owner string) error
+	// GetLock returns the owner and expiry of the named lock, or an empty
+	// owner if nobody holds it.
+	GetLock(name string) (owner string, expiresAt time.Time, err error)
+
+	// SaveChannelJoin records that the bot joined the channel with
+	// -auto-join.
+	SaveChannelJoin(channelID string, joinedAt time.Time) error
+	GetChannelJoins() (map[string]time.Time, error)
+
+	String() string
+	Close() error
+}
+
+// Batch groups fetch writes so that a history page and the fetch state
+// describing it are persisted together.
+type Batch interface {
+	SaveMessage(ts, channelID, userID, text, threadTS string, replyCount int, teamID string) error
+	SaveReply(ts, threadTS, channelID, userID, text, teamID string) error
+	SaveUser(id, name, realName, displayName, email, profileImage, tz, teamID string) error
+	// SaveUserTeams records the teams an Enterprise Grid user belongs to.
+	SaveUserTeams(userID string, teamIDs []string) error
+	SetFetchState(state FetchState)
+	// SetCommitCheck makes every commit of the batch, including the
+	// intermediate ones of large batches, first call check and roll back
+	// with its error instead of committing if it fails.
+	SetCommitCheck(check func() error)
+	Commit() error
+	Rollback() error
+}
+
+const memoryStoreDSN = "memory:"
+
+// OpenStore opens the store selected by dsn: a postgres:// or postgresql://
+// URL opens PostgreSQL, "memory:" an in-memory store, and anything else is
+// treated as a SQLite database path.
+func OpenStore(dsn string) (Store, error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return NewPostgresDatabase(dsn)
+	case dsn == memoryStoreDSN:
+		return NewMemoryStore(), nil
+	default:
+		return NewDatabase(dsn)
+	}
+}
+
+// requireDatabase returns the SQLite database behind store for modes that
+// work on the SQLite schema directly.
+func requireDatabase(store Store, mode string) (*Database, error) {
+	db, ok := store.(*Database)
+	if !ok || db.dialect != dialectSQLite {
+		return nil, fmt.Errorf("%s mode requires a SQLite database", mode)
+	}
+	return db, nil
+}
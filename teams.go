@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Team is a Slack workspace that data in the archive was fetched from.
+// EnterpriseID is set for workspaces that are part of an Enterprise Grid
+// org.
+type Team struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Domain       string `json:"domain,omitempty"`
+	EnterpriseID string `json:"enterprise_id,omitempty"`
+}
+
+// teamFromAuthTest describes the workspace a token belongs to. The domain
+// is taken from the workspace URL, e.g. https://acme.slack.com/.
+func teamFromAuthTest(resp *slack.AuthTestResponse) Team {
+	team := Team{ID: resp.TeamID, Name: resp.Team, EnterpriseID: resp.EnterpriseID}
+	if u, err := url.Parse(resp.URL); err == nil {
+		team.Domain = strings.TrimSuffix(u.Hostname(), ".slack.com")
+	}
+	return team
+}
+
+const saveTeamSQL = `
+	INSERT INTO teams (id, name, domain, enterprise_id) VALUES (?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		name = excluded.name,
+		domain = excluded.domain,
+		enterprise_id = excluded.enterprise_id`
+
+func (d *Database) SaveTeam(team Team) error {
+	return d.exec("save_team", saveTeamSQL, team.ID, team.Name, team.Domain, team.EnterpriseID)
+}
+
+func (d *Database) GetTeams() ([]Team, error) {
+	rows, err := d.db.Query("SELECT id, name, domain, enterprise_id FROM teams ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []Team
+	for rows.Next() {
+		var team Team
+		if err := rows.Scan(&team.ID, &team.Name, &team.Domain, &team.EnterpriseID); err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
+}
+
+func (d *Database) GetChannelTeams() (map[string]string, error) {
+	rows, err := d.db.Query("SELECT id, team_id FROM channels")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := make(map[string]string)
+	for rows.Next() {
+		var id, teamID string
+		if err := rows.Scan(&id, &teamID); err != nil {
+			return nil, err
+		}
+		teams[id] = teamID
+	}
+	return teams, rows.Err()
+}
+
+// AssignTeam sets the team of the channel's messages and replies that were
+// archived before team_id was recorded.
+func (d *Database) AssignTeam(channelID, teamID string) error {
+	for _, table := range []string{"messages", "replies"} {
+		query := "UPDATE " + table + " SET team_id = ? WHERE channel_id = ? AND team_id = ''"
+		if _, err := d.db.Exec(d.rebind(query), teamID, channelID); err != nil {
+			return fmt.Errorf("failed to assign team to %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func (d *Database) getUserTeams() (map[string][]string, error) {
+	rows, err := d.db.Query("SELECT user_id, team_id FROM user_teams ORDER BY user_id, team_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := make(map[string][]string)
+	for rows.Next() {
+		var userID, teamID string
+		if err := rows.Scan(&userID, &teamID); err != nil {
+			return nil, err
+		}
+		teams[userID] = append(teams[userID], teamID)
+	}
+	return teams, rows.Err()
+}
+
+func (s *MemoryStore) SaveTeam(team Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.teams[team.ID] = team
+	return nil
+}
+
+func (s *MemoryStore) GetTeams() ([]Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	teams := make([]Team, 0, len(s.teams))
+	for _, team := range s.teams {
+		teams = append(teams, team)
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams, nil
+}
+
+func (s *MemoryStore) GetChannelTeams() (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	teams := make(map[string]string, len(s.channelTeams))
+	for id, teamID := range s.channelTeams {
+		teams[id] = teamID
+	}
+	return teams, nil
+}
+
+func (s *MemoryStore) AssignTeam(channelID, teamID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, m := range s.messages {
+		if key.channelID == channelID && m.teamID == "" {
+			m.teamID = teamID
+			s.messages[key] = m
+		}
+	}
+	for key, r := range s.replies {
+		if key.channelID == channelID && r.teamID == "" {
+			r.teamID = teamID
+			s.replies[key] = r
+		}
+	}
+	return nil
+}
+
+// resolveTeam returns the ID of the workspace given by ID, name or domain.
+func resolveTeam(store Store, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	teams, err := store.GetTeams()
+	if err != nil {
+		return "", fmt.Errorf("failed to get teams: %w", err)
+	}
+
+	var names []string
+	for _, team := range teams {
+		if team.ID == value || team.Domain == value || strings.EqualFold(team.Name, value) {
+			return team.ID, nil
+		}
+		names = append(names, fmt.Sprintf("%s (%s)", team.Name, team.ID))
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("team %s not found: no teams recorded yet, run a fetch first", value)
+	}
+	return "", fmt.Errorf("team %s not found (available: %s)", value, strings.Join(names, ", "))
+}
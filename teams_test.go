@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestTeamFromAuthTest(t *testing.T) {
+	for _, tc := range []struct {
+		resp slack.AuthTestResponse
+		want Team
+	}{
+		{slack.AuthTestResponse{TeamID: "T1", Team: "Acme", URL: "https://acme.slack.com/"}, Team{ID: "T1", Name: "Acme", Domain: "acme"}},
+		{slack.AuthTestResponse{TeamID: "T2", Team: "Grid", URL: "https://grid.enterprise.slack.com/", EnterpriseID: "E1"}, Team{ID: "T2", Name: "Grid", Domain: "grid.enterprise", EnterpriseID: "E1"}},
+		{slack.AuthTestResponse{TeamID: "T3", Team: "Test"}, Team{ID: "T3", Name: "Test"}},
+	} {
+		if got := teamFromAuthTest(&tc.resp); got != tc.want {
+			t.Errorf("teamFromAuthTest(%s) = %+v, want %+v", tc.resp.URL, got, tc.want)
+		}
+	}
+}
+
+func TestStoreTeams(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if teams, err := store.GetTeams(); err != nil || len(teams) != 0 {
+			t.Fatalf("GetTeams on an empty store = %v, %v", teams, err)
+		}
+
+		for _, team := range []Team{
+			{ID: "T2", Name: "Zeta", Domain: "zeta"},
+			{ID: "T1", Name: "Acme", Domain: "old-acme"},
+			// Saving a team again updates it.
+			{ID: "T1", Name: "Acme", Domain: "acme", EnterpriseID: "E1"},
+		} {
+			if err := store.SaveTeam(team); err != nil {
+				t.Fatalf("SaveTeam failed: %v", err)
+			}
+		}
+
+		teams, err := store.GetTeams()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Team{{ID: "T1", Name: "Acme", Domain: "acme", EnterpriseID: "E1"}, {ID: "T2", Name: "Zeta", Domain: "zeta"}}
+		if !reflect.DeepEqual(teams, want) {
+			t.Errorf("GetTeams = %+v, want %+v ordered by name", teams, want)
+		}
+	})
+}
+
+func TestStoreAssignTeam(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		// Rows archived before teams were recorded have no team.
+		store.SaveChannel("C1", "general", "")
+		store.SaveMessage("1704067200.000100", "C1", "U1", "legacy thread", "1704067200.000100", 1, "")
+		store.SaveReply("1704067260.000100", "1704067200.000100", "C1", "U2", "legacy reply", "")
+		store.SaveMessage("1704067300.000100", "C1", "U1", "already assigned", "", 0, "T9")
+		store.SaveMessage("1704067400.000100", "C2", "U1", "other channel", "", 0, "")
+
+		if err := store.AssignTeam("C1", "T1"); err != nil {
+			t.Fatalf("AssignTeam failed: %v", err)
+		}
+
+		// Only the channel's rows without a team are assigned.
+		if got := messageTimestamps(t, store, "C1", MessageFilter{TeamID: "T1"}); !reflect.DeepEqual(got, []string{"1704067200.000100"}) {
+			t.Errorf("C1 messages in T1 = %v", got)
+		}
+		if got := messageTimestamps(t, store, "C1", MessageFilter{TeamID: "T9"}); !reflect.DeepEqual(got, []string{"1704067300.000100"}) {
+			t.Errorf("C1 messages in T9 = %v, want the existing team kept", got)
+		}
+		if got := messageTimestamps(t, store, "C2", MessageFilter{TeamID: "T1"}); len(got) != 0 {
+			t.Errorf("C2 messages in T1 = %v, want none", got)
+		}
+		// Search ranks by relevance, so compare without order.
+		got := searchTimestamps(t, store, SearchOptions{Query: "legacy", TeamID: "T1"})
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, []string{"1704067200.000100", "1704067260.000100"}) {
+			t.Errorf("search in T1 = %v, want the thread and its reply", got)
+		}
+	})
+}
+
+func TestResolveTeam(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if _, err := resolveTeam(store, "acme"); err == nil || !strings.Contains(err.Error(), "no teams recorded yet") {
+			t.Errorf("resolveTeam with no teams error = %v", err)
+		}
+
+		store.SaveTeam(Team{ID: "T1", Name: "Acme Corp", Domain: "acme"})
+		store.SaveTeam(Team{ID: "T2", Name: "Beta", Domain: "beta"})
+
+		for value, want := range map[string]string{"": "", "T2": "T2", "acme": "T1", "acme corp": "T1", "BETA": "T2"} {
+			if got, err := resolveTeam(store, value); err != nil || got != want {
+				t.Errorf("resolveTeam(%q) = %q, %v; want %q", value, got, err, want)
+			}
+		}
+		if _, err := resolveTeam(store, "gamma"); err == nil || !strings.Contains(err.Error(), "available: Acme Corp (T1), Beta (T2)") {
+			t.Errorf("unknown team error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Tokenizer estimates how many LLM tokens a piece of text will consume.
+// Implementations are estimates; they only need to be stable and err on the
+// side of overcounting so chunks stay within the model's context budget.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+var tokenizers = map[string]func() Tokenizer{
+	"estimate": func() Tokenizer { return estimateTokenizer{} },
+	"chars":    func() Tokenizer { return charsTokenizer{} },
+	"words":    func() Tokenizer { return wordsTokenizer{} },
+}
+
+func NewTokenizer(name string) (Tokenizer, error) {
+	newTokenizer, ok := tokenizers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tokenizer %q (available: %s)", name, strings.Join(tokenizerNames(), ", "))
+	}
+	return newTokenizer(), nil
+}
+
+func tokenizerNames() []string {
+	names := make([]string, 0, len(tokenizers))
+	for name := range tokenizers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// estimateTokenizer counts roughly four characters per token for Latin text
+// and one token per character for CJK scripts, which BPE tokenizers rarely
+// merge.
+type estimateTokenizer struct{}
+
+func (estimateTokenizer) CountTokens(text string) int {
+	var cjk, other int
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+type charsTokenizer struct{}
+
+func (charsTokenizer) CountTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
+
+type wordsTokenizer struct{}
+
+func (wordsTokenizer) CountTokens(text string) int {
+	return (len(strings.Fields(text))*4 + 2) / 3
+}